@@ -90,6 +90,8 @@ ssh_key=${SSH_KEY:-}
 ssh_options=${SSH_OPTIONS:-}
 kubelet_config_file=${KUBELET_CONFIG_FILE:-"test/e2e_node/jenkins/default-kubelet-config.yaml"}
 instance_type=${INSTANCE_TYPE:-}
+artifacts_bucket=${ARTIFACTS_BUCKET:-}
+artifacts_retention=${ARTIFACTS_RETENTION:-"0"}
 
 # Parse the flags to pass to ginkgo
 ginkgoflags="-timeout=24h"
@@ -225,6 +227,7 @@ go build -o e2e_node_runner_remote -ldflags "$(kube::version::ldflags)" ./test/e
   --runtime-config="${runtime_config}" --image-config-dir="${image_config_dir}" --region="${region}" \
   --use-dockerized-build="${use_dockerized_build}" --instance-type="${instance_type}" \
   --target-build-arch="${target_build_arch}" \
+  --artifacts-bucket="${artifacts_bucket}" --artifacts-retention="${artifacts_retention}" \
   --extra-envs="${extra_envs}" --kubelet-config-file="${kubelet_config_file}" --test-suite="${test_suite}" \
   "${timeout_arg}" \
   2>&1 | tee -i "${artifacts}/build-log.txt"
@@ -0,0 +1,64 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/janitor"
+)
+
+func main() {
+	opts := janitor.Options{}
+	var report string
+	pflag.StringSliceVar(&opts.Regions, "regions", []string{"us-east-1"}, "AWS regions to sweep.")
+	pflag.DurationVar(&opts.TTL, "ttl", 6*time.Hour, "Resources older than this are considered leaked.")
+	pflag.StringSliceVar(&opts.NamePrefixes, "name-prefixes", []string{"cid-", "tmp-e2e-", "tmp-node-e2e-"},
+		"Prefixes of the Name tag of instances and volumes to sweep.")
+	pflag.BoolVar(&opts.IAM, "iam", false, "Also sweep roles and instance profiles under the /kubetest2/ path.")
+	pflag.BoolVar(&opts.DryRun, "dry-run", true, "Only report the resources that would be deleted, --dry-run=false deletes them.")
+	pflag.StringVar(&report, "report", "", "Write a JSON report of the swept resources to this file.")
+
+	klog.InitFlags(nil)
+	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+	pflag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result, err := janitor.Run(ctx, opts)
+	if result != nil {
+		result.Print()
+		if report != "" {
+			if err := result.WriteTo(report); err != nil {
+				klog.Errorf("unable to write report to %s: %v", report, err)
+			}
+		}
+	}
+	if err != nil {
+		klog.Fatalf("janitor failed: %v", err)
+	}
+}
@@ -2,5 +2,5 @@ package config
 
 import "embed"
 
-//go:embed ubuntu configure.sh run-kubeadm.sh run-post-install.sh al2023.sh *.yaml
+//go:embed ubuntu configure.sh run-kubeadm.sh run-post-install.sh al2023.sh max-lifetime.sh *.yaml
 var ConfigFS embed.FS
@@ -0,0 +1,26 @@
+#!/bin/bash
+set -xeu
+# installed by kubetest2-ec2 --max-lifetime: power the node off at {{EXPIRES_AT}}, the
+# instance is launched with a shutdown behavior of terminate so that it goes away
+cat > /etc/systemd/system/kubetest2-max-lifetime.service <<SERVICE
+[Unit]
+Description=Power off the node at the end of the lifetime of the kubetest2-ec2 cluster
+
+[Service]
+Type=oneshot
+ExecStart=/usr/bin/systemctl poweroff
+SERVICE
+cat > /etc/systemd/system/kubetest2-max-lifetime.timer <<TIMER
+[Unit]
+Description=End of the lifetime of the kubetest2-ec2 cluster
+
+[Timer]
+OnCalendar={{EXPIRES_AT}}
+AccuracySec=1min
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+TIMER
+systemctl daemon-reload
+systemctl enable --now kubetest2-max-lifetime.timer
@@ -111,6 +111,8 @@ if [[ ${KUBEADM_CONTROL_PLANE} == true ]]; then
   KUBERNETES_VERSION=$(kubelet --version | awk '{print $2}')
   sed -i "s|{{KUBERNETES_VERSION}}|$KUBERNETES_VERSION|g" /etc/kubernetes/kubeadm-init.yaml
   sed -i "s|{{POD_CIDR}}|$POD_CIDR|g" /etc/kubernetes/kubeadm-init.yaml
+  # empty unless the control plane is highly available, then it is the load balancer
+  sed -i "s|{{CONTROL_PLANE_ENDPOINT}}|${KUBEADM_CONTROL_PLANE_ENDPOINT:-}|g" /etc/kubernetes/kubeadm-init.yaml
 
   kubeadm init \
    --v 10 \
@@ -125,6 +127,23 @@ if [[ ${KUBEADM_CONTROL_PLANE} == true ]]; then
 else
   sed -i "s|{{BOOTSTRAP_TOKEN}}|{{KUBEADM_TOKEN}}|g" /etc/kubernetes/kubeadm-join.yaml
   sed -i "s|{{KUBEADM_CONTROL_PLANE_IP}}|$KUBEADM_CONTROL_PLANE_IP|g" /etc/kubernetes/kubeadm-join.yaml
+  if [[ "${KUBEADM_JOIN_CONTROL_PLANE:-false}" == true ]]; then
+    # additional control plane node, it fetches the certificates that the first
+    # control plane uploaded with the certificate key
+    sed -i "0,/^---$/s||controlPlane:\n  certificateKey: {{KUBEADM_CERTIFICATE_KEY}}\n---|" /etc/kubernetes/kubeadm-join.yaml
+    # the certificates are only there once kubeadm init is done on the first control plane
+    for _ in $(seq 1 20); do
+      if kubeadm join \
+       --v 10 \
+       --ignore-preflight-errors=SystemVerification \
+       --config /etc/kubernetes/kubeadm-join.yaml; then
+        exit 0
+      fi
+      kubeadm reset --force || true
+      sleep 30
+    done
+    exit 1
+  fi
   kubeadm join \
    --v 10 \
    --ignore-preflight-errors=SystemVerification \
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// archs are the architectures the nodes may have
+var archs = []string{"amd64", "arm64"}
+
+// buildArch is the architecture of --target-build-arch
+func (d *deployer) buildArch() string {
+	_, arch, _ := strings.Cut(d.BuildOptions.CommonBuildOptions.TargetBuildArch, "/")
+	return arch
+}
+
+// controlPlaneArch is the architecture of the control plane nodes
+func (d *deployer) controlPlaneArch() string {
+	if d.ControlPlaneArch != "" {
+		return d.ControlPlaneArch
+	}
+	return d.buildArch()
+}
+
+// workerArch is the architecture of the workers, and the default of node pools
+func (d *deployer) workerArch() string {
+	if d.WorkerArch != "" {
+		return d.WorkerArch
+	}
+	return d.buildArch()
+}
+
+// validateArchs checks --target-build-arch, --control-plane-arch and --worker-arch
+func (d *deployer) validateArchs() error {
+	if !slices.Contains(archs, d.buildArch()) {
+		return fmt.Errorf("unsupported --target-build-arch %q, expected linux/<arch> with an arch out of %s",
+			d.BuildOptions.CommonBuildOptions.TargetBuildArch, strings.Join(archs, ", "))
+	}
+	if !slices.Contains(archs, d.controlPlaneArch()) {
+		return fmt.Errorf("unsupported --control-plane-arch %q, expected one of %s", d.ControlPlaneArch, strings.Join(archs, ", "))
+	}
+	if !slices.Contains(archs, d.workerArch()) {
+		return fmt.Errorf("unsupported --worker-arch %q, expected one of %s", d.WorkerArch, strings.Join(archs, ", "))
+	}
+	return nil
+}
+
+// clusterArchs are the architectures of all the nodes, which need the server
+// tarball of each of them staged
+func (d *deployer) clusterArchs() []string {
+	needed := []string{d.controlPlaneArch()}
+	if len(d.nodePools) == 0 && d.NumNodes > 0 {
+		needed = append(needed, d.workerArch())
+	}
+	for _, pool := range d.nodePools {
+		needed = append(needed, pool.arch(d))
+	}
+	slices.Sort(needed)
+	return slices.Compact(needed)
+}
+
+// clusterPlatforms are the clusterArchs as build platforms, e.g. linux/arm64
+func (d *deployer) clusterPlatforms() []string {
+	var platforms []string
+	for _, arch := range d.clusterArchs() {
+		platforms = append(platforms, "linux/"+arch)
+	}
+	return platforms
+}
+
+// instanceTypeForArch swaps the default instance type of one architecture for the
+// one of the other, and leaves any other instance type alone
+func instanceTypeForArch(instanceType string, arch string) string {
+	switch {
+	case instanceType == defaultAMD64InstanceType && arch == "arm64":
+		return defaultARM64InstanceTYpe
+	case instanceType == defaultARM64InstanceTYpe && arch == "amd64":
+		return defaultAMD64InstanceType
+	}
+	return instanceType
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/build"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/options"
+)
+
+func newArchDeployer(buildArch string, controlPlaneArch string, workerArch string) *deployer {
+	return &deployer{
+		BuildOptions: &options.BuildOptions{
+			CommonBuildOptions: &build.Options{TargetBuildArch: buildArch},
+		},
+		ControlPlaneArch: controlPlaneArch,
+		WorkerArch:       workerArch,
+	}
+}
+
+func TestValidateArchs(t *testing.T) {
+	tests := []struct {
+		name             string
+		buildArch        string
+		controlPlaneArch string
+		workerArch       string
+		wantErr          bool
+	}{
+		{
+			name:      "build arch",
+			buildArch: "linux/amd64",
+		},
+		{
+			name:             "mixed",
+			buildArch:        "linux/amd64",
+			controlPlaneArch: "arm64",
+			workerArch:       "amd64",
+		},
+		{
+			name:      "no platform",
+			buildArch: "amd64",
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported build arch",
+			buildArch: "linux/s390x",
+			wantErr:   true,
+		},
+		{
+			name:             "unsupported control plane arch",
+			buildArch:        "linux/amd64",
+			controlPlaneArch: "x86_64",
+			wantErr:          true,
+		},
+		{
+			name:       "unsupported worker arch",
+			buildArch:  "linux/arm64",
+			workerArch: "aarch64",
+			wantErr:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newArchDeployer(tc.buildArch, tc.controlPlaneArch, tc.workerArch)
+			if err := d.validateArchs(); (err != nil) != tc.wantErr {
+				t.Errorf("validateArchs() returned %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestClusterArchs(t *testing.T) {
+	tests := []struct {
+		name             string
+		controlPlaneArch string
+		workerArch       string
+		numNodes         int
+		nodePools        []nodePool
+		want             []string
+	}{
+		{
+			name:     "build arch",
+			numNodes: 1,
+			want:     []string{"amd64"},
+		},
+		{
+			name:       "arm64 workers",
+			workerArch: "arm64",
+			numNodes:   1,
+			want:       []string{"amd64", "arm64"},
+		},
+		{
+			name:       "no workers",
+			workerArch: "arm64",
+			want:       []string{"amd64"},
+		},
+		{
+			name:             "arm64 control plane",
+			controlPlaneArch: "arm64",
+			workerArch:       "arm64",
+			numNodes:         1,
+			want:             []string{"arm64"},
+		},
+		{
+			// the workers of --num-nodes are replaced by the node pools
+			name:       "node pools",
+			workerArch: "arm64",
+			numNodes:   1,
+			nodePools:  []nodePool{{Name: "default"}, {Name: "x86", Arch: "amd64"}},
+			want:       []string{"amd64", "arm64"},
+		},
+		{
+			name:       "node pools of the control plane arch",
+			workerArch: "arm64",
+			numNodes:   1,
+			nodePools:  []nodePool{{Name: "x86", Arch: "amd64"}},
+			want:       []string{"amd64"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newArchDeployer("linux/amd64", tc.controlPlaneArch, tc.workerArch)
+			d.NumNodes = tc.numNodes
+			d.nodePools = tc.nodePools
+			if got := d.clusterArchs(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("clusterArchs() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstanceTypeForArch(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		arch         string
+		want         string
+	}{
+		{instanceType: defaultAMD64InstanceType, arch: "amd64", want: defaultAMD64InstanceType},
+		{instanceType: defaultAMD64InstanceType, arch: "arm64", want: defaultARM64InstanceTYpe},
+		{instanceType: defaultARM64InstanceTYpe, arch: "amd64", want: defaultAMD64InstanceType},
+		{instanceType: defaultARM64InstanceTYpe, arch: "arm64", want: defaultARM64InstanceTYpe},
+		// an instance type chosen by the user is theirs to match with the arch
+		{instanceType: "m5.large", arch: "arm64", want: "m5.large"},
+		{instanceType: "m7g.large", arch: "amd64", want: "m7g.large"},
+	}
+	for _, tc := range tests {
+		if got := instanceTypeForArch(tc.instanceType, tc.arch); got != tc.want {
+			t.Errorf("instanceTypeForArch(%s, %s) = %s, want %s", tc.instanceType, tc.arch, got, tc.want)
+		}
+	}
+}
@@ -17,7 +17,6 @@ limitations under the License.
 package deployer
 
 import (
-	"context"
 	"fmt"
 	"runtime"
 	"strings"
@@ -29,13 +28,17 @@ import (
 	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/build"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
 )
 
 func (d *deployer) Build() error {
 	klog.Info("EC2 deployer starting Build()")
 
+	ctx, cancel := newSignalContext(0)
+	defer cancel()
+
 	runner := d.NewAWSRunner()
-	_, err := runner.InitializeServices()
+	_, err := runner.InitializeServices(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to initialize AWS services : %w", err)
 	}
@@ -48,6 +51,18 @@ func (d *deployer) Build() error {
 	d.BuildOptions.CommonBuildOptions.S3Service = d.runner.s3Service
 	d.BuildOptions.CommonBuildOptions.S3Uploader = s3Uploader
 	d.BuildOptions.CommonBuildOptions.RepoRoot = d.RepoRoot
+	if err := d.validateTags(); err != nil {
+		return err
+	}
+	d.BuildOptions.CommonBuildOptions.Tagging = utils.S3Tagging(d.resourceTags())
+	// build and stage the server tarball of every architecture of the cluster
+	if err := d.validateArchs(); err != nil {
+		return err
+	}
+	if err := d.loadClusterSpec(); err != nil {
+		return err
+	}
+	d.BuildOptions.CommonBuildOptions.TargetBuildArchs = d.clusterPlatforms()
 
 	err = d.BuildOptions.Validate()
 	if err != nil {
@@ -56,7 +71,9 @@ func (d *deployer) Build() error {
 
 	// this supports the kubernetes/kubernetes build
 	klog.Info("starting to build kubernetes")
-	version, err := d.BuildOptions.Build()
+	timer := d.startPhase("build")
+	version, err := d.BuildOptions.Build(ctx)
+	timer.stop(err)
 	if err != nil {
 		return err
 	}
@@ -67,11 +84,14 @@ func (d *deployer) Build() error {
 		if strings.Contains(d.BuildOptions.CommonBuildOptions.StageLocation, "://") {
 			return fmt.Errorf("unsupported stage location, please specify the name of the s3 bucket (without s3:// prefix)")
 		}
-		_, err := d.runner.s3Service.HeadBucket(context.TODO(), &s3v2.HeadBucketInput{Bucket: awsv2.String(bucket)})
+		_, err := d.runner.s3Service.HeadBucket(ctx, &s3v2.HeadBucketInput{Bucket: awsv2.String(bucket)})
 		if err != nil {
 			return fmt.Errorf("unable to find bucket %q, %v", bucket, err)
 		}
-		if err := d.BuildOptions.Stage(version); err != nil {
+		timer := d.startPhase("stage")
+		err = d.BuildOptions.Stage(ctx, version)
+		timer.stop(err)
+		if err != nil {
 			return fmt.Errorf("error staging build: %v", err)
 		}
 		klog.Infof("staged version %s to s3 bucket %s", version, bucket)
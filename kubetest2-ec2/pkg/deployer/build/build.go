@@ -18,6 +18,7 @@ limitations under the License.
 package build
 
 import (
+	"context"
 	"fmt"
 	"k8s.io/klog/v2"
 	"os"
@@ -28,15 +29,15 @@ import (
 
 type Builder interface {
 	// Build determines how kubernetes artifacts are built from sources or existing artifacts
-	// and returns the version being built
-	Build() (string, error)
+	// and returns the version being built, until ctx is done
+	Build(ctx context.Context) (string, error)
 }
 
 type NoopBuilder struct{}
 
 var _ Builder = &NoopBuilder{}
 
-func (n *NoopBuilder) Build() (string, error) {
+func (n *NoopBuilder) Build(context.Context) (string, error) {
 	return "", nil
 }
 
@@ -17,8 +17,11 @@ limitations under the License.
 package build
 
 import (
+	"context"
 	"fmt"
 	"runtime"
+	"slices"
+	"strings"
 
 	"k8s.io/klog/v2"
 
@@ -27,8 +30,9 @@ import (
 )
 
 type MakeBuilder struct {
-	RepoRoot        string
-	TargetBuildArch string
+	RepoRoot string
+	// TargetBuildArchs are the platforms to build, e.g. linux/amd64
+	TargetBuildArchs []string
 }
 
 var _ Builder = &MakeBuilder{}
@@ -38,13 +42,14 @@ const (
 )
 
 // Build builds kubernetes with the quick-release make target
-func (m *MakeBuilder) Build() (string, error) {
-	version, err := m.buildQuickRelease()
+func (m *MakeBuilder) Build(ctx context.Context) (string, error) {
+	version, err := m.buildQuickRelease(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to build quick release: %v", err)
 	}
-	if m.TargetBuildArch != runtime.GOOS+"/"+runtime.GOARCH {
-		err = m.buildTestBinaries()
+	// the test binaries run on this machine
+	if !slices.Contains(m.TargetBuildArchs, runtime.GOOS+"/"+runtime.GOARCH) {
+		err = m.buildTestBinaries(ctx)
 		if err != nil {
 			return "", fmt.Errorf("failed to build test binaries: %v", err)
 		}
@@ -52,26 +57,27 @@ func (m *MakeBuilder) Build() (string, error) {
 	return version, err
 }
 
-func (m *MakeBuilder) buildQuickRelease() (string, error) {
+func (m *MakeBuilder) buildQuickRelease(ctx context.Context) (string, error) {
 	version, err := utils.SourceVersion(m.RepoRoot)
 	if err != nil {
 		return "", fmt.Errorf("failed to get version: %v", err)
 	}
-	cmd := exec.Command("make", target,
-		fmt.Sprintf("KUBE_BUILD_PLATFORMS=%s", m.TargetBuildArch),
+	platforms := strings.Join(m.TargetBuildArchs, " ")
+	cmd := exec.CommandContext(ctx, "make", target,
+		fmt.Sprintf("KUBE_BUILD_PLATFORMS=%s", platforms),
 		"KUBE_STATIC_OVERRIDES=kubelet")
 	cmd.SetDir(m.RepoRoot)
 	setSourceDateEpoch(m.RepoRoot, cmd)
 	exec.InheritOutput(cmd)
-	klog.Infof("running build %s using: KUBE_BUILD_PLATFORMS=%s", target, m.TargetBuildArch)
+	klog.Infof("running build %s using: KUBE_BUILD_PLATFORMS=%s", target, platforms)
 	if err = cmd.Run(); err != nil {
 		return "", err
 	}
 	return version, nil
 }
 
-func (m *MakeBuilder) buildTestBinaries() error {
-	cmd := exec.Command("make",
+func (m *MakeBuilder) buildTestBinaries(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "make",
 		fmt.Sprintf("WHAT=github.com/onsi/ginkgo/v2/ginkgo k8s.io/kubernetes/test/e2e/e2e.test k8s.io/kubernetes/cmd/kubectl"))
 	cmd.SetDir(m.RepoRoot)
 	setSourceDateEpoch(m.RepoRoot, cmd)
@@ -27,10 +27,15 @@ type Options struct {
 	StageVersion    string `flag:"~version" desc:"Specify version already in s3 bucket"`
 	TargetBuildArch string `flag:"~target-build-arch" desc:"Target architecture for the test artifacts"`
 	RunID           string `flag:"-"`
+	Tagging         string `flag:"-"`
 	S3Service       *s3v2.Client
 	S3Uploader      *s3managerv2.Uploader
 	Builder
 	Stager
+
+	// TargetBuildArchs are the platforms to build and stage, when the nodes need more
+	// than TargetBuildArch
+	TargetBuildArchs []string `flag:"-"`
 }
 
 func (o *Options) Validate() error {
@@ -39,16 +44,24 @@ func (o *Options) Validate() error {
 
 func (o *Options) implementationFromStrategy() error {
 	o.Builder = &MakeBuilder{
-		RepoRoot:        o.RepoRoot,
-		TargetBuildArch: o.TargetBuildArch,
+		RepoRoot:         o.RepoRoot,
+		TargetBuildArchs: o.targetBuildArchs(),
 	}
 	o.Stager = &S3Stager{
-		RunID:           o.RunID,
-		RepoRoot:        o.RepoRoot,
-		StageLocation:   o.StageLocation,
-		s3Service:       o.S3Service,
-		s3Uploader:      o.S3Uploader,
-		TargetBuildArch: o.TargetBuildArch,
+		RunID:            o.RunID,
+		Tagging:          o.Tagging,
+		RepoRoot:         o.RepoRoot,
+		StageLocation:    o.StageLocation,
+		s3Service:        o.S3Service,
+		s3Uploader:       o.S3Uploader,
+		TargetBuildArchs: o.targetBuildArchs(),
 	}
 	return nil
 }
+
+func (o *Options) targetBuildArchs() []string {
+	if len(o.TargetBuildArchs) > 0 {
+		return o.TargetBuildArchs
+	}
+	return []string{o.TargetBuildArch}
+}
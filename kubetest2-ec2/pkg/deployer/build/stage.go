@@ -32,31 +32,44 @@ import (
 
 type Stager interface {
 	// Stage determines how kubernetes artifacts will be staged (e.g. to say a GCS bucket)
-	// for the specified version
-	Stage(version string) error
+	// for the specified version, until ctx is done
+	Stage(ctx context.Context, version string) error
 }
 
 type NoopStager struct{}
 
 var _ Stager = &NoopStager{}
 
-func (n *NoopStager) Stage(string) error {
+func (n *NoopStager) Stage(context.Context, string) error {
 	return nil
 }
 
 type S3Stager struct {
-	StageLocation   string
-	s3Service       *s3v2.Client
-	s3Uploader      *s3managerv2.Uploader
-	TargetBuildArch string
-	RepoRoot        string
-	RunID           string
+	StageLocation string
+	s3Service     *s3v2.Client
+	s3Uploader    *s3managerv2.Uploader
+	RepoRoot      string
+	RunID         string
+	// TargetBuildArchs are the platforms to stage the server tarball of, e.g. linux/amd64
+	TargetBuildArchs []string
+	// Tagging is the URL encoded tags of the staged objects
+	Tagging string
 }
 
 var _ Stager = &S3Stager{}
 
-func (n *S3Stager) Stage(version string) error {
-	tgzFile := "kubernetes-server-" + strings.ReplaceAll(n.TargetBuildArch, "/", "-") + ".tar.gz"
+func (n *S3Stager) Stage(ctx context.Context, version string) error {
+	for _, arch := range n.TargetBuildArchs {
+		if err := n.stageTarball(ctx, version, arch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stageTarball uploads the server tarball of the platform
+func (n *S3Stager) stageTarball(ctx context.Context, version string, arch string) error {
+	tgzFile := "kubernetes-server-" + strings.ReplaceAll(arch, "/", "-") + ".tar.gz"
 	destinationKey := awsv2.String(version + "/" + tgzFile)
 	klog.Infof("uploading %s to location s3://%s/%s", tgzFile, n.StageLocation, *destinationKey)
 
@@ -83,6 +96,9 @@ func (n *S3Stager) Stage(version string) error {
 		Body:          reader,
 		ContentLength: awsv2.Int64(fileSize),
 	}
-	_, err = n.s3Uploader.Upload(context.TODO(), input)
+	if n.Tagging != "" {
+		input.Tagging = awsv2.String(n.Tagging)
+	}
+	_, err = n.s3Uploader.Upload(ctx, input)
 	return err
 }
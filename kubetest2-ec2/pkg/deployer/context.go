@@ -0,0 +1,78 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// cleanupTimeout bounds the AWS calls made to clean up after a cancelled or failed Up(),
+// they run on a fresh context as the one of Up() is already done.
+const cleanupTimeout = 10 * time.Minute
+
+// newSignalContext returns a context that is cancelled on SIGINT/SIGTERM, or
+// once the timeout elapses when it is positive.
+func newSignalContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// startUp records the cancel function of an in-flight Up() so that Down() can
+// stop it, and returns the function to call once Up() is completely done.
+func (d *deployer) startUp(cancel context.CancelFunc) func() {
+	d.upMu.Lock()
+	defer d.upMu.Unlock()
+	done := make(chan struct{})
+	d.upCancel = cancel
+	d.upDone = done
+	return func() {
+		close(done)
+	}
+}
+
+// waitForUp cancels an in-flight Up() and waits for it to finish cleaning up.
+// kubetest2 calls Down() from its own signal handler while Up() may still be
+// running, so the two must not race on the same instances.
+func (d *deployer) waitForUp() {
+	d.upMu.Lock()
+	cancel, done := d.upCancel, d.upDone
+	d.upMu.Unlock()
+	if done == nil {
+		return
+	}
+	select {
+	case <-done:
+		return
+	default:
+	}
+	klog.Info("waiting for the in-flight Up() to be cancelled and cleaned up")
+	cancel()
+	<-done
+}
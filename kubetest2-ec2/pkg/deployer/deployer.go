@@ -25,23 +25,22 @@ import (
 	osexec "os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/octago/sflags/gen/gpflag"
 	"github.com/spf13/pflag"
 
-	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
-
 	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/kubetest2/pkg/artifacts"
-	"sigs.k8s.io/kubetest2/pkg/exec"
 	"sigs.k8s.io/kubetest2/pkg/types"
 
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/build"
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/options"
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
 )
 
 // Name is the name of the deployer
@@ -71,8 +70,10 @@ func New(opts types.Options) (types.Deployer, *pflag.FlagSet) {
 	if err != nil || !info.IsDir() {
 		k8sPath = ""
 	}
+	clusterID := "cid-" + uuid.New().String()[:8]
 	d := &deployer{
-		ClusterID:             "cid-" + uuid.New().String()[:8],
+		ClusterID:             clusterID,
+		defaultClusterID:      clusterID,
 		ExternalCloudProvider: false,
 		ExternalLoadBalancer:  false,
 		DevicePluginNvidia:    false,
@@ -82,10 +83,10 @@ func New(opts types.Options) (types.Deployer, *pflag.FlagSet) {
 			CommonBuildOptions: &build.Options{
 				RunID: opts.RunID(),
 				Builder: &build.MakeBuilder{
-					TargetBuildArch: "linux/amd64",
+					TargetBuildArchs: []string{"linux/amd64"},
 				},
 				Stager: &build.S3Stager{
-					TargetBuildArch: "linux/amd64",
+					TargetBuildArchs: []string{"linux/amd64"},
 				},
 				TargetBuildArch: "linux/amd64",
 			},
@@ -98,9 +99,22 @@ func New(opts types.Options) (types.Deployer, *pflag.FlagSet) {
 		Region:             "us-east-1",
 		NumNodes:           2,
 		logsDir:            filepath.Join(artifacts.BaseDir(), "logs"),
-		InstanceProfile:    "provider-aws-test-instance-profile",
-		RoleName:           "provider-aws-test-role",
+		InstanceProfile:    utils.DefaultInstanceProfileName,
+		RoleName:           utils.DefaultRoleName,
 		RepoRoot:           k8sPath,
+		LaunchConcurrency:  10,
+		PartialCapacity:    partialCapacityFail,
+		MinNodes:           1,
+		ControlPlaneCount:  1,
+		CapacityType:       utils.CapacityTypeOnDemand,
+		WorkerCapacityType: utils.CapacityTypeOnDemand,
+		VpcCIDR:            utils.DefaultVpcCIDR,
+		VpcZones:           3,
+		SSHTransport:       remote.TransportNative,
+		SSHCommandTimeout:  10 * time.Minute,
+		SSHVerifyHostKeys:  true,
+		ArtifactsRetention: 7 * 24 * time.Hour,
+		phases:             newPhaseRecorder(opts.RunID()),
 	}
 	// register flags and return
 	return d, bindFlags(d)
@@ -115,8 +129,6 @@ type deployer struct {
 
 	BuildOptions *options.BuildOptions
 
-	kubectlPath string
-
 	ClusterID      string `desc:"A unique name/id for the cluster."`
 	KubeconfigPath string `flag:"kubeconfig" desc:"Absolute path to existing kubeconfig for cluster"`
 	RepoRoot       string `desc:"The path to the root of the local kubernetes/kubernetes repo."`
@@ -147,186 +159,125 @@ type deployer struct {
 	NumNodes           int    `flag:"num-nodes" desc:"Number of nodes in the cluster."`
 	IPFamily           string `flag:"ip-family" desc:"IP family for cluster networking: ipv4 (default), ipv6, or dual. When ipv6 or dual is set, instances are launched with an IPv6 address and only IPv6-enabled subnets are eligible. Configuring kubeadm/kubelet for dual-stack remains the caller's responsibility via user-data."`
 
+	UpTimeout     time.Duration `desc:"Overall deadline for Up(), 0 means no deadline. A cancelled Up() cleans up what it created so far."`
+	KeepOnFailure bool          `desc:"Leave the instances of a failed Up() running for debugging instead of deleting them."`
+
+	LaunchConcurrency  int    `desc:"Maximum number of worker instances launched at the same time."`
+	PartialCapacity    string `desc:"What Up() does when only some of the --num-nodes workers come up: fail (default) or proceed."`
+	MinNodes           int    `desc:"With --partial-capacity=proceed, the minimum number of workers that must come up."`
+	CapacityType       string `desc:"Capacity type of the control plane instance: on-demand (default), spot or spot-with-fallback."`
+	WorkerCapacityType string `desc:"Capacity type of the worker instances: on-demand (default), spot or spot-with-fallback."`
+
+	ControlPlaneCount int `desc:"Number of control plane nodes. More than one brings up a highly available control plane behind a network load balancer."`
+
+	InstanceTypes       []string `desc:"Ordered list of instance types for the control plane, the next one is tried on capacity errors. Defaults to --instance-type."`
+	WorkerInstanceTypes []string `desc:"Ordered list of instance types for the workers, the next one is tried on capacity errors. Defaults to --worker-instance-type."`
+
+	VpcID            string   `flag:"vpc-id" desc:"Launch the cluster in this existing VPC instead of the default VPC."`
+	SubnetIDs        []string `flag:"subnet-ids" desc:"Existing subnets to launch the control plane in, and the workers unless --worker-subnet-ids is set. Defaults to all subnets of the VPC."`
+	WorkerSubnetIDs  []string `flag:"worker-subnet-ids" desc:"Existing subnets to launch the workers in. Defaults to --subnet-ids."`
+	SecurityGroupIDs []string `flag:"security-group-ids" desc:"Existing security groups of the instances, used as is. They must allow the cluster traffic between the nodes. Defaults to the default security group of the VPC."`
+
+	CreateVPC bool   `flag:"create-vpc" desc:"Create a VPC for the cluster, with its own subnets, internet gateway and security group, and delete it on Down()."`
+	VpcCIDR   string `flag:"vpc-cidr" desc:"IPv4 range of the VPC created with --create-vpc."`
+	VpcZones  int    `flag:"vpc-zones" desc:"Number of availability zones the VPC created with --create-vpc spans."`
+
+	SSHTransport      string        `flag:"ssh-transport" desc:"How to run commands on the nodes: native (default, pooled golang.org/x/crypto/ssh connections and SFTP) or exec (the ssh and scp binaries)."`
+	SSHJumpHost       string        `flag:"ssh-jump-host" desc:"[user@]host[:port] of a jump host to reach the nodes through with the native transport, using the key of the nodes."`
+	SSHCommandTimeout time.Duration `flag:"ssh-command-timeout" desc:"Timeout of every command run on, or copy from, the nodes. 0 means none."`
+
+	SSHVerifyHostKeys bool `flag:"ssh-verify-host-keys" desc:"Only accept the SSH host keys the nodes print on their console, recorded in <artifacts>/<cluster-id>-known_hosts. Nodes which print none have the key of their first connection recorded, with a warning. The key of --ssh-jump-host must be in ~/.ssh/known_hosts."`
+
+	PrivateNodes bool `desc:"Launch the nodes without public IP addresses and reach them through SSM Session Manager port forwarding. Needs --create-vpc, which then adds a NAT gateway, or --subnet-ids with outbound access."`
+
+	LogSpec            string `desc:"YAML file listing the commands, files and globs to collect from the nodes with the logs of the cluster. Defaults to config/logs.yaml."`
+	ConsoleScreenshots bool   `desc:"Also save a screenshot of the console of every node with the logs of the cluster."`
+
+	ArtifactsBucket    string        `desc:"S3 bucket, optionally followed by /<prefix>, to upload the artifacts directory to after dumping the logs, with an index.html of presigned links."`
+	ArtifactsRetention time.Duration `desc:"How long the artifacts uploaded to --artifacts-bucket are kept, older runs are deleted by the next upload. 0 keeps them."`
+
+	MergeKubeconfig string `flag:"merge-kubeconfig" desc:"Kubeconfig file, e.g. ~/.kube/config, to add the cluster to under a context named after --cluster-id, Down() removes it again. The kubeconfig is otherwise only written to the artifacts directory."`
+
+	ReadinessGates        []string `desc:"Readiness gates Up() waits for in order, out of apiserver, nodes, cloud-init, coredns, cni and ccm. Defaults to apiserver, nodes and cloud-init, and ccm with --external-cloud-provider. The results are written to <artifacts>/readiness.json and junit_readiness.xml."`
+	ReadinessGateTimeouts []string `desc:"Timeouts of readiness gates as <gate>=<duration>, e.g. nodes=20m. pods=<duration> applies to the gates of --readiness-pod-selectors."`
+	ReadinessPodSelectors []string `desc:"Additional readiness gates, each waiting for the pods matching <namespace>/<label selector> to be ready."`
+
+	Tags []string `flag:"tags" desc:"key=value tags of the instances, volumes, network interfaces, VPC resources, load balancers and S3 objects the deployer creates, along with run-id, job-name (from $JOB_NAME) and created-by tags. The IAM role and instance profile, shared between runs, are only tagged created-by."`
+
+	MaxLifetime time.Duration `desc:"Self-destruct safety net: the nodes power themselves off, and are terminated, once this long has passed since Up() started, and every resource is tagged with the expires-at time for the janitor. 0 means no limit."`
+
+	ClusterSpec string `desc:"YAML file listing the node pools of the cluster, each with its own count, image or operating system, instance types, user data, labels, taints and kubelet arguments. They replace the --num-nodes workers, and default to the --worker-* flags."`
+
+	ControlPlaneArch string `desc:"Architecture of the control plane nodes, amd64 or arm64. Defaults to the one of --target-build-arch."`
+	WorkerArch       string `desc:"Architecture of the workers and the default of node pools, amd64 or arm64. Defaults to the one of --target-build-arch. Build() builds and stages the server tarball of every architecture of the cluster."`
+
 	runner  *AWSRunner
 	logsDir string
+	phases  *phaseRecorder
+	// defaultClusterID is the generated ClusterID, used to tell whether --cluster-id was passed
+	defaultClusterID string
+	// expiresAt is the end of --max-lifetime, zero without it
+	expiresAt time.Time
+	// nodePools are the node pools of --cluster-spec, if any
+	nodePools []nodePool
+
+	// guards the cancellation of an in-flight Up(), see waitForUp
+	upMu     sync.Mutex
+	upCancel context.CancelFunc
+	upDone   chan struct{}
 }
 
 func (d *deployer) Down() error {
-	if err := d.DumpClusterLogs(); err != nil {
+	d.waitForUp()
+
+	ctx := context.Background()
+	if err := d.ensureClusterInventory(ctx); err != nil {
+		return fmt.Errorf("unable to find instances of cluster %s : %w", d.ClusterID, err)
+	}
+	if err := d.dumpClusterLogs(ctx); err != nil {
 		klog.Warningf("Dumping cluster logs at the start of Down() failed: %s", err)
 	}
-	for _, instance := range d.runner.instances {
-		_, err := d.runner.ec2Service.TerminateInstances(context.TODO(), &ec2v2.TerminateInstancesInput{
-			InstanceIds: []string{instance.instanceID},
-		})
-		if err != nil {
-			return fmt.Errorf("failed to delete instance %s : %w", instance.instanceID, err)
-		}
-		klog.Infof("deleted instance id: %s", instance.instanceID)
+	if err := d.terminateInstances(ctx); err != nil {
+		return err
+	}
+	if err := d.deleteLoadBalancer(ctx); err != nil {
+		return err
+	}
+	remote.CloseConnections()
+	d.runner.closeTunnels(ctx)
+	if err := d.deleteNetwork(ctx); err != nil {
+		return err
+	}
+	if err := d.unmergeKubeconfig(); err != nil {
+		klog.Warningf("unable to remove context %s from %s: %v", d.ClusterID, d.MergeKubeconfig, err)
 	}
+	removeClusterState()
 	return nil
 }
 
 func (d *deployer) Kubeconfig() (string, error) {
-	// noop deployer is specifically used with an existing cluster and KUBECONFIG
 	if d.KubeconfigPath != "" {
 		return d.KubeconfigPath, nil
 	}
-	if kconfig, ok := os.LookupEnv("KUBECONFIG"); ok {
-		return kconfig, nil
+	if state, err := loadClusterState(); d.PrivateNodes || (err == nil && state.PrivateNodes) {
+		ctx, cancel := newSignalContext(0)
+		defer cancel()
+		if err := d.downloadTunnelKubeconfig(ctx); err != nil {
+			return "", fmt.Errorf("no kubeconfig of cluster %s: %w", d.ClusterID, err)
+		}
+		return d.KubeconfigPath, nil
 	}
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
+	// the one Up of an earlier invocation downloaded
+	if _, err := os.Stat(kubeconfigPath()); err != nil {
+		return "", fmt.Errorf("no kubeconfig of cluster %s: %w", d.ClusterID, err)
 	}
-	return filepath.Join(home, ".kube", "config"), nil
+	return kubeconfigPath(), nil
 }
 
 func (d *deployer) Version() string {
 	return GitTag
 }
 
-func (d *deployer) waitForKubectlNodes() {
-	if d.kubectlPath == "" {
-		klog.Warningf("kubectl not found, cannot wait for all worker nodes to come up")
-		return
-	}
-	if d.KubeconfigPath == "" {
-		klog.Warningf("KUBECONFIG is not set, cannot wait for all worker nodes to come up")
-		return
-	}
-	args := []string{
-		d.kubectlPath,
-		"--kubeconfig",
-		d.KubeconfigPath,
-		"get",
-		"nodes",
-		"-o=name",
-	}
-	klog.Infof("Running kubectl command %v", args)
-	for i := 0; i < 30; i++ {
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.SetStderr(os.Stderr)
-		lines, err := exec.OutputLines(cmd)
-		if err != nil {
-			klog.Errorf("unable to get nodes: %s", err)
-			return
-		}
-		if len(lines) == len(d.runner.instances) {
-			klog.Infof("found %d nodes in cluster %s: %v",
-				len(lines),
-				d.ClusterID,
-				lines)
-			break
-		} else {
-			klog.Infof("waiting for %d nodes in cluster %s, found %d",
-				len(d.runner.instances),
-				d.ClusterID,
-				len(lines))
-			time.Sleep(time.Second * 15)
-		}
-	}
-}
-
-func (d *deployer) waitForKubectlNodesToBeReady() {
-	if d.kubectlPath == "" {
-		klog.Warningf("kubectl not found, cannot wait for all worker nodes to come up")
-		return
-	}
-	if d.KubeconfigPath == "" {
-		klog.Warningf("KUBECONFIG is not set, cannot wait for all worker nodes to come up")
-		return
-	}
-	args := []string{
-		d.kubectlPath,
-		"--kubeconfig",
-		d.KubeconfigPath,
-		"wait",
-		"--for=condition=Ready",
-		"nodes",
-		"--all",
-		"--timeout=300s",
-	}
-	klog.Infof("Running kubectl command %v", args)
-
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.SetStderr(os.Stderr)
-	lines, err := exec.OutputLines(cmd)
-	if err != nil {
-		klog.Errorf("unable to wait for nodes to be ready: %s\n%s\n", err, lines)
-		return
-	}
-}
-
-func (d *deployer) waitForExternalProviderPods() {
-	if d.kubectlPath == "" {
-		klog.Warningf("kubectl not found, cannot wait for all worker nodes to come up")
-		return
-	}
-	if d.KubeconfigPath == "" {
-		klog.Warningf("KUBECONFIG is not set, cannot wait for all worker nodes to come up")
-		return
-	}
-	args := []string{
-		d.kubectlPath,
-		"--kubeconfig",
-		d.KubeconfigPath,
-		"get",
-		"pod",
-		"-l",
-		"k8s-app=aws-cloud-controller-manager",
-		"-n",
-		"kube-system",
-		"-o",
-		"name",
-	}
-	klog.Infof("Running kubectl command %v", args)
-	controllerPodName := ""
-	for i := 0; i < 30; i++ {
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.SetStderr(os.Stderr)
-		lines, err := exec.OutputLines(cmd)
-		if err != nil {
-			klog.Errorf("unable to get nodes: %s", err)
-			return
-		}
-		if len(lines) >= 1 {
-			klog.Infof("found %d pods in cluster %s: %v",
-				len(lines),
-				d.ClusterID,
-				lines)
-			controllerPodName = lines[0]
-			break
-		} else {
-			klog.Infof("waiting for %d pods in cluster %s, found %d",
-				len(d.runner.instances),
-				d.ClusterID,
-				len(lines))
-			time.Sleep(time.Second * 15)
-		}
-	}
-
-	args = []string{
-		d.kubectlPath,
-		"--kubeconfig",
-		d.KubeconfigPath,
-		"wait",
-		controllerPodName,
-		"-n",
-		"kube-system",
-		"--for=condition=Ready",
-		"--timeout=300s",
-	}
-	klog.Infof("Running kubectl command %v", args)
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.SetStderr(os.Stderr)
-	_, err := exec.OutputLines(cmd)
-	if err != nil {
-		klog.Errorf("unable to wait for pod: %s", err)
-		return
-	}
-}
-
 // helper used to create & bind a flagset to the deployer
 func bindFlags(d *deployer) *pflag.FlagSet {
 	flags, err := gpflag.Parse(d)
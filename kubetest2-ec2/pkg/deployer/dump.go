@@ -17,16 +17,28 @@ limitations under the License.
 package deployer
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
+	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
 	"k8s.io/klog/v2"
 
-	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
 )
 
 func (d *deployer) DumpClusterLogs() error {
+	return d.dumpClusterLogs(context.Background())
+}
+
+func (d *deployer) dumpClusterLogs(ctx context.Context) error {
+	if err := d.ensureClusterInventory(ctx); err != nil {
+		return fmt.Errorf("unable to find instances of cluster %s : %w", d.ClusterID, err)
+	}
 	klog.Infof("copying logs to %s", d.logsDir)
 	_, err := os.Stat(d.logsDir)
 	if os.IsNotExist(err) {
@@ -41,99 +53,88 @@ func (d *deployer) DumpClusterLogs() error {
 		}
 	}
 
-	d.dumpVPCCNILogs()
-	d.dumpContainerdInstallationLogs()
-	d.dumpContainerdLogs()
-	d.dumpCloudInitLogs()
-	d.dumpKubeletLogs()
-	d.kubectlDump()
-	d.dumpJournalLogs()
-
-	return nil
-}
-
-func (d *deployer) dumpContainerdInstallationLogs() {
-	d.dumpRemoteLogs("containerd-installation", "journalctl", "-u", "containerd-installation", "--no-pager")
-}
-
-func (d *deployer) dumpContainerdLogs() {
-	d.dumpRemoteLogs("containerd", "journalctl", "-u", "containerd", "--no-pager")
-}
-
-func (d *deployer) dumpKubeletLogs() {
-	d.dumpRemoteLogs("kubelet", "journalctl", "-u", "kubelet", "--no-pager")
-}
-
-func (d *deployer) dumpJournalLogs() {
-	d.dumpRemoteLogs("journal", "journalctl", "--no-pager")
-}
-
-func (d *deployer) dumpCloudInitLogs() {
-	d.dumpRemoteLogs("cloud-init", "cat", "/var/log/cloud-init.log")
-	d.dumpRemoteLogs("cloud-init-output", "cat", "/var/log/cloud-init-output.log")
-}
-
-func (d *deployer) kubectlDump() {
-	d.dumpRemoteLogs("cluster-info",
-		"kubectl",
-		"--kubeconfig",
-		"/etc/kubernetes/admin.conf",
-		"cluster-info",
-		"dump",
-		"--all-namespaces")
+	// first, as it does not need the nodes to be reachable
+	d.dumpConsoleLogs(ctx)
+	d.dumpSpotInterruptions(ctx)
+	// then the logs of the nodes, over SSH
+	var errs []error
+	if err := d.collectNodeLogs(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("collecting the logs of the nodes: %w", err))
+	}
+	// whatever could be collected, it is most needed when the nodes are unreachable
+	if err := d.uploadArtifacts(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("uploading the artifacts: %w", err))
+	}
+	return errors.Join(errs...)
 }
 
-func (d *deployer) dumpRemoteLogs(outputFilePrefix string, args ...string) {
+// dumpSpotInterruptions records the spot instances that EC2 reclaimed, they can
+// not be reached anymore so their logs would otherwise just be missing.
+func (d *deployer) dumpSpotInterruptions(ctx context.Context) {
 	for _, instance := range d.runner.instances {
-		file := outputFilePrefix + ".log"
-		klog.Infof("Running command to dump logs to file %s/%s: %v", instance.instanceID, file, args)
-		output, err := remote.SSH(instance.instanceID, args...)
-		if err != nil {
-			klog.Errorf("error running %v - Command failed: %s", args, instance.instanceID, output)
+		if instance.instance == nil || instance.instance.InstanceLifecycle != ec2typesv2.InstanceLifecycleTypeSpot {
+			continue
 		}
-		outfile, err := os.Create(filepath.Join(d.logsDir, instance.instanceID, file))
+		reason, err := utils.SpotInterruption(ctx, d.runner.ec2Service, instance.instanceID)
 		if err != nil {
-			klog.Errorf("failed to create %s log files : %w", outputFilePrefix, err)
-		} else {
-			defer outfile.Close()
+			klog.Errorf("unable to check spot instance %s for interruption: %v", instance.instanceID, err)
+			continue
 		}
-		_, err = outfile.WriteString(output)
-		if err != nil {
-			klog.Errorf("failed to write to %s log file: %w", outputFilePrefix, err)
+		if reason == "" {
+			continue
+		}
+		klog.Warningf("%s", reason)
+		destDir := filepath.Join(d.logsDir, instance.instanceID)
+		if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+			klog.Errorf("failed to create %s: %s", destDir, err)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(destDir, "spot-interruption.log"), []byte(reason+"\n"), 0644); err != nil {
+			klog.Errorf("failed to write spot interruption of %s: %v", instance.instanceID, err)
 		}
 	}
 }
 
-func (d *deployer) dumpVPCCNILogs() {
+// dumpConsoleLogs saves what EC2 knows about every instance: its state, its console
+// output and, with --console-screenshots, a screenshot of its console. They tell why
+// a node that never became reachable over SSH failed to boot.
+func (d *deployer) dumpConsoleLogs(ctx context.Context) {
 	for _, instance := range d.runner.instances {
-		destDir := filepath.Join(d.logsDir, instance.instanceID, "aws-cni")
-		err := os.MkdirAll(destDir, os.ModePerm)
-		if err != nil {
+		destDir := filepath.Join(d.logsDir, instance.instanceID)
+		if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
 			klog.Errorf("failed to create %s: %s", destDir, err)
 			continue
 		}
-		output, err := remote.SSH(instance.instanceID, "/opt/cni/bin/aws-cni-support.sh")
+
+		state, err := utils.DescribeInstanceState(ctx, d.runner.ec2Service, instance.instanceID)
 		if err != nil {
-			klog.Errorf("error running /opt/cni/bin/aws-cni-support.sh - Command failed: %s",
-				instance.instanceID, output)
+			klog.Errorf("unable to describe the state of %s: %v", instance.instanceID, err)
 		}
-		output, err = remote.SCP(instance.instanceID, "/var/log/eks*.tar.gz", destDir)
-		if err != nil {
-			klog.Errorf("error scp from /var/log/eks*.tar.gz failed: %s", instance.instanceID)
+		if state != nil {
+			data, err := json.MarshalIndent(state, "", "  ")
+			if err == nil {
+				err = os.WriteFile(filepath.Join(destDir, "instance-state.json"), data, 0644)
+			}
+			if err != nil {
+				klog.Errorf("failed to write the state of %s: %v", instance.instanceID, err)
+			}
 		}
-		output, err = remote.SSH(instance.instanceID, "chmod -R a+rx /var/log/pods/ && chmod -R a+rx /var/log/containers/")
+
+		output, err := utils.ConsoleOutput(ctx, d.runner.ec2Service, instance.instanceID)
 		if err != nil {
-			klog.Errorf("error chmod for pod logs : %s", instance.instanceID, output)
+			klog.Errorf("unable to get the console output of %s: %v", instance.instanceID, err)
+		} else if err := os.WriteFile(filepath.Join(destDir, "console-output.log"), []byte(output), 0644); err != nil {
+			klog.Errorf("failed to write the console output of %s: %v", instance.instanceID, err)
 		}
-		destDir = filepath.Join(d.logsDir, instance.instanceID)
-		err = os.MkdirAll(destDir, os.ModePerm)
-		if err != nil {
-			klog.Errorf("failed to create %s: %s", destDir, err)
+
+		if !d.ConsoleScreenshots {
 			continue
 		}
-		output, err = remote.SCP(instance.instanceID, "/var/log/pods/", destDir)
+		image, err := utils.ConsoleScreenshot(ctx, d.runner.ec2Service, instance.instanceID)
 		if err != nil {
-			klog.Errorf("error scp from /var/log/pods/ failed: %s", instance.instanceID)
+			klog.Errorf("unable to get a console screenshot of %s: %v", instance.instanceID, err)
+		} else if err := os.WriteFile(filepath.Join(destDir, "console-screenshot.jpg"), image, 0644); err != nil {
+			klog.Errorf("failed to write the console screenshot of %s: %v", instance.instanceID, err)
 		}
 	}
 }
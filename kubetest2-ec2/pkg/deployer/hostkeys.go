@@ -0,0 +1,69 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/kubetest2/pkg/artifacts"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// consoleHostKeysWait is how long after its launch an instance has to print its host
+// keys on the console, past it the key it presents is trusted on first use
+const consoleHostKeysWait = 5 * time.Minute
+
+// knownHostsPath is the known_hosts file of the cluster, kept with the artifacts so
+// that Down in a later invocation verifies the same keys
+func knownHostsPath(clusterID string) string {
+	return filepath.Join(artifacts.BaseDir(), clusterID+"-known_hosts")
+}
+
+// learnHostKeys records the host keys the instance printed on its console, which
+// come from the instance itself through the EC2 API rather than over the network.
+// Images which do not print them, or whose console lags, have the key presented on
+// the first connection recorded instead.
+func (a *AWSRunner) learnHostKeys(ctx context.Context, instance *awsInstance) error {
+	if !a.deployer.SSHVerifyHostKeys || remote.HasHostKeys(instance.instanceID) {
+		return nil
+	}
+	keys, err := utils.ConsoleHostKeys(ctx, a.ec2Service, instance.instanceID)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		if instance.instance != nil && time.Since(awsv2.ToTime(instance.instance.LaunchTime)) < consoleHostKeysWait {
+			return fmt.Errorf("the console output of %s has no host keys yet", instance.instanceID)
+		}
+		klog.Warningf("the console output of %s has no host keys, trusting the key of its first SSH connection", instance.instanceID)
+		remote.TrustOnFirstUse(instance.instanceID)
+		return nil
+	}
+	if err := remote.AddKnownHostKeys(instance.instanceID, keys); err != nil {
+		return err
+	}
+	klog.Infof("recorded %d host keys of %s in %s", len(keys), instance.instanceID, knownHostsPath(a.deployer.ClusterID))
+	return nil
+}
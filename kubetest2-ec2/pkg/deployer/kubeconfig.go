@@ -0,0 +1,146 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/kubetest2/pkg/artifacts"
+)
+
+// kubeconfigPath is where the kubeconfig of the cluster is written, it is only
+// merged elsewhere with --merge-kubeconfig
+func kubeconfigPath() string {
+	return filepath.Join(artifacts.BaseDir(), "kubeconfig")
+}
+
+// mergeKubeconfig adds the cluster, user and context of the kubeconfig of the cluster
+// to --merge-kubeconfig, all named after the cluster ID. The current context of an
+// existing file is left alone.
+func (d *deployer) mergeKubeconfig() error {
+	if d.MergeKubeconfig == "" || d.KubeconfigPath == "" {
+		return nil
+	}
+	source, err := clientcmd.LoadFromFile(d.KubeconfigPath)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", d.KubeconfigPath, err)
+	}
+	context, ok := source.Contexts[source.CurrentContext]
+	if !ok {
+		return fmt.Errorf("parsing %s: no current context", d.KubeconfigPath)
+	}
+	cluster, ok := source.Clusters[context.Cluster]
+	if !ok {
+		return fmt.Errorf("parsing %s: no cluster %s", d.KubeconfigPath, context.Cluster)
+	}
+	user, ok := source.AuthInfos[context.AuthInfo]
+	if !ok {
+		return fmt.Errorf("parsing %s: no user %s", d.KubeconfigPath, context.AuthInfo)
+	}
+
+	target, created, err := readKubeconfig(d.MergeKubeconfig)
+	if err != nil {
+		return err
+	}
+	context = context.DeepCopy()
+	context.Cluster = d.ClusterID
+	context.AuthInfo = d.ClusterID
+	target.Clusters[d.ClusterID] = cluster
+	target.AuthInfos[d.ClusterID] = user
+	target.Contexts[d.ClusterID] = context
+	if created {
+		target.CurrentContext = d.ClusterID
+	}
+	if err := writeKubeconfig(d.MergeKubeconfig, target); err != nil {
+		return err
+	}
+	klog.Infof("merged the kubeconfig of the cluster into %s, use it with --context %s", d.MergeKubeconfig, d.ClusterID)
+	return nil
+}
+
+// unmergeKubeconfig removes what mergeKubeconfig added to --merge-kubeconfig
+func (d *deployer) unmergeKubeconfig() error {
+	if d.MergeKubeconfig == "" {
+		return nil
+	}
+	target, created, err := readKubeconfig(d.MergeKubeconfig)
+	if err != nil || created {
+		return err
+	}
+	delete(target.Clusters, d.ClusterID)
+	delete(target.AuthInfos, d.ClusterID)
+	delete(target.Contexts, d.ClusterID)
+	if target.CurrentContext == d.ClusterID {
+		target.CurrentContext = ""
+	}
+	if err := writeKubeconfig(d.MergeKubeconfig, target); err != nil {
+		return err
+	}
+	klog.Infof("removed context %s from %s", d.ClusterID, d.MergeKubeconfig)
+	return nil
+}
+
+// readKubeconfig parses the kubeconfig at path, or returns an empty one if it does
+// not exist yet or is empty
+func readKubeconfig(path string) (*clientcmdapi.Config, bool, error) {
+	config, err := clientcmd.LoadFromFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return clientcmdapi.NewConfig(), true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return config, clientcmdapi.IsConfigEmpty(config), nil
+}
+
+// writeKubeconfig replaces the kubeconfig at path, so that a reader never sees it
+// half written. A symlink at path is followed, the file it points to is replaced.
+func writeKubeconfig(path string, config *clientcmdapi.Config) error {
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".kubeconfig-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// CreateTemp makes the file 0600 already, as a kubeconfig should be
+	return os.Rename(f.Name(), path)
+}
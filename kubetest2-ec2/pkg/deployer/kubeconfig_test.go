@@ -0,0 +1,322 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// clusterKubeconfig is the admin kubeconfig kubeadm writes on the control plane
+const clusterKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: kubernetes
+  cluster:
+    server: https://10.0.0.1:6443
+users:
+- name: kubernetes-admin
+  user:
+    token: secret
+contexts:
+- name: kubernetes-admin@kubernetes
+  context:
+    cluster: kubernetes
+    user: kubernetes-admin
+current-context: kubernetes-admin@kubernetes
+`
+
+// otherKubeconfig belongs to the user, merging must not touch its entries
+const otherKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: other
+  cluster:
+    server: https://other:6443
+users:
+- name: other
+  user:
+    token: other
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: other
+current-context: other
+`
+
+// kubeconfigNames are the sorted names of the clusters, users and contexts of the
+// kubeconfig, the contexts with their cluster and user
+func kubeconfigNames(config *clientcmdapi.Config) map[string][]string {
+	names := map[string][]string{}
+	for name := range config.Clusters {
+		names["clusters"] = append(names["clusters"], name)
+	}
+	for name := range config.AuthInfos {
+		names["users"] = append(names["users"], name)
+	}
+	for name, context := range config.Contexts {
+		names["contexts"] = append(names["contexts"], name+":"+context.Cluster+"/"+context.AuthInfo)
+	}
+	for _, list := range names {
+		sort.Strings(list)
+	}
+	return names
+}
+
+func readTestKubeconfig(t *testing.T, path string) *clientcmdapi.Config {
+	t.Helper()
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", path, err)
+	}
+	return config
+}
+
+func TestMergeKubeconfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing content of --merge-kubeconfig, none if empty
+		existing           string
+		wantNames          map[string][]string
+		wantCurrentContext string
+	}{
+		{
+			name: "new file",
+			wantNames: map[string][]string{
+				"clusters": {"test-cluster"},
+				"users":    {"test-cluster"},
+				"contexts": {"test-cluster:test-cluster/test-cluster"},
+			},
+			wantCurrentContext: "test-cluster",
+		},
+		{
+			name:     "existing file",
+			existing: otherKubeconfig,
+			wantNames: map[string][]string{
+				"clusters": {"other", "test-cluster"},
+				"users":    {"other", "test-cluster"},
+				"contexts": {"other:other/other", "test-cluster:test-cluster/test-cluster"},
+			},
+			wantCurrentContext: "other",
+		},
+		{
+			name:     "merged again",
+			existing: clusterKubeconfigOf("test-cluster", "https://old:6443"),
+			wantNames: map[string][]string{
+				"clusters": {"test-cluster"},
+				"users":    {"test-cluster"},
+				"contexts": {"test-cluster:test-cluster/test-cluster"},
+			},
+			wantCurrentContext: "test-cluster",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := &deployer{
+				ClusterID:       "test-cluster",
+				KubeconfigPath:  filepath.Join(dir, "kubeconfig"),
+				MergeKubeconfig: filepath.Join(dir, "config"),
+			}
+			if err := os.WriteFile(d.KubeconfigPath, []byte(clusterKubeconfig), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if tc.existing != "" {
+				if err := os.WriteFile(d.MergeKubeconfig, []byte(tc.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := d.mergeKubeconfig(); err != nil {
+				t.Fatalf("mergeKubeconfig() returned %v", err)
+			}
+			merged := readTestKubeconfig(t, d.MergeKubeconfig)
+			if got := kubeconfigNames(merged); !reflect.DeepEqual(got, tc.wantNames) {
+				t.Errorf("merged %v, want %v", got, tc.wantNames)
+			}
+			if merged.CurrentContext != tc.wantCurrentContext {
+				t.Errorf("current context is %q, want %q", merged.CurrentContext, tc.wantCurrentContext)
+			}
+			if cluster := merged.Clusters["test-cluster"]; cluster == nil || cluster.Server != "https://10.0.0.1:6443" {
+				t.Errorf("cluster is %v, want the one of the cluster kubeconfig", cluster)
+			}
+			if user := merged.AuthInfos["test-cluster"]; user == nil || user.Token != "secret" {
+				t.Errorf("user is %v, want the one of the cluster kubeconfig", user)
+			}
+			info, err := os.Stat(d.MergeKubeconfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != 0600 {
+				t.Errorf("merged kubeconfig has mode %o, want 600", perm)
+			}
+		})
+	}
+}
+
+func TestUnmergeKubeconfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing content of --merge-kubeconfig, none if empty
+		existing           string
+		wantNames          map[string][]string
+		wantCurrentContext string
+		wantFile           bool
+	}{
+		{
+			name: "missing file",
+		},
+		{
+			name:               "only the cluster",
+			existing:           clusterKubeconfigOf("test-cluster", "https://10.0.0.1:6443"),
+			wantNames:          map[string][]string{},
+			wantCurrentContext: "",
+			wantFile:           true,
+		},
+		{
+			name:     "other clusters",
+			existing: otherKubeconfig,
+			wantNames: map[string][]string{
+				"clusters": {"other"},
+				"users":    {"other"},
+				"contexts": {"other:other/other"},
+			},
+			wantCurrentContext: "other",
+			wantFile:           true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &deployer{
+				ClusterID:       "test-cluster",
+				MergeKubeconfig: filepath.Join(t.TempDir(), "config"),
+			}
+			if tc.existing != "" {
+				if err := os.WriteFile(d.MergeKubeconfig, []byte(tc.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := d.unmergeKubeconfig(); err != nil {
+				t.Fatalf("unmergeKubeconfig() returned %v", err)
+			}
+			if _, err := os.Stat(d.MergeKubeconfig); (err == nil) != tc.wantFile {
+				t.Fatalf("stat of the kubeconfig returned %v, want it to exist %t", err, tc.wantFile)
+			}
+			if !tc.wantFile {
+				return
+			}
+			unmerged := readTestKubeconfig(t, d.MergeKubeconfig)
+			if got := kubeconfigNames(unmerged); !reflect.DeepEqual(got, tc.wantNames) {
+				t.Errorf("kept %v, want %v", got, tc.wantNames)
+			}
+			if unmerged.CurrentContext != tc.wantCurrentContext {
+				t.Errorf("current context is %q, want %q", unmerged.CurrentContext, tc.wantCurrentContext)
+			}
+		})
+	}
+}
+
+func TestMergeKubeconfigSymlink(t *testing.T) {
+	dir := t.TempDir()
+	d := &deployer{
+		ClusterID:       "test-cluster",
+		KubeconfigPath:  filepath.Join(dir, "kubeconfig"),
+		MergeKubeconfig: filepath.Join(dir, "config"),
+	}
+	if err := os.WriteFile(d.KubeconfigPath, []byte(clusterKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// e.g. ~/.kube/config pointing into a dotfiles repository
+	target := filepath.Join(dir, "dotfiles", "kubeconfig")
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte(otherKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, d.MergeKubeconfig); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		name      string
+		run       func() error
+		wantNames map[string][]string
+	}{
+		{
+			name: "merge",
+			run:  d.mergeKubeconfig,
+			wantNames: map[string][]string{
+				"clusters": {"other", "test-cluster"},
+				"users":    {"other", "test-cluster"},
+				"contexts": {"other:other/other", "test-cluster:test-cluster/test-cluster"},
+			},
+		},
+		{
+			name: "unmerge",
+			run:  d.unmergeKubeconfig,
+			wantNames: map[string][]string{
+				"clusters": {"other"},
+				"users":    {"other"},
+				"contexts": {"other:other/other"},
+			},
+		},
+	} {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s returned %v", step.name, err)
+		}
+		info, err := os.Lstat(d.MergeKubeconfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("%s replaced the symlink %s with a file", step.name, d.MergeKubeconfig)
+		}
+		if got := kubeconfigNames(readTestKubeconfig(t, target)); !reflect.DeepEqual(got, step.wantNames) {
+			t.Errorf("after %s the target of the symlink has %v, want %v", step.name, got, step.wantNames)
+		}
+	}
+}
+
+// clusterKubeconfigOf is a kubeconfig as mergeKubeconfig writes it for the cluster
+func clusterKubeconfigOf(clusterID string, server string) string {
+	return `apiVersion: v1
+kind: Config
+clusters:
+- name: ` + clusterID + `
+  cluster:
+    server: ` + server + `
+users:
+- name: ` + clusterID + `
+  user:
+    token: old
+contexts:
+- name: ` + clusterID + `
+  context:
+    cluster: ` + clusterID + `
+    user: ` + clusterID + `
+current-context: ` + clusterID + `
+`
+}
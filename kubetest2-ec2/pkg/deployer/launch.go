@@ -0,0 +1,255 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+const (
+	// partialCapacityFail fails Up() unless every worker comes up
+	partialCapacityFail = "fail"
+	// partialCapacityProceed carries on with the workers that came up, as long as
+	// there are at least --min-nodes of them
+	partialCapacityProceed = "proceed"
+)
+
+func (d *deployer) validateLaunchOptions() error {
+	switch d.PartialCapacity {
+	case partialCapacityFail, partialCapacityProceed:
+	default:
+		return fmt.Errorf("unrecognized parameter --partial-capacity : %s", d.PartialCapacity)
+	}
+	if !slices.Contains(utils.CapacityTypes, d.CapacityType) {
+		return fmt.Errorf("unrecognized parameter --capacity-type : %s", d.CapacityType)
+	}
+	if !slices.Contains(utils.CapacityTypes, d.WorkerCapacityType) {
+		return fmt.Errorf("unrecognized parameter --worker-capacity-type : %s", d.WorkerCapacityType)
+	}
+	if d.ControlPlaneCount < 1 {
+		return fmt.Errorf("--control-plane-count must be at least 1, is %d", d.ControlPlaneCount)
+	}
+	if d.LaunchConcurrency < 1 {
+		return fmt.Errorf("--launch-concurrency must be at least 1, is %d", d.LaunchConcurrency)
+	}
+	if d.PartialCapacity == partialCapacityProceed && (d.MinNodes < 0 || d.MinNodes > d.NumNodes) {
+		return fmt.Errorf("--min-nodes must be between 0 and --num-nodes (%d), is %d", d.NumNodes, d.MinNodes)
+	}
+	return nil
+}
+
+// launchResult is the outcome of launching, or waiting for, one instance
+type launchResult struct {
+	image    utils.InternalAWSImage
+	instance *awsInstance
+	err      error
+}
+
+// launchInstances starts the first control plane node and then all the other
+// nodes, at most --launch-concurrency at a time. The other nodes join through the
+// private IP of the first control plane, or through the load balancer of a highly
+// available control plane, so it has to come first. Control plane nodes that fail
+// to launch are fatal, workers are reconciled against the --partial-capacity policy.
+func (d *deployer) launchInstances(ctx context.Context) error {
+	runner := d.runner
+	if len(runner.internalAWSImages) == 0 {
+		return fmt.Errorf("no images to launch")
+	}
+	if err := runner.prepareSubnets(ctx); err != nil {
+		return err
+	}
+	if d.ControlPlaneCount > 1 {
+		err := runner.ensureLoadBalancer(ctx)
+		d.saveClusterState()
+		if err != nil {
+			return fmt.Errorf("creating the control plane load balancer: %w", err)
+		}
+	}
+
+	controlPlane := runner.internalAWSImages[0]
+	instance, err := runner.createAWSInstance(ctx, controlPlane)
+	if instance != nil {
+		runner.instances = append(runner.instances, instance)
+		d.saveClusterState()
+	}
+	if err != nil {
+		return fmt.Errorf("error starting control plane instance for image %s : %w", controlPlane.AmiID, err)
+	}
+	runner.controlPlaneIP = instance.privateIP
+	klog.Infof("started control plane instance id: %s", instance.instanceID)
+	if err := runner.registerAPIServer(ctx, instance); err != nil {
+		return err
+	}
+
+	others := runner.internalAWSImages[1:]
+	results := make([]launchResult, len(others))
+	sem := make(chan struct{}, d.LaunchConcurrency)
+	var wg sync.WaitGroup
+	for i, image := range others {
+		wg.Add(1)
+		go func(i int, image utils.InternalAWSImage) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i] = launchResult{image: image, err: ctx.Err()}
+				return
+			}
+			instance, err := runner.createAWSInstance(ctx, image)
+			results[i] = launchResult{image: image, instance: instance, err: err}
+		}(i, image)
+	}
+	wg.Wait()
+
+	// record everything that was created before deciding anything, so that a
+	// rollback knows about it
+	var failed []launchResult
+	var controlPlaneErrs []error
+	launched := 0
+	for _, result := range results {
+		if result.instance != nil {
+			runner.instances = append(runner.instances, result.instance)
+		}
+		if result.err != nil {
+			klog.Errorf("error starting %s instance for image %s : %v", result.image.Role, result.image.AmiID, result.err)
+			if result.image.Role == utils.RoleControlPlane {
+				controlPlaneErrs = append(controlPlaneErrs, result.err)
+			} else {
+				failed = append(failed, result)
+			}
+			continue
+		}
+		klog.Infof("started %s instance id: %s", result.image.Role, result.instance.instanceID)
+		if result.image.Role == utils.RoleControlPlane {
+			if err := runner.registerAPIServer(ctx, result.instance); err != nil {
+				controlPlaneErrs = append(controlPlaneErrs, err)
+			}
+			continue
+		}
+		launched++
+	}
+	d.saveClusterState()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(controlPlaneErrs) > 0 {
+		return fmt.Errorf("error starting control plane instances: %w", errors.Join(controlPlaneErrs...))
+	}
+	return d.reconcileWorkers(ctx, launched, failed)
+}
+
+// waitForInstances waits for all the launched instances to be running and
+// configured. A control plane that does not come up is always fatal, workers
+// are reconciled against the --partial-capacity policy.
+func (d *deployer) waitForInstances(ctx context.Context) error {
+	// stops waiting for the other instances once one of them failed Up()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	runner := d.runner
+	results := make(chan launchResult, len(runner.instances))
+	for _, instance := range runner.instances {
+		go func(instance *awsInstance) {
+			_, err := runner.isAWSInstanceRunning(ctx, instance)
+			if err != nil && ctx.Err() == nil {
+				// a spot instance may have been reclaimed while we were waiting for it
+				if reason, _ := utils.SpotInterruption(ctx, runner.ec2Service, instance.instanceID); reason != "" {
+					klog.Errorf("%s", reason)
+					err = fmt.Errorf("%s: %w", reason, err)
+				}
+			}
+			if err != nil {
+				klog.Errorf("error checking instance is running %s : %s", instance.instanceID, err)
+			} else {
+				klog.Infof("instance is running: %s", instance.instanceID)
+			}
+			results <- launchResult{instance: instance, err: err}
+		}(instance)
+	}
+
+	var failed []launchResult
+	running := 0
+	for range runner.instances {
+		select {
+		case result := <-results:
+			if result.err == nil {
+				if result.instance.role != utils.RoleControlPlane {
+					running++
+				}
+				continue
+			}
+			if result.instance.role == utils.RoleControlPlane || d.PartialCapacity == partialCapacityFail {
+				return result.err
+			}
+			failed = append(failed, result)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	err := d.reconcileWorkers(ctx, running, failed)
+	d.saveClusterState()
+	return err
+}
+
+// reconcileWorkers applies the --partial-capacity policy once some workers failed.
+// When Up() may proceed, the failed workers that were created are terminated and
+// dropped from the cluster.
+func (d *deployer) reconcileWorkers(ctx context.Context, ready int, failed []launchResult) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, result := range failed {
+		errs = append(errs, result.err)
+	}
+	requested := d.NumNodes
+	if d.PartialCapacity == partialCapacityFail {
+		return fmt.Errorf("only %d of %d workers came up: %w", ready, requested, errors.Join(errs...))
+	}
+	if ready < d.MinNodes {
+		return fmt.Errorf("only %d of %d workers came up, --min-nodes is %d: %w",
+			ready, requested, d.MinNodes, errors.Join(errs...))
+	}
+	klog.Warningf("proceeding with %d of %d workers as --partial-capacity=%s", ready, requested, d.PartialCapacity)
+
+	var dropped []*awsInstance
+	for _, result := range failed {
+		if result.instance != nil {
+			dropped = append(dropped, result.instance)
+		}
+	}
+	if len(dropped) == 0 {
+		return nil
+	}
+	if err := d.runner.terminate(ctx, dropped); err != nil {
+		// they still carry the cluster tag, so Down() will find them
+		klog.Warningf("unable to terminate failed workers: %v", err)
+		return nil
+	}
+	d.runner.instances = slices.DeleteFunc(d.runner.instances, func(instance *awsInstance) bool {
+		return slices.Contains(dropped, instance)
+	})
+	return nil
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// validateMaxLifetime checks --max-lifetime
+func (d *deployer) validateMaxLifetime() error {
+	if d.MaxLifetime < 0 {
+		return fmt.Errorf("invalid --max-lifetime %s, it must not be negative", d.MaxLifetime)
+	}
+	if d.MaxLifetime > 0 && d.UpTimeout > d.MaxLifetime {
+		klog.Warningf("--max-lifetime %s is shorter than --up-timeout %s, the nodes may power off before Up() completes",
+			d.MaxLifetime, d.UpTimeout)
+	}
+	return nil
+}
+
+// startLifetime sets the time the cluster expires at with --max-lifetime, before Up()
+// creates anything. The nodes power themselves off then, and every resource is tagged
+// with it for the janitor.
+func (d *deployer) startLifetime() {
+	if d.MaxLifetime <= 0 {
+		return
+	}
+	d.expiresAt = time.Now().Add(d.MaxLifetime).UTC().Truncate(time.Second)
+	klog.Infof("cluster %s expires at %s", d.ClusterID, d.expiresAt.Format(time.RFC3339))
+}
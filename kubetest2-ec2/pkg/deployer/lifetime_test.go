@@ -0,0 +1,135 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/kubetest2/pkg/types"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/build"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/options"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// testOptions are the kubetest2 options of a test run
+type testOptions struct {
+	types.Options
+}
+
+func (testOptions) RunID() string {
+	return "test-run"
+}
+
+func TestLifetimeOfImages(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxLifetime time.Duration
+		numNodes    int
+		nodePools   []nodePool
+		wantImages  int
+	}{
+		{
+			name:       "no lifetime",
+			numNodes:   2,
+			wantImages: 3,
+		},
+		{
+			name:        "lifetime",
+			maxLifetime: 4 * time.Hour,
+			numNodes:    2,
+			wantImages:  3,
+		},
+		{
+			name:        "lifetime with node pools",
+			maxLifetime: 4 * time.Hour,
+			nodePools: []nodePool{{
+				Name:             "gpu",
+				Count:            2,
+				InstanceTypes:    []string{"g5.xlarge"},
+				Taints:           []string{"gpu=true:NoSchedule"},
+				KubeletExtraArgs: map[string]string{"max-pods": "20"},
+				amiID:            "ami-gpu",
+			}},
+			wantImages: 3,
+		},
+		{
+			name:        "lifetime without workers",
+			maxLifetime: 30 * time.Minute,
+			wantImages:  1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &deployer{
+				commonOptions: testOptions{},
+				BuildOptions: &options.BuildOptions{
+					CommonBuildOptions: &build.Options{StageLocation: "test-bucket"},
+				},
+				ClusterID:          "test-cluster",
+				Image:              "ami-control-plane",
+				WorkerImage:        "ami-worker",
+				InstanceType:       defaultAMD64InstanceType,
+				WorkerInstanceType: defaultAMD64InstanceType,
+				ControlPlaneCount:  1,
+				NumNodes:           tc.numNodes,
+				MaxLifetime:        tc.maxLifetime,
+				nodePools:          tc.nodePools,
+			}
+			// as up() does, before the runner prepares the images
+			d.startLifetime()
+			a := &AWSRunner{deployer: d}
+			images, err := a.awsImages("v1.31.0")
+			if err != nil {
+				t.Fatalf("awsImages() returned %v", err)
+			}
+			if len(images) != tc.wantImages {
+				t.Fatalf("awsImages() returned %d images, want %d", len(images), tc.wantImages)
+			}
+
+			wantLifetime := tc.maxLifetime > 0
+			for _, image := range images {
+				role := image.Role + " " + image.NodePool
+				if image.TerminateOnShutdown != wantLifetime {
+					t.Errorf("%s terminates on shutdown %t, want %t", role, image.TerminateOnShutdown, wantLifetime)
+				}
+				expiresAt, tagged := image.Tags[utils.ExpiresAtTagKey]
+				if tagged != wantLifetime {
+					t.Errorf("%s is tagged %s %t, want %t", role, utils.ExpiresAtTagKey, tagged, wantLifetime)
+				}
+				if size, err := utils.UserDataSize(image.UserData); err != nil || size > utils.MaxUserDataSize {
+					t.Errorf("the user data of %s is %d bytes launched, want at most %d", role, size, utils.MaxUserDataSize)
+				}
+				hasTimer := strings.Contains(image.UserData, "kubetest2-max-lifetime.timer")
+				if hasTimer != wantLifetime {
+					t.Errorf("%s has the max lifetime timer in its user data %t, want %t", role, hasTimer, wantLifetime)
+				}
+				if !wantLifetime {
+					continue
+				}
+				if expiresAt != d.expiresAt.Format(time.RFC3339) {
+					t.Errorf("%s expires at %s, want %s", role, expiresAt, d.expiresAt.Format(time.RFC3339))
+				}
+				if calendar := "OnCalendar=" + d.expiresAt.Format("2006-01-02 15:04:05 UTC"); !strings.Contains(image.UserData, calendar) {
+					t.Errorf("the user data of %s does not power it off with %s", role, calendar)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// ensureLoadBalancer creates the network load balancer that is the stable
+// controlPlaneEndpoint of a highly available control plane.
+func (a *AWSRunner) ensureLoadBalancer(ctx context.Context) error {
+	if a.securityGroupID == "" {
+		return fmt.Errorf("unable to find the security group of vpc %s to open the API server port in", a.vpcID)
+	}
+	switch {
+	case a.network != nil:
+		// the security group of the cluster network already allows it from anywhere
+	case len(a.deployer.SecurityGroupIDs) > 0:
+		klog.Infof("not editing --security-group-ids, they must allow tcp/%d from the VPC for the load balancer",
+			utils.APIServerPort)
+	default:
+		// the load balancer connects to the API servers from its own private IPs
+		ruleIDs, err := utils.EnsureVPCIngress(ctx, a.ec2Service, a.vpcID, a.securityGroupID, utils.APIServerPort)
+		if err != nil {
+			return err
+		}
+		a.created.addSecurityGroupRules(a.securityGroupID, ruleIDs)
+		if len(ruleIDs) > 0 {
+			a.apiServerIngressRules = map[string][]string{a.securityGroupID: ruleIDs}
+		}
+	}
+
+	// a network load balancer takes at most one subnet per availability zone
+	var subnetIDs []string
+	zones := map[string]bool{}
+	for _, subnet := range a.subnets {
+		if !zones[subnet.AvailabilityZone] {
+			zones[subnet.AvailabilityZone] = true
+			subnetIDs = append(subnetIDs, subnet.ID)
+		}
+	}
+
+	// with --private-nodes the subnets may have no internet gateway, the nodes and the
+	// SSM tunnels only need to reach it from within the VPC
+	lb, err := utils.CreateAPIServerLoadBalancer(ctx, a.elbService, a.deployer.ClusterID, a.vpcID, subnetIDs,
+		a.deployer.PrivateNodes, a.deployer.resourceTags())
+	if lb != nil {
+		a.created.setLoadBalancer()
+	}
+	if err != nil {
+		return err
+	}
+	a.loadBalancer = lb
+	a.controlPlaneEndpoint = lb.DNSName
+	klog.Infof("using load balancer %s as the control plane endpoint", lb.DNSName)
+	return nil
+}
+
+// registerAPIServer puts a control plane node behind the load balancer, if there is one
+func (a *AWSRunner) registerAPIServer(ctx context.Context, instance *awsInstance) error {
+	if a.loadBalancer == nil {
+		return nil
+	}
+	if err := utils.RegisterAPIServerTarget(ctx, a.elbService, a.loadBalancer.TargetGroupARN, instance.privateIP); err != nil {
+		return err
+	}
+	klog.Infof("registered control plane %s (%s) with the load balancer", instance.instanceID, instance.privateIP)
+	return nil
+}
+
+// deleteLoadBalancer deletes the load balancer of the cluster, if it may have one, and
+// the ingress rules that were added for it
+func (d *deployer) deleteLoadBalancer(ctx context.Context) error {
+	if d.ControlPlaneCount <= 1 && d.runner.controlPlaneEndpoint == "" {
+		return nil
+	}
+	if err := utils.DeleteAPIServerLoadBalancer(ctx, d.runner.elbService, d.ClusterID); err != nil {
+		return err
+	}
+	return d.runner.revokeAPIServerIngress(ctx)
+}
+
+// revokeAPIServerIngress revokes the ingress rules ensureLoadBalancer added to the security group
+func (a *AWSRunner) revokeAPIServerIngress(ctx context.Context) error {
+	for groupID, ruleIDs := range a.apiServerIngressRules {
+		if err := utils.RevokeSecurityGroupIngress(ctx, a.ec2Service, groupID, ruleIDs); err != nil {
+			return err
+		}
+		klog.Infof("revoked ingress rules %v of security group %s", ruleIDs, groupID)
+		delete(a.apiServerIngressRules, groupID)
+	}
+	return nil
+}
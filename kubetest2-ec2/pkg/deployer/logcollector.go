@@ -0,0 +1,296 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/config"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+const (
+	defaultLogSpec    = "logs.yaml"
+	defaultLogTimeout = 10 * time.Minute
+	logManifestFile   = "manifest.json"
+)
+
+// logSpec lists the logs to collect from the nodes, see config/logs.yaml
+type logSpec struct {
+	Timeout time.Duration `yaml:"timeout"`
+	Logs    []logSource   `yaml:"logs"`
+}
+
+type logSource struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"command"`
+	File    string   `yaml:"file"`
+	Glob    string   `yaml:"glob"`
+	Dir     string   `yaml:"dir"`
+	Roles   []string `yaml:"roles"`
+	MaxSize string   `yaml:"maxSize"`
+
+	maxBytes int64
+}
+
+// logManifest records what was collected from every node, and what failed
+type logManifest struct {
+	ClusterID string     `json:"clusterID"`
+	Nodes     []nodeLogs `json:"nodes"`
+}
+
+type nodeLogs struct {
+	InstanceID string         `json:"instanceID"`
+	Role       string         `json:"role,omitempty"`
+	Duration   string         `json:"duration"`
+	Logs       []collectedLog `json:"logs"`
+}
+
+type collectedLog struct {
+	Name string `json:"name"`
+	// Path is relative to the logs directory
+	Path      string `json:"path,omitempty"`
+	Bytes     int64  `json:"bytes,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// loadLogSpec reads --log-spec, or the default spec
+func (d *deployer) loadLogSpec() (*logSpec, error) {
+	var data []byte
+	var err error
+	if d.LogSpec == "" {
+		data, err = config.ConfigFS.ReadFile(defaultLogSpec)
+	} else {
+		data, err = os.ReadFile(d.LogSpec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the log spec: %w", err)
+	}
+	spec := &logSpec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("parsing the log spec: %w", err)
+	}
+	if spec.Timeout <= 0 {
+		spec.Timeout = defaultLogTimeout
+	}
+	names := map[string]bool{}
+	for i := range spec.Logs {
+		source := &spec.Logs[i]
+		if source.Name == "" || strings.ContainsAny(source.Name, `/\`) {
+			return nil, fmt.Errorf("log %d of the log spec needs a name without slashes", i)
+		}
+		if names[source.Name] {
+			return nil, fmt.Errorf("log %s appears twice in the log spec", source.Name)
+		}
+		names[source.Name] = true
+		if source.Glob == "" && (source.Command == "") == (source.File == "") {
+			return nil, fmt.Errorf("log %s needs one of command or file, or a glob", source.Name)
+		}
+		if source.Glob != "" && source.File != "" {
+			return nil, fmt.Errorf("log %s cannot have both a file and a glob", source.Name)
+		}
+		if source.Dir != "" && (source.Glob == "" || filepath.IsAbs(source.Dir) || strings.Contains(source.Dir, "..")) {
+			return nil, fmt.Errorf("log %s: dir is a directory relative to the one of the node, for a glob", source.Name)
+		}
+		for _, role := range source.Roles {
+			if role != utils.RoleControlPlane && role != utils.RoleWorker {
+				return nil, fmt.Errorf("log %s: unknown role %q", source.Name, role)
+			}
+		}
+		if source.maxBytes, err = parseSize(source.MaxSize); err != nil {
+			return nil, fmt.Errorf("log %s: %w", source.Name, err)
+		}
+	}
+	return spec, nil
+}
+
+// parseSize parses a number of bytes with an optional K, M, G, Ki, Mi or Gi suffix
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30},
+		{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000},
+	} {
+		if strings.HasSuffix(size, unit.suffix) {
+			size, multiplier = strings.TrimSuffix(size, unit.suffix), unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid maxSize %q", size)
+	}
+	return n * multiplier, nil
+}
+
+// collectNodeLogs collects the logs of the spec from all the nodes in parallel, and
+// writes the manifest of what was collected
+func (d *deployer) collectNodeLogs(ctx context.Context) error {
+	spec, err := d.loadLogSpec()
+	if err != nil {
+		return err
+	}
+	manifest := logManifest{
+		ClusterID: d.ClusterID,
+		Nodes:     make([]nodeLogs, len(d.runner.instances)),
+	}
+	var wg sync.WaitGroup
+	for i, instance := range d.runner.instances {
+		wg.Add(1)
+		go func(i int, instance *awsInstance) {
+			defer wg.Done()
+			manifest.Nodes[i] = d.collectLogsOf(ctx, spec, instance)
+		}(i, instance)
+	}
+	wg.Wait()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.logsDir, logManifestFile)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	klog.Infof("wrote the manifest of the logs to %s", path)
+	return nil
+}
+
+// collectLogsOf collects the logs of the spec from the instance within the timeout
+// of the spec
+func (d *deployer) collectLogsOf(ctx context.Context, spec *logSpec, instance *awsInstance) nodeLogs {
+	start := time.Now()
+	node := nodeLogs{InstanceID: instance.instanceID, Role: instance.role, Logs: []collectedLog{}}
+	ctx, cancel := context.WithTimeout(ctx, spec.Timeout)
+	defer cancel()
+
+	nodeDir := filepath.Join(d.logsDir, instance.instanceID)
+	mkdirErr := os.MkdirAll(nodeDir, os.ModePerm)
+	for _, source := range spec.Logs {
+		if len(source.Roles) > 0 && !slices.Contains(source.Roles, instance.role) {
+			continue
+		}
+		var collected collectedLog
+		switch {
+		case mkdirErr != nil:
+			collected = collectedLog{Name: source.Name, Error: mkdirErr.Error()}
+		case ctx.Err() != nil:
+			collected = collectedLog{Name: source.Name, Error: fmt.Sprintf("not collected: %v", ctx.Err())}
+		case source.Glob != "":
+			collected = d.copyRemoteLogs(ctx, instance.instanceID, source)
+		default:
+			collected = d.dumpRemoteLogs(ctx, instance.instanceID, source)
+		}
+		if collected.Error != "" {
+			klog.Errorf("failed to collect %s from %s: %s", source.Name, instance.instanceID, collected.Error)
+		}
+		node.Logs = append(node.Logs, collected)
+	}
+	node.Duration = time.Since(start).Round(time.Millisecond).String()
+	return node
+}
+
+// dumpRemoteLogs saves the output of the command, or the file, of the source as
+// <name>.log in the directory of the instance
+func (d *deployer) dumpRemoteLogs(ctx context.Context, instanceID string, source logSource) collectedLog {
+	file := source.Name + ".log"
+	collected := collectedLog{Name: source.Name, Path: filepath.Join(instanceID, file)}
+	command := source.Command
+	if source.File != "" {
+		command = "cat " + source.File
+		if source.maxBytes > 0 {
+			// one byte more than kept tells whether there was more
+			command = fmt.Sprintf("tail -c %d %s", source.maxBytes+1, source.File)
+		}
+	} else if source.maxBytes > 0 {
+		command = fmt.Sprintf("(%s) 2>&1 | tail -c %d", command, source.maxBytes+1)
+	}
+	klog.Infof("Running command to dump logs to file %s/%s: %s", instanceID, file, command)
+	output, err := remote.SSH(ctx, instanceID, "sh", "-c", shellQuote(command))
+	if err != nil {
+		collected.Error = err.Error()
+	}
+	if source.maxBytes > 0 && int64(len(output)) > source.maxBytes {
+		output = output[int64(len(output))-source.maxBytes:]
+		collected.Truncated = true
+	}
+	// whatever the command printed helps even when it failed
+	if err := os.WriteFile(filepath.Join(d.logsDir, collected.Path), []byte(output), 0644); err != nil {
+		collected.Error = fmt.Sprintf("writing %s: %v", file, err)
+		return collected
+	}
+	collected.Bytes = int64(len(output))
+	return collected
+}
+
+// copyRemoteLogs copies the files matching the glob of the source, after running its
+// command if any, into the directory of the instance. The files are copied even when
+// the command fails, e.g. those it wrote before failing.
+func (d *deployer) copyRemoteLogs(ctx context.Context, instanceID string, source logSource) collectedLog {
+	collected := collectedLog{Name: source.Name, Path: filepath.Join(instanceID, source.Dir)}
+	destDir := filepath.Join(d.logsDir, collected.Path)
+	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+		collected.Error = err.Error()
+		return collected
+	}
+	var errs []string
+	if source.Command != "" {
+		if output, err := remote.SSH(ctx, instanceID, "sh", "-c", shellQuote(source.Command)); err != nil {
+			errs = append(errs, fmt.Sprintf("running %s: %v: %s", source.Command, err, output))
+		}
+	}
+	// the glob is expanded by the remote shell, not quoted
+	output, err := remote.SSH(ctx, instanceID, "sh", "-c", shellQuote("du -cb "+source.Glob+" | tail -n 1"))
+	if err == nil {
+		fields := strings.Fields(output)
+		if len(fields) > 0 {
+			collected.Bytes, _ = strconv.ParseInt(fields[0], 10, 64)
+		}
+	}
+	if source.maxBytes > 0 && collected.Bytes > source.maxBytes {
+		errs = append(errs, fmt.Sprintf("%s matches %d bytes, more than maxSize %s", source.Glob, collected.Bytes, source.MaxSize))
+	} else if output, err := remote.SCP(ctx, instanceID, source.Glob, destDir); err != nil {
+		errs = append(errs, fmt.Sprintf("copying %s: %v: %s", source.Glob, err, output))
+	}
+	collected.Error = strings.Join(errs, "; ")
+	return collected
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadLogSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		// spec is the --log-spec, the default spec if empty
+		spec        string
+		wantTimeout time.Duration
+		// wantMaxBytes are the maxSize of the logs by name
+		wantMaxBytes map[string]int64
+		wantErr      bool
+	}{
+		{
+			name:         "default",
+			wantTimeout:  10 * time.Minute,
+			wantMaxBytes: map[string]int64{"pods": 1 << 30, "containerd": 100 << 20, "cloud-init": 0},
+		},
+		{
+			name: "no timeout",
+			spec: `logs:
+- name: kubelet
+  command: journalctl -u kubelet --no-pager
+  maxSize: 10M
+- name: syslog
+  file: /var/log/syslog
+  roles: [worker]
+- name: audit
+  glob: /var/log/kubernetes/audit*
+  dir: audit
+  roles: [control-plane]
+`,
+			wantTimeout:  defaultLogTimeout,
+			wantMaxBytes: map[string]int64{"kubelet": 10 * 1000 * 1000, "syslog": 0, "audit": 0},
+		},
+		{
+			name:    "no name",
+			spec:    "logs:\n- command: uptime\n",
+			wantErr: true,
+		},
+		{
+			name:    "name with a slash",
+			spec:    "logs:\n- name: ../uptime\n  command: uptime\n",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			spec:    "logs:\n- name: uptime\n  command: uptime\n- name: uptime\n  file: /proc/uptime\n",
+			wantErr: true,
+		},
+		{
+			name:    "nothing to collect",
+			spec:    "logs:\n- name: uptime\n",
+			wantErr: true,
+		},
+		{
+			name:    "command and file",
+			spec:    "logs:\n- name: uptime\n  command: uptime\n  file: /proc/uptime\n",
+			wantErr: true,
+		},
+		{
+			name:    "file and glob",
+			spec:    "logs:\n- name: syslog\n  file: /var/log/syslog\n  glob: /var/log/syslog*\n",
+			wantErr: true,
+		},
+		{
+			name:    "dir without glob",
+			spec:    "logs:\n- name: uptime\n  command: uptime\n  dir: uptime\n",
+			wantErr: true,
+		},
+		{
+			name:    "absolute dir",
+			spec:    "logs:\n- name: syslog\n  glob: /var/log/syslog*\n  dir: /tmp\n",
+			wantErr: true,
+		},
+		{
+			name:    "dir out of the node",
+			spec:    "logs:\n- name: syslog\n  glob: /var/log/syslog*\n  dir: ../syslog\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown role",
+			spec:    "logs:\n- name: uptime\n  command: uptime\n  roles: [etcd]\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid maxSize",
+			spec:    "logs:\n- name: uptime\n  command: uptime\n  maxSize: 10MB\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			spec:    "logs: [\n",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &deployer{}
+			if tc.spec != "" {
+				d.LogSpec = filepath.Join(t.TempDir(), "logs.yaml")
+				if err := os.WriteFile(d.LogSpec, []byte(tc.spec), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			spec, err := d.loadLogSpec()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("loadLogSpec() returned %v, want error %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if spec.Timeout != tc.wantTimeout {
+				t.Errorf("the timeout is %s, want %s", spec.Timeout, tc.wantTimeout)
+			}
+			maxBytes := map[string]int64{}
+			for _, source := range spec.Logs {
+				maxBytes[source.Name] = source.maxBytes
+			}
+			for name, want := range tc.wantMaxBytes {
+				if got, ok := maxBytes[name]; !ok || got != want {
+					t.Errorf("log %s has a maxSize of %d bytes (found %t), want %d", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: "512", want: 512},
+		{size: "10K", want: 10 * 1000},
+		{size: "10Ki", want: 10 << 10},
+		{size: "100M", want: 100 * 1000 * 1000},
+		{size: "100Mi", want: 100 << 20},
+		{size: "2G", want: 2 * 1000 * 1000 * 1000},
+		{size: "2Gi", want: 2 << 30},
+		{size: "10MB", wantErr: true},
+		{size: "-1K", wantErr: true},
+		{size: "Mi", wantErr: true},
+		{size: "1.5Gi", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseSize(tc.size)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseSize(%q) returned %v, want error %t", tc.size, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tc.size, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+
+	"sigs.k8s.io/kubetest2/pkg/artifacts"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+const clusterMetadataFile = "cluster.json"
+
+// clusterMetadata describes the cluster to the testers that run after Up(). Unlike
+// clusterState, which only serves later invocations of the deployer, its fields are
+// meant to be stable.
+type clusterMetadata struct {
+	ClusterID string `json:"clusterID"`
+	Region    string `json:"region"`
+	// KubernetesVersion is the version the nodes installed, staged or built
+	KubernetesVersion string            `json:"kubernetesVersion"`
+	FeatureGates      map[string]string `json:"featureGates,omitempty"`
+	RuntimeConfig     string            `json:"runtimeConfig,omitempty"`
+	// Kubeconfig is the admin kubeconfig of the cluster
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// ControlPlaneEndpoint is the DNS name of the API server load balancer, if any
+	ControlPlaneEndpoint string           `json:"controlPlaneEndpoint,omitempty"`
+	Network              metadataNetwork  `json:"network"`
+	UserData             metadataUserData `json:"userData"`
+	// SSH is how to reach the nodes, empty with --private-nodes
+	SSH       *metadataSSH       `json:"ssh,omitempty"`
+	Instances []metadataInstance `json:"instances"`
+}
+
+type metadataNetwork struct {
+	VpcID           string   `json:"vpcID,omitempty"`
+	SubnetIDs       []string `json:"subnetIDs,omitempty"`
+	WorkerSubnetIDs []string `json:"workerSubnetIDs,omitempty"`
+	SecurityGroupID string   `json:"securityGroupID,omitempty"`
+	IPFamily        string   `json:"ipFamily,omitempty"`
+}
+
+// metadataUserData names the templates the user data of the instances came from,
+// files of config/ unless they are paths
+type metadataUserData struct {
+	ControlPlane string `json:"controlPlane"`
+	Worker       string `json:"worker"`
+	KubeadmInit  string `json:"kubeadmInit,omitempty"`
+	KubeadmJoin  string `json:"kubeadmJoin,omitempty"`
+}
+
+type metadataSSH struct {
+	User    string `json:"user"`
+	Bastion string `json:"bastion,omitempty"`
+	KeyPath string `json:"keyPath,omitempty"`
+}
+
+type metadataInstance struct {
+	InstanceID       string `json:"instanceID"`
+	Role             string `json:"role"`
+	ImageID          string `json:"imageID,omitempty"`
+	InstanceType     string `json:"instanceType,omitempty"`
+	Architecture     string `json:"architecture,omitempty"`
+	CapacityType     string `json:"capacityType,omitempty"`
+	NodePool         string `json:"nodePool,omitempty"`
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	SubnetID         string `json:"subnetID,omitempty"`
+	PrivateIP        string `json:"privateIP,omitempty"`
+	PublicIP         string `json:"publicIP,omitempty"`
+}
+
+func clusterMetadataPath() string {
+	return filepath.Join(artifacts.BaseDir(), clusterMetadataFile)
+}
+
+// writeClusterMetadata writes <artifacts>/cluster.json
+func (d *deployer) writeClusterMetadata() error {
+	a := d.runner
+	metadata := clusterMetadata{
+		ClusterID:            d.ClusterID,
+		Region:               d.Region,
+		KubernetesVersion:    a.version,
+		FeatureGates:         parseFeatureGates(d.FeatureGates),
+		RuntimeConfig:        d.RuntimeConfig,
+		Kubeconfig:           d.KubeconfigPath,
+		ControlPlaneEndpoint: a.controlPlaneEndpoint,
+		Network: metadataNetwork{
+			VpcID:           a.vpcID,
+			SubnetIDs:       subnetIDs(a.subnets),
+			WorkerSubnetIDs: subnetIDs(a.workerSubnets),
+			SecurityGroupID: a.securityGroupID,
+			IPFamily:        d.IPFamily,
+		},
+		UserData: metadataUserData{
+			ControlPlane: d.UserDataFile,
+			Worker:       d.WorkerUserDataFile,
+			KubeadmInit:  d.KubeadmInitFile,
+			KubeadmJoin:  d.KubeadmJoinFile,
+		},
+		Instances: []metadataInstance{},
+	}
+	if a.network != nil && metadata.Network.VpcID == "" {
+		metadata.Network.VpcID = a.network.VpcID
+	}
+	for _, instance := range a.instances {
+		m := metadataInstance{
+			InstanceID: instance.instanceID,
+			Role:       instance.role,
+			PrivateIP:  instance.privateIP,
+			PublicIP:   instance.publicIP,
+		}
+		if i := instance.instance; i != nil {
+			m.ImageID = awsv2.ToString(i.ImageId)
+			m.InstanceType = string(i.InstanceType)
+			m.Architecture = string(i.Architecture)
+			m.CapacityType = utils.InstanceTag(*i, utils.CapacityTypeTagKey)
+			m.NodePool = utils.InstanceTag(*i, utils.NodePoolTagKey)
+			m.SubnetID = awsv2.ToString(i.SubnetId)
+			if i.Placement != nil {
+				m.AvailabilityZone = awsv2.ToString(i.Placement.AvailabilityZone)
+			}
+		}
+		metadata.Instances = append(metadata.Instances, m)
+	}
+	if len(a.instances) > 0 && a.instances[0].publicIP != "" {
+		metadata.SSH = &metadataSSH{
+			User:    d.SSHUser,
+			Bastion: a.instances[0].publicIP + ":22",
+		}
+		if a.instances[0].sshKey != nil {
+			metadata.SSH.KeyPath = a.instances[0].sshKey.PrivateKeyPath
+		}
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(artifacts.BaseDir(), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(clusterMetadataPath(), data, 0644)
+}
+
+// parseFeatureGates splits --feature-gates into its key=value pairs
+func parseFeatureGates(featureGates string) map[string]string {
+	gates := map[string]string{}
+	for _, pair := range strings.Split(featureGates, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if key != "" {
+			gates[key] = value
+		}
+	}
+	if len(gates) == 0 {
+		return nil
+	}
+	return gates
+}
+
+func subnetIDs(subnets []utils.Subnet) []string {
+	var ids []string
+	for _, subnet := range subnets {
+		ids = append(ids, subnet.ID)
+	}
+	return ids
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFeatureGates(t *testing.T) {
+	tests := []struct {
+		name         string
+		featureGates string
+		want         map[string]string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:         "one",
+			featureGates: "InPlacePodVerticalScaling=true",
+			want:         map[string]string{"InPlacePodVerticalScaling": "true"},
+		},
+		{
+			name:         "several with spaces",
+			featureGates: "AllAlpha=false, InPlacePodVerticalScaling=true ,",
+			want:         map[string]string{"AllAlpha": "false", "InPlacePodVerticalScaling": "true"},
+		},
+		{
+			name:         "no value",
+			featureGates: "AllBeta",
+			want:         map[string]string{"AllBeta": ""},
+		},
+		{
+			name:         "only separators",
+			featureGates: " , ,",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseFeatureGates(tc.featureGates); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseFeatureGates(%q) = %v, want %v", tc.featureGates, got, tc.want)
+			}
+		})
+	}
+}
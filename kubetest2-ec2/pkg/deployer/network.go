@@ -0,0 +1,87 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+func (d *deployer) validateNetworkOptions() error {
+	if d.PrivateNodes {
+		if !d.CreateVPC && len(d.SubnetIDs) == 0 {
+			return fmt.Errorf("--private-nodes needs --create-vpc or --subnet-ids, the subnets of the default VPC have no outbound access without public IPs")
+		}
+		if _, err := exec.LookPath(utils.SessionManagerPlugin); err != nil {
+			return fmt.Errorf("--private-nodes needs %s: %w", utils.SessionManagerPlugin, err)
+		}
+	}
+	if !d.CreateVPC {
+		return nil
+	}
+	if d.VpcID != "" || len(d.SubnetIDs) > 0 || len(d.WorkerSubnetIDs) > 0 || len(d.SecurityGroupIDs) > 0 {
+		return fmt.Errorf("--create-vpc cannot be combined with --vpc-id, --subnet-ids, --worker-subnet-ids or --security-group-ids")
+	}
+	if d.VpcZones < 1 {
+		return fmt.Errorf("--vpc-zones must be at least 1, is %d", d.VpcZones)
+	}
+	return nil
+}
+
+// createNetwork creates the VPC of the cluster and launches the instances in its
+// public subnets, or private ones with --private-nodes, behind its own security group.
+func (a *AWSRunner) createNetwork(ctx context.Context) error {
+	ipv6 := a.deployer.IPFamily == "ipv6" || a.deployer.IPFamily == "dual"
+	// whatever was created is tagged, so a rollback finds it even if this fails
+	a.created.setNetwork()
+	network, err := utils.CreateClusterNetwork(ctx, a.ec2Service, a.deployer.ClusterID, a.deployer.VpcCIDR,
+		a.deployer.VpcZones, ipv6, a.deployer.PrivateNodes, a.deployer.resourceTags())
+	if network != nil {
+		a.network = network
+	}
+	if err != nil {
+		return fmt.Errorf("creating the vpc of the cluster: %w", err)
+	}
+
+	a.vpcID = network.VpcID
+	a.subnets = network.PublicSubnets
+	if a.deployer.PrivateNodes {
+		a.subnets = network.PrivateSubnets
+	}
+	a.securityGroupID = network.SecurityGroupID
+	for i := range a.internalAWSImages {
+		a.internalAWSImages[i].SecurityGroupIDs = []string{network.SecurityGroupID}
+	}
+	return nil
+}
+
+// deleteNetwork deletes the VPC of the cluster, if it may have one
+func (d *deployer) deleteNetwork(ctx context.Context) error {
+	if !d.CreateVPC && d.runner.network == nil {
+		return nil
+	}
+	if err := utils.DeleteClusterNetwork(ctx, d.runner.ec2Service, d.ClusterID); err != nil {
+		return err
+	}
+	klog.Infof("deleted the network of cluster %s", d.ClusterID)
+	return nil
+}
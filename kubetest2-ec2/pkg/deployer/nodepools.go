@@ -0,0 +1,290 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// taintEffects are the effects a taint of a node pool may have
+var taintEffects = []string{"NoSchedule", "PreferNoSchedule", "NoExecute"}
+
+// clusterSpec is the file of --cluster-spec
+type clusterSpec struct {
+	NodePools []nodePool `yaml:"nodePools"`
+}
+
+// nodePool is a group of identical workers. The fields it leaves empty default to
+// the --worker-* flags.
+type nodePool struct {
+	Name  string `yaml:"name"`
+	Count int    `yaml:"count"`
+	// Image is an AMI ID or one of operatingSystems
+	Image string `yaml:"image"`
+	// Arch is the architecture of the workers, amd64 or arm64, which an operating
+	// system Image resolves to. Defaults to --worker-arch.
+	Arch string `yaml:"arch"`
+	// InstanceType is a shorthand for a single InstanceTypes
+	InstanceType string `yaml:"instanceType"`
+	// InstanceTypes are tried in order on capacity errors
+	InstanceTypes []string `yaml:"instanceTypes"`
+	CapacityType  string   `yaml:"capacityType"`
+	// UserDataFile defaults to the one of the operating system of Image, if it is one
+	UserDataFile string            `yaml:"userDataFile"`
+	Labels       map[string]string `yaml:"labels"`
+	// Taints are <key>[=<value>]:<effect>
+	Taints           []string          `yaml:"taints"`
+	KubeletExtraArgs map[string]string `yaml:"kubeletExtraArgs"`
+
+	// amiID is Image once resolved by resolveNodePools
+	amiID string
+}
+
+// loadClusterSpec reads and checks --cluster-spec. Its node pools replace the
+// --num-nodes workers, so --num-nodes becomes their total count.
+func (d *deployer) loadClusterSpec() error {
+	if d.ClusterSpec == "" {
+		return nil
+	}
+	data, err := os.ReadFile(d.ClusterSpec)
+	if err != nil {
+		return fmt.Errorf("reading the cluster spec: %w", err)
+	}
+	spec := &clusterSpec{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(spec); err != nil {
+		return fmt.Errorf("parsing the cluster spec %s: %w", d.ClusterSpec, err)
+	}
+	if len(spec.NodePools) == 0 {
+		return fmt.Errorf("the cluster spec %s has no nodePools", d.ClusterSpec)
+	}
+	names := map[string]bool{}
+	total := 0
+	for i := range spec.NodePools {
+		pool := &spec.NodePools[i]
+		if errs := validation.IsDNS1123Label(pool.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid name %q of node pool %d: %s", pool.Name, i, strings.Join(errs, ", "))
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("node pool %s appears twice in the cluster spec", pool.Name)
+		}
+		names[pool.Name] = true
+		if err := pool.validate(); err != nil {
+			return fmt.Errorf("invalid node pool %s: %w", pool.Name, err)
+		}
+		total += pool.Count
+	}
+	d.nodePools = spec.NodePools
+	d.NumNodes = total
+	return nil
+}
+
+func (p *nodePool) validate() error {
+	if p.Count < 1 {
+		return fmt.Errorf("count must be at least 1, is %d", p.Count)
+	}
+	if p.Image != "" && !slices.Contains(operatingSystems, p.Image) && !strings.HasPrefix(p.Image, "ami-") {
+		return fmt.Errorf("image %q is neither an AMI ID nor one of %s", p.Image, strings.Join(operatingSystems, ", "))
+	}
+	if p.Arch != "" && !slices.Contains(archs, p.Arch) {
+		return fmt.Errorf("arch must be one of %s, is %q", strings.Join(archs, ", "), p.Arch)
+	}
+	if p.InstanceType != "" && len(p.InstanceTypes) > 0 {
+		return fmt.Errorf("instanceType and instanceTypes are mutually exclusive")
+	}
+	if p.CapacityType != "" && !slices.Contains(utils.CapacityTypes, p.CapacityType) {
+		return fmt.Errorf("capacityType must be one of %s, is %q", strings.Join(utils.CapacityTypes, ", "), p.CapacityType)
+	}
+	for key, value := range p.Labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label %q: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value of label %s: %s", key, strings.Join(errs, ", "))
+		}
+		if !kubeletMaySetLabel(key) {
+			return fmt.Errorf("label %s is in a namespace the kubelet may not set, use node.kubernetes.io/ instead", key)
+		}
+	}
+	for _, taint := range p.Taints {
+		keyValue, effect, ok := strings.Cut(taint, ":")
+		key, _, _ := strings.Cut(keyValue, "=")
+		if !ok || !slices.Contains(taintEffects, effect) {
+			return fmt.Errorf("invalid taint %q, expected <key>[=<value>]:<effect> with an effect out of %s",
+				taint, strings.Join(taintEffects, ", "))
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid key of taint %q: %s", taint, strings.Join(errs, ", "))
+		}
+	}
+	for name := range p.KubeletExtraArgs {
+		switch {
+		case name == "" || strings.HasPrefix(name, "-"):
+			return fmt.Errorf("invalid kubelet argument %q, expected its name without dashes", name)
+		case name == "node-labels" || name == "register-with-taints":
+			return fmt.Errorf("kubelet argument %s is set from the labels and taints of the node pool", name)
+		}
+	}
+	return nil
+}
+
+// kubeletMaySetLabel returns false for the labels --node-labels refuses, in the
+// kubernetes.io and k8s.io namespaces other than node.kubernetes.io and kubelet.kubernetes.io
+func kubeletMaySetLabel(key string) bool {
+	namespace, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return true
+	}
+	inNamespace := func(domain string) bool {
+		return namespace == domain || strings.HasSuffix(namespace, "."+domain)
+	}
+	if inNamespace("node.kubernetes.io") || inNamespace("kubelet.kubernetes.io") {
+		return true
+	}
+	return !inNamespace("kubernetes.io") && !inNamespace("k8s.io")
+}
+
+// resolveNodePools looks up the AMIs of the node pools and fills in their defaults
+func (a *AWSRunner) resolveNodePools(ctx context.Context) error {
+	for i := range a.deployer.nodePools {
+		pool := &a.deployer.nodePools[i]
+		arch := pool.arch(a.deployer)
+		switch {
+		case slices.Contains(operatingSystems, pool.Image):
+			path, userDataFile, err := osImageParameter(pool.Image, arch)
+			if err != nil {
+				return err
+			}
+			id, err := utils.GetSSMImage(ctx, a.ssmService, path)
+			if err != nil {
+				return fmt.Errorf("error looking up ssm for node pool %s : %w", pool.Name, err)
+			}
+			klog.Infof("using image id from ssm %s for node pool %s", id, pool.Name)
+			pool.amiID = id
+			if pool.UserDataFile == "" {
+				pool.UserDataFile = userDataFile
+			}
+		case pool.Image == "":
+			pool.amiID = a.deployer.WorkerImage
+		default:
+			pool.amiID = pool.Image
+		}
+		if pool.UserDataFile == "" {
+			pool.UserDataFile = a.deployer.WorkerUserDataFile
+		}
+
+		if pool.InstanceType != "" {
+			pool.InstanceTypes = []string{pool.InstanceType}
+		}
+		if len(pool.InstanceTypes) == 0 {
+			pool.InstanceTypes = a.deployer.WorkerInstanceTypes
+		}
+		if len(pool.InstanceTypes) == 0 {
+			pool.InstanceTypes = []string{instanceTypeForArch(a.deployer.WorkerInstanceType, arch)}
+		}
+		if pool.CapacityType == "" {
+			pool.CapacityType = a.deployer.WorkerCapacityType
+		}
+	}
+	return nil
+}
+
+// arch is the architecture of the workers of the pool
+func (p *nodePool) arch(d *deployer) string {
+	if p.Arch != "" {
+		return p.Arch
+	}
+	return d.workerArch()
+}
+
+// kubeletArgs are the kubeletExtraArgs the workers of the pool join with. They are
+// labeled with the name of the pool.
+func (p *nodePool) kubeletArgs() []utils.KubeletArg {
+	labels := map[string]string{utils.NodePoolTagKey: p.Name}
+	for key, value := range p.Labels {
+		labels[key] = value
+	}
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	args := []utils.KubeletArg{{Name: "node-labels", Value: strings.Join(pairs, ",")}}
+	if len(p.Taints) > 0 {
+		args = append(args, utils.KubeletArg{Name: "register-with-taints", Value: strings.Join(p.Taints, ",")})
+	}
+	names := make([]string, 0, len(p.KubeletExtraArgs))
+	for name := range p.KubeletExtraArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, utils.KubeletArg{Name: name, Value: p.KubeletExtraArgs[name]})
+	}
+	return args
+}
+
+// nodePoolImages expands the node pools into the images of their workers
+func (a *AWSRunner) nodePoolImages(version string, tags map[string]string) ([]utils.InternalAWSImage, error) {
+	var ret []utils.InternalAWSImage
+	for _, pool := range a.deployer.nodePools {
+		userData, err := a.getUserData(pool.UserDataFile, version, kubeadmJoin, pool.kubeletArgs())
+		if err != nil {
+			return nil, fmt.Errorf("unable to load user data %s of node pool %s : %w", pool.UserDataFile, pool.Name, err)
+		}
+		size, err := utils.UserDataSize(userData)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compress user data of node pool %s: %w", pool.Name, err)
+		}
+		if size > utils.MaxUserDataSize {
+			return nil, fmt.Errorf("user data of node pool %s is too large, must be less than 16384 bytes gzipped, is %d",
+				pool.Name, size)
+		}
+		klog.Infof("using %s for node pool %s of %d workers", pool.amiID, pool.Name, pool.Count)
+		for i := 0; i < pool.Count; i++ {
+			ret = append(ret, utils.InternalAWSImage{
+				AmiID:            pool.amiID,
+				UserData:         userData,
+				InstanceType:     pool.InstanceTypes[0],
+				InstanceTypes:    pool.InstanceTypes,
+				InstanceProfile:  a.deployer.InstanceProfile,
+				Role:             utils.RoleWorker,
+				CapacityType:     pool.CapacityType,
+				SecurityGroupIDs: a.deployer.SecurityGroupIDs,
+				NoPublicIP:       a.deployer.PrivateNodes,
+				Tags:             tags,
+
+				TerminateOnShutdown: !a.deployer.expiresAt.IsZero(),
+				NodePool:            pool.Name,
+			})
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+func TestNodePoolValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pool    nodePool
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			pool: nodePool{Count: 1},
+		},
+		{
+			name: "everything",
+			pool: nodePool{
+				Count:            3,
+				Image:            "al2023",
+				Arch:             "arm64",
+				InstanceTypes:    []string{"m7g.large", "m6g.large"},
+				CapacityType:     utils.CapacityTypeSpotWithFallback,
+				Labels:           map[string]string{"node.kubernetes.io/gpu": "true", "team": "node"},
+				Taints:           []string{"gpu=true:NoSchedule", "dedicated:NoExecute"},
+				KubeletExtraArgs: map[string]string{"max-pods": "20"},
+			},
+		},
+		{
+			name: "ami",
+			pool: nodePool{Count: 1, Image: "ami-0123456789abcdef0"},
+		},
+		{
+			name:    "no count",
+			pool:    nodePool{},
+			wantErr: true,
+		},
+		{
+			name:    "unknown image",
+			pool:    nodePool{Count: 1, Image: "debian"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown arch",
+			pool:    nodePool{Count: 1, Arch: "riscv64"},
+			wantErr: true,
+		},
+		{
+			name:    "instanceType and instanceTypes",
+			pool:    nodePool{Count: 1, InstanceType: "m6i.large", InstanceTypes: []string{"m5.large"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown capacity type",
+			pool:    nodePool{Count: 1, CapacityType: "reserved"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid label",
+			pool:    nodePool{Count: 1, Labels: map[string]string{"not a label": "true"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid label value",
+			pool:    nodePool{Count: 1, Labels: map[string]string{"team": "not a value"}},
+			wantErr: true,
+		},
+		{
+			name:    "label the kubelet may not set",
+			pool:    nodePool{Count: 1, Labels: map[string]string{"node-role.kubernetes.io/gpu": ""}},
+			wantErr: true,
+		},
+		{
+			name:    "taint without effect",
+			pool:    nodePool{Count: 1, Taints: []string{"gpu=true"}},
+			wantErr: true,
+		},
+		{
+			name:    "taint with unknown effect",
+			pool:    nodePool{Count: 1, Taints: []string{"gpu=true:NoRun"}},
+			wantErr: true,
+		},
+		{
+			name:    "kubelet argument with dashes",
+			pool:    nodePool{Count: 1, KubeletExtraArgs: map[string]string{"--max-pods": "20"}},
+			wantErr: true,
+		},
+		{
+			name:    "kubelet node labels",
+			pool:    nodePool{Count: 1, KubeletExtraArgs: map[string]string{"node-labels": "team=node"}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.pool.validate(); (err != nil) != tc.wantErr {
+				t.Errorf("validate() returned %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestKubeletMaySetLabel(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "team", want: true},
+		{key: "example.com/team", want: true},
+		{key: "node.kubernetes.io/gpu", want: true},
+		{key: "kubelet.kubernetes.io/gpu", want: true},
+		{key: "pool.node.kubernetes.io/gpu", want: true},
+		{key: "kubernetes.io/role", want: false},
+		{key: "node-role.kubernetes.io/gpu", want: false},
+		{key: "k8s.io/gpu", want: false},
+	}
+	for _, tc := range tests {
+		if got := kubeletMaySetLabel(tc.key); got != tc.want {
+			t.Errorf("kubeletMaySetLabel(%q) = %t, want %t", tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestLoadClusterSpec(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         string
+		wantPools    []string
+		wantNumNodes int
+		wantErr      bool
+	}{
+		{
+			name: "node pools",
+			spec: `nodePools:
+- name: general
+  count: 2
+- name: gpu
+  count: 1
+  instanceType: g5.xlarge
+`,
+			wantPools:    []string{"general", "gpu"},
+			wantNumNodes: 3,
+		},
+		{
+			name:    "no node pools",
+			spec:    "nodePools: []\n",
+			wantErr: true,
+		},
+		{
+			name: "unknown field",
+			spec: `nodePools:
+- name: general
+  count: 2
+  size: large
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid name",
+			spec: `nodePools:
+- name: General_Pool
+  count: 2
+`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate name",
+			spec: `nodePools:
+- name: general
+  count: 2
+- name: general
+  count: 1
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid pool",
+			spec: `nodePools:
+- name: general
+  count: 0
+`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &deployer{
+				ClusterSpec: filepath.Join(t.TempDir(), "cluster.yaml"),
+				NumNodes:    2,
+			}
+			if err := os.WriteFile(d.ClusterSpec, []byte(tc.spec), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := d.loadClusterSpec()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("loadClusterSpec() returned %v, want error %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var pools []string
+			for _, pool := range d.nodePools {
+				pools = append(pools, pool.Name)
+			}
+			if !reflect.DeepEqual(pools, tc.wantPools) {
+				t.Errorf("loaded node pools %v, want %v", pools, tc.wantPools)
+			}
+			if d.NumNodes != tc.wantNumNodes {
+				t.Errorf("--num-nodes is %d, want %d", d.NumNodes, tc.wantNumNodes)
+			}
+		})
+	}
+}
+
+func TestNodePoolKubeletArgs(t *testing.T) {
+	pool := nodePool{
+		Name:             "gpu",
+		Labels:           map[string]string{"team": "node", "accelerator": "nvidia"},
+		Taints:           []string{"gpu=true:NoSchedule", "dedicated:NoExecute"},
+		KubeletExtraArgs: map[string]string{"max-pods": "20", "cpu-manager-policy": "static"},
+	}
+	want := []utils.KubeletArg{
+		{Name: "node-labels", Value: "accelerator=nvidia," + utils.NodePoolTagKey + "=gpu,team=node"},
+		{Name: "register-with-taints", Value: "gpu=true:NoSchedule,dedicated:NoExecute"},
+		{Name: "cpu-manager-policy", Value: "static"},
+		{Name: "max-pods", Value: "20"},
+	}
+	if got := pool.kubeletArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("kubeletArgs() = %v, want %v", got, want)
+	}
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package options
 
 import (
+	"context"
+
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/build"
 )
 
@@ -30,10 +32,10 @@ func (bo *BuildOptions) Validate() error {
 	return bo.CommonBuildOptions.Validate()
 }
 
-func (bo *BuildOptions) Build() (string, error) {
-	return bo.CommonBuildOptions.Build()
+func (bo *BuildOptions) Build(ctx context.Context) (string, error) {
+	return bo.CommonBuildOptions.Build(ctx)
 }
 
-func (bo *BuildOptions) Stage(version string) interface{} {
-	return bo.CommonBuildOptions.Stage(version)
+func (bo *BuildOptions) Stage(ctx context.Context, version string) error {
+	return bo.CommonBuildOptions.Stage(ctx, version)
 }
@@ -0,0 +1,220 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/kubetest2/pkg/artifacts"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+const (
+	phasesFile           = "phases.json"
+	phasesMetricsFile    = "phases.prom"
+	phasesMetric         = "kubetest2_ec2_phase_duration_seconds"
+	phasesMaxMetric      = "kubetest2_ec2_phase_duration_max_seconds"
+	phasesFailuresMetric = "kubetest2_ec2_phase_failures_total"
+)
+
+// phaseRecorder records how long the phases of Build() and Up() take. Every phase
+// that ends rewrites <artifacts>/phases.json and <artifacts>/phases.prom, a Prometheus
+// textfile, so that they are there even if the deployer does not return.
+type phaseRecorder struct {
+	mu sync.Mutex
+	// runID tells the phases of this run from those of earlier runs in the same
+	// artifacts directory, a --build and an --up invocation of one job share it
+	runID  string
+	loaded bool
+	phases []phaseTiming
+}
+
+// phaseTiming is a phase that ended
+type phaseTiming struct {
+	Phase        string    `json:"phase"`
+	Role         string    `json:"role,omitempty"`
+	ImageID      string    `json:"imageID,omitempty"`
+	InstanceType string    `json:"instanceType,omitempty"`
+	InstanceID   string    `json:"instanceID,omitempty"`
+	Start        time.Time `json:"start"`
+	Seconds      float64   `json:"seconds"`
+	Error        string    `json:"error,omitempty"`
+}
+
+type phasesReport struct {
+	RunID  string        `json:"runID"`
+	Phases []phaseTiming `json:"phases"`
+}
+
+// phaseTimer times a phase, from startPhase until stop
+type phaseTimer struct {
+	recorder *phaseRecorder
+	timing   phaseTiming
+}
+
+func newPhaseRecorder(runID string) *phaseRecorder {
+	return &phaseRecorder{runID: runID}
+}
+
+// startPhase starts timing the phase
+func (d *deployer) startPhase(phase string) *phaseTimer {
+	return &phaseTimer{
+		recorder: d.phases,
+		timing:   phaseTiming{Phase: phase, Start: time.Now()},
+	}
+}
+
+// forImage labels the phase with the role, AMI and first instance type of the image
+func (t *phaseTimer) forImage(img utils.InternalAWSImage) *phaseTimer {
+	t.timing.Role = img.Role
+	t.timing.ImageID = img.AmiID
+	if len(img.InstanceTypes) > 0 {
+		t.timing.InstanceType = img.InstanceTypes[0]
+	}
+	return t
+}
+
+// forInstance labels the phase with the role, AMI and instance type of the instance
+func (t *phaseTimer) forInstance(instance *awsInstance) *phaseTimer {
+	if instance == nil {
+		return t
+	}
+	t.timing.Role = instance.role
+	t.timing.InstanceID = instance.instanceID
+	if instance.instance != nil {
+		t.timing.ImageID = awsv2.ToString(instance.instance.ImageId)
+		t.timing.InstanceType = string(instance.instance.InstanceType)
+	}
+	return t
+}
+
+// stop records the phase, which failed if err is set
+func (t *phaseTimer) stop(err error) {
+	t.timing.Seconds = time.Since(t.timing.Start).Seconds()
+	if err != nil {
+		t.timing.Error = err.Error()
+	}
+	klog.V(2).Infof("phase %s took %.1fs", t.timing.Phase, t.timing.Seconds)
+	if t.recorder == nil {
+		return
+	}
+	if err := t.recorder.record(t.timing); err != nil {
+		klog.Warningf("unable to record phase %s: %v", t.timing.Phase, err)
+	}
+}
+
+func (r *phaseRecorder) record(timing phaseTiming) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded {
+		r.loaded = true
+		r.loadEarlierPhases()
+	}
+	r.phases = append(r.phases, timing)
+
+	if err := os.MkdirAll(artifacts.BaseDir(), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(phasesReport{RunID: r.runID, Phases: r.phases}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(artifacts.BaseDir(), phasesFile), data, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(artifacts.BaseDir(), phasesMetricsFile), phasesMetrics(r.phases), 0644)
+}
+
+// loadEarlierPhases keeps the phases an earlier invocation of the same run recorded,
+// e.g. the build when --up runs in a separate step
+func (r *phaseRecorder) loadEarlierPhases() {
+	data, err := os.ReadFile(filepath.Join(artifacts.BaseDir(), phasesFile))
+	if err != nil {
+		return
+	}
+	var report phasesReport
+	if err := json.Unmarshal(data, &report); err != nil || report.RunID != r.runID {
+		return
+	}
+	r.phases = append(report.Phases, r.phases...)
+}
+
+// phasesMetrics renders the phases in the Prometheus text format. Phases with the
+// same labels, e.g. the workers of one instance type, are summarized together.
+func phasesMetrics(phases []phaseTiming) []byte {
+	type series struct {
+		labels   string
+		count    int
+		sum      float64
+		max      float64
+		failures int
+	}
+	byLabels := map[string]*series{}
+	for _, phase := range phases {
+		labels := fmt.Sprintf(`phase=%q,role=%q,ami=%q,instance_type=%q`,
+			phase.Phase, phase.Role, phase.ImageID, phase.InstanceType)
+		s, ok := byLabels[labels]
+		if !ok {
+			s = &series{labels: labels}
+			byLabels[labels] = s
+		}
+		s.count++
+		s.sum += phase.Seconds
+		if phase.Seconds > s.max {
+			s.max = phase.Seconds
+		}
+		if phase.Error != "" {
+			s.failures++
+		}
+	}
+	keys := make([]string, 0, len(byLabels))
+	for labels := range byLabels {
+		keys = append(keys, labels)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# HELP %s How long the phases of the ec2 deployer took.\n", phasesMetric)
+	fmt.Fprintf(&buf, "# TYPE %s summary\n", phasesMetric)
+	for _, labels := range keys {
+		s := byLabels[labels]
+		fmt.Fprintf(&buf, "%s_sum{%s} %g\n", phasesMetric, labels, s.sum)
+		fmt.Fprintf(&buf, "%s_count{%s} %d\n", phasesMetric, labels, s.count)
+	}
+	fmt.Fprintf(&buf, "# HELP %s How long the slowest run of the phases of the ec2 deployer took.\n", phasesMaxMetric)
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", phasesMaxMetric)
+	for _, labels := range keys {
+		fmt.Fprintf(&buf, "%s{%s} %g\n", phasesMaxMetric, labels, byLabels[labels].max)
+	}
+	fmt.Fprintf(&buf, "# HELP %s How many times the phases of the ec2 deployer failed.\n", phasesFailuresMetric)
+	fmt.Fprintf(&buf, "# TYPE %s counter\n", phasesFailuresMetric)
+	for _, labels := range keys {
+		fmt.Fprintf(&buf, "%s{%s} %d\n", phasesFailuresMetric, labels, byLabels[labels].failures)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPhasesMetrics(t *testing.T) {
+	phases := []phaseTiming{
+		{Phase: "launch", Role: "worker", ImageID: "ami-1", InstanceType: "m6i.large", Seconds: 30},
+		{Phase: "build", Seconds: 120.5},
+		{Phase: "launch", Role: "worker", ImageID: "ami-1", InstanceType: "m6i.large", Seconds: 45, Error: "InsufficientInstanceCapacity"},
+		{Phase: "launch", Role: "control-plane", ImageID: "ami-1", InstanceType: "m6i.large", Seconds: 20},
+	}
+	want := `# HELP kubetest2_ec2_phase_duration_seconds How long the phases of the ec2 deployer took.
+# TYPE kubetest2_ec2_phase_duration_seconds summary
+kubetest2_ec2_phase_duration_seconds_sum{phase="build",role="",ami="",instance_type=""} 120.5
+kubetest2_ec2_phase_duration_seconds_count{phase="build",role="",ami="",instance_type=""} 1
+kubetest2_ec2_phase_duration_seconds_sum{phase="launch",role="control-plane",ami="ami-1",instance_type="m6i.large"} 20
+kubetest2_ec2_phase_duration_seconds_count{phase="launch",role="control-plane",ami="ami-1",instance_type="m6i.large"} 1
+kubetest2_ec2_phase_duration_seconds_sum{phase="launch",role="worker",ami="ami-1",instance_type="m6i.large"} 75
+kubetest2_ec2_phase_duration_seconds_count{phase="launch",role="worker",ami="ami-1",instance_type="m6i.large"} 2
+# HELP kubetest2_ec2_phase_duration_max_seconds How long the slowest run of the phases of the ec2 deployer took.
+# TYPE kubetest2_ec2_phase_duration_max_seconds gauge
+kubetest2_ec2_phase_duration_max_seconds{phase="build",role="",ami="",instance_type=""} 120.5
+kubetest2_ec2_phase_duration_max_seconds{phase="launch",role="control-plane",ami="ami-1",instance_type="m6i.large"} 20
+kubetest2_ec2_phase_duration_max_seconds{phase="launch",role="worker",ami="ami-1",instance_type="m6i.large"} 45
+# HELP kubetest2_ec2_phase_failures_total How many times the phases of the ec2 deployer failed.
+# TYPE kubetest2_ec2_phase_failures_total counter
+kubetest2_ec2_phase_failures_total{phase="build",role="",ami="",instance_type=""} 0
+kubetest2_ec2_phase_failures_total{phase="launch",role="control-plane",ami="ami-1",instance_type="m6i.large"} 0
+kubetest2_ec2_phase_failures_total{phase="launch",role="worker",ami="ami-1",instance_type="m6i.large"} 1
+`
+	if got := string(phasesMetrics(phases)); got != want {
+		t.Errorf("phasesMetrics() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLoadEarlierPhases(t *testing.T) {
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	build := phaseTiming{Phase: "build", Start: start, Seconds: 120}
+	tests := []struct {
+		name string
+		// earlier is the phases.json of an earlier invocation, none if empty
+		earlier string
+		want    []string
+	}{
+		{
+			name: "first invocation",
+			want: []string{"up"},
+		},
+		{
+			name:    "same run",
+			earlier: "run-1",
+			want:    []string{"build", "up"},
+		},
+		{
+			name:    "other run",
+			earlier: "run-0",
+			want:    []string{"up"},
+		},
+		{
+			name:    "invalid file",
+			earlier: "invalid",
+			want:    []string{"up"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("ARTIFACTS", dir)
+			data := []byte("{")
+			if tc.earlier != "invalid" {
+				var err error
+				data, err = json.Marshal(phasesReport{RunID: tc.earlier, Phases: []phaseTiming{build}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.earlier != "" {
+				if err := os.WriteFile(filepath.Join(dir, phasesFile), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := &deployer{phases: newPhaseRecorder("run-1")}
+			d.startPhase("up").stop(errors.New("timed out"))
+
+			data, err := os.ReadFile(filepath.Join(dir, phasesFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var report phasesReport
+			if err := json.Unmarshal(data, &report); err != nil {
+				t.Fatalf("parsing %s: %v", phasesFile, err)
+			}
+			if report.RunID != "run-1" {
+				t.Errorf("%s is of run %s, want run-1", phasesFile, report.RunID)
+			}
+			var got []string
+			for _, phase := range report.Phases {
+				got = append(got, phase.Phase)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s has the phases %v, want %v", phasesFile, got, tc.want)
+			}
+			if last := report.Phases[len(report.Phases)-1]; last.Error != "timed out" {
+				t.Errorf("phase up failed with %q, want %q", last.Error, "timed out")
+			}
+			if _, err := os.Stat(filepath.Join(dir, phasesMetricsFile)); err != nil {
+				t.Errorf("%s was not written: %v", phasesMetricsFile, err)
+			}
+		})
+	}
+}
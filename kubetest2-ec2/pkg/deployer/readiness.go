@@ -0,0 +1,454 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/kubetest2/pkg/artifacts"
+)
+
+const (
+	readinessPollInterval = 15 * time.Second
+	// requestTimeout bounds every request to the API server, which may not answer
+	// while the control plane comes up
+	requestTimeout = 30 * time.Second
+
+	cloudControllerManagerSelector = "k8s-app=aws-cloud-controller-manager"
+
+	// podsGate is the name of the gates of --readiness-pod-selectors
+	podsGate               = "pods"
+	defaultPodsGateTimeout = 5 * time.Minute
+
+	readinessReport      = "readiness.json"
+	readinessJUnitReport = "junit_readiness.xml"
+)
+
+// cniDaemonSets are the daemonsets of the CNI plugins the cni gate knows, in kube-system
+var cniDaemonSets = []string{"aws-node", "cilium", "calico-node", "kube-flannel-ds"}
+
+// readinessGate is a check of the cluster Up() waits for
+type readinessGate struct {
+	// timeout is the default of the gate, --readiness-gate-timeouts overrides it
+	timeout time.Duration
+	// a failed optional gate is reported but does not fail Up()
+	optional bool
+	check    func(d *deployer, ctx context.Context, client kubernetes.Interface) error
+}
+
+// readinessGates are the gates --readiness-gates may enable
+var readinessGates = map[string]readinessGate{
+	"apiserver":  {timeout: 5 * time.Minute, check: (*deployer).waitForAPIServer},
+	"nodes":      {timeout: 15 * time.Minute, check: (*deployer).waitForNodesReady},
+	"cloud-init": {timeout: 5 * time.Minute, optional: true, check: (*deployer).waitForCloudInit},
+	"coredns":    {timeout: 5 * time.Minute, check: (*deployer).waitForCoreDNS},
+	"cni":        {timeout: 5 * time.Minute, check: (*deployer).waitForCNI},
+	"ccm":        {timeout: 5 * time.Minute, check: (*deployer).waitForExternalProviderPods},
+}
+
+// enabledGate is a readiness gate of the run
+type enabledGate struct {
+	name string
+	readinessGate
+}
+
+// gateResult is the outcome of a readiness gate
+type gateResult struct {
+	Name     string  `json:"name"`
+	Optional bool    `json:"optional,omitempty"`
+	Timeout  string  `json:"timeout"`
+	Seconds  float64 `json:"seconds"`
+	// Result is passed, failed or skipped, when an earlier gate failed
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readinessGatesOf returns the gates enabled by --readiness-gates and
+// --readiness-pod-selectors, with their timeouts
+func (d *deployer) readinessGatesOf() ([]enabledGate, error) {
+	names := d.ReadinessGates
+	if len(names) == 0 {
+		names = []string{"apiserver", "nodes", "cloud-init"}
+		if d.ExternalCloudProvider {
+			names = append(names, "ccm")
+		}
+	}
+	timeouts := map[string]time.Duration{}
+	for _, entry := range d.ReadinessGateTimeouts {
+		name, value, ok := strings.Cut(entry, "=")
+		_, known := readinessGates[name]
+		if !ok || (!known && name != podsGate) {
+			return nil, fmt.Errorf("invalid --readiness-gate-timeouts entry %q, expected <gate>=<duration>", entry)
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout of readiness gate %s: %q", name, value)
+		}
+		timeouts[name] = timeout
+	}
+
+	var gates []enabledGate
+	for _, name := range names {
+		gate, ok := readinessGates[name]
+		if !ok {
+			known := make([]string, 0, len(readinessGates))
+			for name := range readinessGates {
+				known = append(known, name)
+			}
+			slices.Sort(known)
+			return nil, fmt.Errorf("unknown readiness gate %q, expected one of %s", name, strings.Join(known, ", "))
+		}
+		if timeout, ok := timeouts[name]; ok {
+			gate.timeout = timeout
+		}
+		gates = append(gates, enabledGate{name: name, readinessGate: gate})
+	}
+	for _, entry := range d.ReadinessPodSelectors {
+		namespace, selector, ok := strings.Cut(entry, "/")
+		if !ok || namespace == "" {
+			return nil, fmt.Errorf("invalid --readiness-pod-selectors entry %q, expected <namespace>/<label selector>", entry)
+		}
+		if _, err := labels.Parse(selector); err != nil {
+			return nil, fmt.Errorf("invalid label selector of readiness gate %q: %w", entry, err)
+		}
+		timeout := defaultPodsGateTimeout
+		if t, ok := timeouts[podsGate]; ok {
+			timeout = t
+		}
+		gates = append(gates, enabledGate{
+			name: podsGate + ":" + entry,
+			readinessGate: readinessGate{
+				timeout: timeout,
+				check: func(d *deployer, ctx context.Context, client kubernetes.Interface) error {
+					return d.waitForPods(ctx, client, namespace, selector)
+				},
+			},
+		})
+	}
+	return gates, nil
+}
+
+// waitForReadinessGates runs the readiness gates in order and writes their report to
+// the artifacts directory. It stops at the first gate that fails, unless it is optional.
+func (d *deployer) waitForReadinessGates(ctx context.Context, client kubernetes.Interface) error {
+	gates, err := d.readinessGatesOf()
+	if err != nil {
+		return err
+	}
+	results := make([]gateResult, 0, len(gates))
+	var failed error
+	for _, gate := range gates {
+		result := gateResult{Name: gate.name, Optional: gate.optional, Timeout: gate.timeout.String(),
+			Result: "skipped"}
+		if failed != nil || ctx.Err() != nil {
+			results = append(results, result)
+			continue
+		}
+		klog.Infof("waiting up to %s for readiness gate %s of cluster %s", gate.timeout, gate.name, d.ClusterID)
+		start := time.Now()
+		timer := d.startPhase("readiness-" + gate.name)
+		gateCtx, cancel := context.WithTimeout(ctx, gate.timeout)
+		err := gate.check(d, gateCtx, client)
+		cancel()
+		timer.stop(err)
+		duration := time.Since(start)
+		result.Seconds = duration.Seconds()
+		result.Result = "passed"
+		if err != nil {
+			result.Result = "failed"
+			result.Error = err.Error()
+			if ctx.Err() != nil {
+				failed = err
+			} else if gate.optional {
+				klog.Warningf("optional readiness gate %s failed (continuing anyway): %v", gate.name, err)
+			} else {
+				failed = fmt.Errorf("readiness gate %s: %w", gate.name, err)
+			}
+		} else {
+			klog.Infof("readiness gate %s passed in %s", gate.name, duration.Round(time.Second))
+		}
+		results = append(results, result)
+	}
+	if err := writeReadinessReport(results); err != nil {
+		klog.Warningf("unable to write the readiness report: %v", err)
+	}
+	return failed
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeReadinessReport writes the results of the readiness gates as JSON and as
+// JUnit, which the CI shows next to the results of the tests
+func writeReadinessReport(results []gateResult) error {
+	dir := artifacts.BaseDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, readinessReport), data, 0644); err != nil {
+		return err
+	}
+
+	suite := junitTestSuite{Name: "kubetest2-ec2 readiness", Tests: len(results)}
+	for _, result := range results {
+		testCase := junitTestCase{
+			Name:      "readiness gate " + result.Name,
+			ClassName: "readiness",
+			Time:      result.Seconds,
+		}
+		switch result.Result {
+		case "failed":
+			// an optional gate is reported as skipped, it does not fail the run
+			if result.Optional {
+				testCase.Skipped = &struct{}{}
+				suite.Skipped++
+				break
+			}
+			testCase.Failure = &junitFailure{Message: result.Error, Text: result.Error}
+			suite.Failures++
+		case "skipped":
+			testCase.Skipped = &struct{}{}
+			suite.Skipped++
+		}
+		suite.Time += testCase.Time
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	data, err = xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+	return os.WriteFile(filepath.Join(dir, readinessJUnitReport), data, 0644)
+}
+
+// kubeClient returns a client of the cluster, from its downloaded kubeconfig
+func (d *deployer) kubeClient() (kubernetes.Interface, error) {
+	if d.KubeconfigPath == "" {
+		return nil, fmt.Errorf("the kubeconfig of cluster %s was not downloaded", d.ClusterID)
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", d.KubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", d.KubeconfigPath, err)
+	}
+	config.Timeout = requestTimeout
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating a client of cluster %s: %w", d.ClusterID, err)
+	}
+	return client, nil
+}
+
+// poll runs condition until it is done or ctx expires, the error of the last
+// attempt explains a timeout
+func poll(ctx context.Context, condition func(ctx context.Context) (bool, error)) error {
+	var lastErr error
+	err := wait.PollUntilContextCancel(ctx, readinessPollInterval, true, func(ctx context.Context) (bool, error) {
+		done, err := condition(ctx)
+		if err != nil {
+			lastErr = err
+			klog.V(2).Infof("%v", err)
+			return false, nil
+		}
+		return done, nil
+	})
+	if err != nil && lastErr != nil {
+		return fmt.Errorf("%w: %v", err, lastErr)
+	}
+	return err
+}
+
+// waitForAPIServer waits until the API server reports healthy
+func (d *deployer) waitForAPIServer(ctx context.Context, client kubernetes.Interface) error {
+	return poll(ctx, func(ctx context.Context) (bool, error) {
+		body, err := client.Discovery().RESTClient().Get().AbsPath("/healthz").Do(ctx).Raw()
+		if err != nil {
+			return false, fmt.Errorf("checking the health of the API server: %w", err)
+		}
+		return strings.TrimSpace(string(body)) == "ok", nil
+	})
+}
+
+// waitForNodesReady waits until a node registered for every instance of the cluster
+// and all the nodes are ready
+func (d *deployer) waitForNodesReady(ctx context.Context, client kubernetes.Interface) error {
+	want := len(d.runner.instances)
+	return poll(ctx, func(ctx context.Context) (bool, error) {
+		nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, fmt.Errorf("listing the nodes: %w", err)
+		}
+		if len(nodes.Items) < want {
+			klog.Infof("waiting for %d nodes in cluster %s, found %d", want, d.ClusterID, len(nodes.Items))
+			return false, fmt.Errorf("found %d of %d nodes", len(nodes.Items), want)
+		}
+		var notReady []string
+		for _, node := range nodes.Items {
+			if !nodeReady(&node) {
+				notReady = append(notReady, node.Name)
+			}
+		}
+		if len(notReady) > 0 {
+			klog.Infof("waiting for nodes %v of cluster %s to be ready", notReady, d.ClusterID)
+			return false, fmt.Errorf("nodes %v are not ready", notReady)
+		}
+		return true, nil
+	})
+}
+
+// waitForCloudInit waits until cloud-init finished on the control plane
+func (d *deployer) waitForCloudInit(ctx context.Context, _ kubernetes.Interface) error {
+	return d.waitForCloudInitComplete(ctx)
+}
+
+// waitForCoreDNS waits until the coredns deployment is available
+func (d *deployer) waitForCoreDNS(ctx context.Context, client kubernetes.Interface) error {
+	return poll(ctx, func(ctx context.Context) (bool, error) {
+		deployment, err := client.AppsV1().Deployments(metav1.NamespaceSystem).Get(ctx, "coredns", metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("getting the coredns deployment: %w", err)
+		}
+		for _, condition := range deployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentAvailable && condition.Status == corev1.ConditionTrue {
+				return true, nil
+			}
+		}
+		return false, fmt.Errorf("the coredns deployment is not available, %d of %d replicas are",
+			deployment.Status.AvailableReplicas, deployment.Status.Replicas)
+	})
+}
+
+// waitForCNI waits until the daemonset of the CNI plugin rolled out on every node
+func (d *deployer) waitForCNI(ctx context.Context, client kubernetes.Interface) error {
+	return poll(ctx, func(ctx context.Context) (bool, error) {
+		daemonSets, err := client.AppsV1().DaemonSets(metav1.NamespaceSystem).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, fmt.Errorf("listing the daemonsets: %w", err)
+		}
+		for _, ds := range daemonSets.Items {
+			if !slices.Contains(cniDaemonSets, ds.Name) {
+				continue
+			}
+			if !daemonSetRolledOut(&ds) {
+				return false, fmt.Errorf("daemonset %s is not rolled out, %d of %d pods are ready",
+					ds.Name, ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+			}
+			klog.Infof("the CNI daemonset %s of cluster %s rolled out", ds.Name, d.ClusterID)
+			return true, nil
+		}
+		return false, fmt.Errorf("none of the CNI daemonsets %v is in %s", cniDaemonSets, metav1.NamespaceSystem)
+	})
+}
+
+// waitForExternalProviderPods waits until the pods of the AWS cloud controller manager
+// are ready
+func (d *deployer) waitForExternalProviderPods(ctx context.Context, client kubernetes.Interface) error {
+	return d.waitForPods(ctx, client, metav1.NamespaceSystem, cloudControllerManagerSelector)
+}
+
+// waitForPods waits until there are pods matching the selector in the namespace, and
+// all of them are ready
+func (d *deployer) waitForPods(ctx context.Context, client kubernetes.Interface, namespace string, selector string) error {
+	return poll(ctx, func(ctx context.Context) (bool, error) {
+		pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, fmt.Errorf("listing the pods %s/%s: %w", namespace, selector, err)
+		}
+		if len(pods.Items) == 0 {
+			klog.Infof("waiting for pods %s/%s in cluster %s", namespace, selector, d.ClusterID)
+			return false, errors.New("no pod matches")
+		}
+		var notReady []string
+		for _, pod := range pods.Items {
+			if !podReady(&pod) {
+				notReady = append(notReady, pod.Name)
+			}
+		}
+		if len(notReady) > 0 {
+			klog.Infof("waiting for pods %v of cluster %s to be ready", notReady, d.ClusterID)
+			return false, fmt.Errorf("pods %v are not ready", notReady)
+		}
+		return true, nil
+	})
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func daemonSetRolledOut(ds *appsv1.DaemonSet) bool {
+	status := ds.Status
+	return status.ObservedGeneration >= ds.Generation &&
+		status.DesiredNumberScheduled > 0 &&
+		status.UpdatedNumberScheduled == status.DesiredNumberScheduled &&
+		status.NumberReady == status.DesiredNumberScheduled
+}
@@ -0,0 +1,343 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []corev1.NodeCondition
+		want       bool
+	}{
+		{
+			name: "no conditions",
+		},
+		{
+			name: "ready",
+			conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			},
+			want: true,
+		},
+		{
+			name:       "not ready",
+			conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}},
+		},
+		{
+			name:       "unknown",
+			conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionUnknown}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &corev1.Node{Status: corev1.NodeStatus{Conditions: tc.conditions}}
+			if got := nodeReady(node); got != tc.want {
+				t.Errorf("nodeReady() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPodReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []corev1.PodCondition
+		want       bool
+	}{
+		{
+			name: "no conditions",
+		},
+		{
+			name: "ready",
+			conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+			want: true,
+		},
+		{
+			name: "scheduled",
+			conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionFalse},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{Status: corev1.PodStatus{Conditions: tc.conditions}}
+			if got := podReady(pod); got != tc.want {
+				t.Errorf("podReady() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDaemonSetRolledOut(t *testing.T) {
+	tests := []struct {
+		name       string
+		generation int64
+		status     appsv1.DaemonSetStatus
+		want       bool
+	}{
+		{
+			name:       "rolled out",
+			generation: 2,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3},
+			want:       true,
+		},
+		{
+			name:       "not observed",
+			generation: 2,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3},
+		},
+		{
+			name:       "no pods scheduled",
+			generation: 1,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 1},
+		},
+		{
+			name:       "updating",
+			generation: 1,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 2, NumberReady: 3},
+		},
+		{
+			name:       "not ready",
+			generation: 1,
+			status:     appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 2},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ds := &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Generation: tc.generation},
+				Status:     tc.status,
+			}
+			if got := daemonSetRolledOut(ds); got != tc.want {
+				t.Errorf("daemonSetRolledOut() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPoll(t *testing.T) {
+	t.Run("done", func(t *testing.T) {
+		calls := 0
+		err := poll(context.Background(), func(ctx context.Context) (bool, error) {
+			calls++
+			return true, nil
+		})
+		if err != nil || calls != 1 {
+			t.Errorf("poll() returned %v after %d calls, want nil after 1", err, calls)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := poll(ctx, func(ctx context.Context) (bool, error) {
+			return false, errors.New("2 of 3 nodes")
+		})
+		if err == nil || !strings.Contains(err.Error(), "2 of 3 nodes") {
+			t.Errorf("poll() returned %v, want the error of the last attempt", err)
+		}
+	})
+}
+
+func TestReadinessGatesOf(t *testing.T) {
+	tests := []struct {
+		name                  string
+		gates                 []string
+		timeouts              []string
+		podSelectors          []string
+		externalCloudProvider bool
+		// want are <gate>=<timeout>
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: []string{"apiserver=5m0s", "nodes=15m0s", "cloud-init=5m0s"},
+		},
+		{
+			name:                  "defaults with an external cloud provider",
+			externalCloudProvider: true,
+			want:                  []string{"apiserver=5m0s", "nodes=15m0s", "cloud-init=5m0s", "ccm=5m0s"},
+		},
+		{
+			name:     "gates in order with timeouts",
+			gates:    []string{"nodes", "cni", "coredns"},
+			timeouts: []string{"cni=10m", "coredns=90s"},
+			want:     []string{"nodes=15m0s", "cni=10m0s", "coredns=1m30s"},
+		},
+		{
+			name:         "pod selectors",
+			gates:        []string{"apiserver"},
+			timeouts:     []string{"pods=2m"},
+			podSelectors: []string{"kube-system/k8s-app=ebs-csi-node", "monitoring/app in (prometheus)"},
+			want: []string{"apiserver=5m0s", "pods:kube-system/k8s-app=ebs-csi-node=2m0s",
+				"pods:monitoring/app in (prometheus)=2m0s"},
+		},
+		{
+			name:    "unknown gate",
+			gates:   []string{"storage"},
+			wantErr: true,
+		},
+		{
+			name:     "timeout of an unknown gate",
+			timeouts: []string{"storage=5m"},
+			wantErr:  true,
+		},
+		{
+			name:     "timeout without duration",
+			timeouts: []string{"nodes"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid timeout",
+			timeouts: []string{"nodes=soon"},
+			wantErr:  true,
+		},
+		{
+			name:     "negative timeout",
+			timeouts: []string{"nodes=-5m"},
+			wantErr:  true,
+		},
+		{
+			name:         "pod selector without namespace",
+			podSelectors: []string{"k8s-app=ebs-csi-node"},
+			wantErr:      true,
+		},
+		{
+			name:         "invalid pod selector",
+			podSelectors: []string{"kube-system/k8s-app in (ebs"},
+			wantErr:      true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &deployer{
+				ReadinessGates:        tc.gates,
+				ReadinessGateTimeouts: tc.timeouts,
+				ReadinessPodSelectors: tc.podSelectors,
+				ExternalCloudProvider: tc.externalCloudProvider,
+			}
+			gates, err := d.readinessGatesOf()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("readinessGatesOf() returned %v, want error %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []string
+			for _, gate := range gates {
+				if gate.check == nil {
+					t.Errorf("gate %s has no check", gate.name)
+				}
+				got = append(got, gate.name+"="+gate.timeout.String())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("readinessGatesOf() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteReadinessReport(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ARTIFACTS", dir)
+	results := []gateResult{
+		{Name: "apiserver", Timeout: "5m0s", Seconds: 12, Result: "passed"},
+		{Name: "cloud-init", Optional: true, Timeout: "5m0s", Seconds: 300, Result: "failed", Error: "timed out"},
+		{Name: "nodes", Timeout: "15m0s", Seconds: 900, Result: "failed", Error: "nodes [b] are not ready"},
+		{Name: "coredns", Timeout: "5m0s", Result: "skipped"},
+	}
+	if err := writeReadinessReport(results); err != nil {
+		t.Fatalf("writeReadinessReport() returned %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, readinessReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []gateResult
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("parsing %s: %v", readinessReport, err)
+	}
+	if !reflect.DeepEqual(written, results) {
+		t.Errorf("%s has %v, want %v", readinessReport, written, results)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, readinessJUnitReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		t.Fatalf("parsing %s: %v", readinessJUnitReport, err)
+	}
+	if suite.Tests != 4 || suite.Failures != 1 || suite.Skipped != 2 || suite.Time != 1212 {
+		t.Errorf("suite has %d tests, %d failures, %d skipped in %vs, want 4, 1, 2 in 1212s",
+			suite.Tests, suite.Failures, suite.Skipped, suite.Time)
+	}
+	tests := []struct {
+		name        string
+		wantFailure string
+		wantSkipped bool
+	}{
+		{name: "readiness gate apiserver"},
+		// a failed optional gate does not fail the run
+		{name: "readiness gate cloud-init", wantSkipped: true},
+		{name: "readiness gate nodes", wantFailure: "nodes [b] are not ready"},
+		{name: "readiness gate coredns", wantSkipped: true},
+	}
+	if len(suite.Cases) != len(tests) {
+		t.Fatalf("suite has %d test cases, want %d", len(suite.Cases), len(tests))
+	}
+	for i, tc := range tests {
+		testCase := suite.Cases[i]
+		if testCase.Name != tc.name {
+			t.Errorf("test case %d is %q, want %q", i, testCase.Name, tc.name)
+		}
+		failure := ""
+		if testCase.Failure != nil {
+			failure = testCase.Failure.Message
+		}
+		if failure != tc.wantFailure {
+			t.Errorf("test case %s failed with %q, want %q", tc.name, failure, tc.wantFailure)
+		}
+		if (testCase.Skipped != nil) != tc.wantSkipped {
+			t.Errorf("test case %s skipped %t, want %t", tc.name, testCase.Skipped != nil, tc.wantSkipped)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"k8s.io/klog/v2"
+)
+
+// knownHosts is the file host keys are pinned in. The keys are recorded under the
+// hostname (e.g. the instance ID) rather than its address, which may be the local
+// end of a tunnel and change from one run to the next. The hosts in firstUse have
+// no keys to pin yet, the first key they present is recorded.
+var knownHosts = struct {
+	sync.Mutex
+	path     string
+	firstUse map[string]bool
+}{}
+
+// SetKnownHostsFile makes ssh, scp and the native transport only accept the host
+// keys recorded in the file with AddKnownHostKeys. An empty path accepts any key.
+func SetKnownHostsFile(path string) {
+	knownHosts.Lock()
+	defer knownHosts.Unlock()
+	knownHosts.path = path
+	knownHosts.firstUse = nil
+}
+
+// TrustOnFirstUse makes the first connection to the hostname record the key it
+// presents, for hosts whose keys cannot be learnt ahead of it. Later connections
+// only accept that key.
+func TrustOnFirstUse(hostname string) {
+	knownHosts.Lock()
+	defer knownHosts.Unlock()
+	if knownHosts.firstUse == nil {
+		knownHosts.firstUse = map[string]bool{}
+	}
+	knownHosts.firstUse[hostname] = true
+}
+
+func trustsOnFirstUse(hostname string) bool {
+	knownHosts.Lock()
+	defer knownHosts.Unlock()
+	return knownHosts.firstUse[hostname]
+}
+
+func knownHostsFile() string {
+	knownHosts.Lock()
+	defer knownHosts.Unlock()
+	return knownHosts.path
+}
+
+// HasHostKeys tells whether keys of the hostname are recorded already
+func HasHostKeys(hostname string) bool {
+	knownHosts.Lock()
+	defer knownHosts.Unlock()
+	if knownHosts.path == "" {
+		return false
+	}
+	f, err := os.Open(knownHosts.path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), hostname+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// AddKnownHostKeys records the host keys of the hostname
+func AddKnownHostKeys(hostname string, keys []ssh.PublicKey) error {
+	knownHosts.Lock()
+	defer knownHosts.Unlock()
+	if knownHosts.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(knownHosts.path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(knownHosts.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening known hosts file: %w", err)
+	}
+	defer f.Close()
+	for _, key := range keys {
+		if _, err := fmt.Fprintln(f, knownhosts.Line([]string{hostname}, key)); err != nil {
+			return fmt.Errorf("writing known hosts file: %w", err)
+		}
+	}
+	return nil
+}
+
+// HostKeyCallback verifies the key of the hostname against the known hosts file,
+// whatever address it was dialed on. A host trusted on first use without known keys
+// has the key it presents recorded.
+func HostKeyCallback(hostname string) (ssh.HostKeyCallback, error) {
+	path := knownHostsFile()
+	if path == "" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if trustsOnFirstUse(hostname) {
+		// creates the file when no key is recorded at all yet
+		if err := AddKnownHostKeys(hostname, nil); err != nil {
+			return nil, err
+		}
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("no known host keys for %s: %w", hostname, err)
+	}
+	return func(_ string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(net.JoinHostPort(hostname, "22"), remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			if !trustsOnFirstUse(hostname) {
+				return fmt.Errorf("no known host keys for %s", hostname)
+			}
+			klog.Warningf("trusting the %s host key %s of %s on first use", key.Type(), ssh.FingerprintSHA256(key), hostname)
+			return AddKnownHostKeys(hostname, []ssh.PublicKey{key})
+		}
+		return err
+	}, nil
+}
+
+// jumpHostKeyCallback verifies the key of --ssh-jump-host, which is not a node of the
+// cluster, against the known hosts of the user
+func jumpHostKeyCallback() (ssh.HostKeyCallback, error) {
+	if knownHostsFile() == "" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	callback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("the key of the jump host must be in ~/.ssh/known_hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// knownHostsOptions are the options of the ssh and scp binaries to verify the key of
+// the host, nil when keys are not verified
+func knownHostsOptions(host string) []string {
+	path := knownHostsFile()
+	if path == "" {
+		return nil
+	}
+	strict := "yes"
+	if trustsOnFirstUse(host) {
+		// records the key under HostKeyAlias when there is none, rejects any other
+		strict = "accept-new"
+	}
+	return []string{
+		"-o", "UserKnownHostsFile=" + path,
+		"-o", "StrictHostKeyChecking=" + strict,
+		"-o", "HostKeyAlias=" + host,
+		"-o", "IdentitiesOnly=yes",
+		"-o", "CheckHostIP=no",
+		"-o", "ServerAliveInterval=30",
+		"-o", "LogLevel=ERROR",
+	}
+}
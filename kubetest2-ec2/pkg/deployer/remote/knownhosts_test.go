@@ -0,0 +1,176 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	pinned := newTestHostKey(t)
+	other := newTestHostKey(t)
+	tests := []struct {
+		name string
+		// knownHosts is the path of the known hosts file, relative to a temporary
+		// directory, none if empty
+		knownHosts string
+		hostname   string
+		key        ssh.PublicKey
+		wantErr    bool
+	}{
+		{
+			name:     "not pinned",
+			hostname: "i-0123",
+			key:      other,
+		},
+		{
+			name:       "pinned key",
+			knownHosts: "known_hosts",
+			hostname:   "i-0123",
+			key:        pinned,
+		},
+		{
+			name:       "other key",
+			knownHosts: "known_hosts",
+			hostname:   "i-0123",
+			key:        other,
+			wantErr:    true,
+		},
+		{
+			name:       "unknown host",
+			knownHosts: "known_hosts",
+			hostname:   "i-4567",
+			key:        pinned,
+			wantErr:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.knownHosts != "" {
+				SetKnownHostsFile(filepath.Join(t.TempDir(), "cluster", tc.knownHosts))
+			}
+			t.Cleanup(func() { SetKnownHostsFile("") })
+			if err := AddKnownHostKeys("i-0123", []ssh.PublicKey{pinned}); err != nil {
+				t.Fatalf("AddKnownHostKeys() returned %v", err)
+			}
+			if got, want := HasHostKeys("i-0123"), tc.knownHosts != ""; got != want {
+				t.Errorf("HasHostKeys(i-0123) = %t, want %t", got, want)
+			}
+			if HasHostKeys("i-01") {
+				t.Errorf("HasHostKeys(i-01) = true, want false")
+			}
+
+			callback, err := HostKeyCallback(tc.hostname)
+			if err != nil {
+				t.Fatalf("HostKeyCallback(%s) returned %v", tc.hostname, err)
+			}
+			// the address is the local end of a tunnel, only the hostname matters
+			remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40022}
+			err = callback("127.0.0.1:40022", remote, tc.key)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("verifying the key of %s returned %v, want error %t", tc.hostname, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	first := newTestHostKey(t)
+	other := newTestHostKey(t)
+	SetKnownHostsFile(filepath.Join(t.TempDir(), "known_hosts"))
+	t.Cleanup(func() { SetKnownHostsFile("") })
+	TrustOnFirstUse("i-0123")
+
+	if got := knownHostsOptions("i-0123"); !contains(got, "StrictHostKeyChecking=accept-new") {
+		t.Errorf("knownHostsOptions(i-0123) = %v, want StrictHostKeyChecking=accept-new", got)
+	}
+	if got := knownHostsOptions("i-4567"); !contains(got, "StrictHostKeyChecking=yes") {
+		t.Errorf("knownHostsOptions(i-4567) = %v, want StrictHostKeyChecking=yes", got)
+	}
+
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40022}
+	tests := []struct {
+		name     string
+		hostname string
+		key      ssh.PublicKey
+		wantErr  bool
+	}{
+		{
+			name:     "first use",
+			hostname: "i-0123",
+			key:      first,
+		},
+		{
+			name:     "same key",
+			hostname: "i-0123",
+			key:      first,
+		},
+		{
+			name:     "other key",
+			hostname: "i-0123",
+			key:      other,
+			wantErr:  true,
+		},
+		{
+			name:     "not trusted on first use",
+			hostname: "i-4567",
+			key:      first,
+			wantErr:  true,
+		},
+	}
+	// the steps run in order, each one sees the keys the previous ones recorded
+	for _, tc := range tests {
+		callback, err := HostKeyCallback(tc.hostname)
+		if err != nil {
+			t.Fatalf("%s: HostKeyCallback(%s) returned %v", tc.name, tc.hostname, err)
+		}
+		if err := callback("127.0.0.1:40022", remote, tc.key); (err != nil) != tc.wantErr {
+			t.Errorf("%s: verifying the key of %s returned %v, want error %t", tc.name, tc.hostname, err, tc.wantErr)
+		}
+	}
+	if !HasHostKeys("i-0123") {
+		t.Errorf("HasHostKeys(i-0123) = false, want the key of the first use recorded")
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
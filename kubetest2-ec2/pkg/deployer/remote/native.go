@@ -0,0 +1,431 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TransportNative runs commands with golang.org/x/crypto/ssh over pooled connections
+	TransportNative = "native"
+	// TransportExec runs the ssh and scp binaries for every command
+	TransportExec = "exec"
+
+	dialTimeout = 30 * time.Second
+)
+
+var sshTransport = flag.String("ssh-transport", TransportNative, "How to run remote commands: native or exec (the ssh and scp binaries).")
+var sshJumpHost = flag.String("ssh-jump-host", "", "[user@]host[:port] of a jump host to reach the nodes through, with the same key as the nodes.")
+var sshCommandTimeout = flag.Duration("ssh-command-timeout", 10*time.Minute, "Timeout of every remote command or copy, 0 means none.")
+
+// errNoNativeAuth means there is no private key to authenticate with natively, the ssh
+// binary may still manage with its agent or its configuration.
+var errNoNativeAuth = errors.New("no private ssh key to authenticate with")
+
+// clients pools one connection per host, and one to the jump host
+var clients = struct {
+	sync.Mutex
+	m    map[string]*ssh.Client
+	jump *ssh.Client
+}{m: make(map[string]*ssh.Client)}
+
+// CloseConnections closes all the pooled connections
+func CloseConnections() {
+	clients.Lock()
+	defer clients.Unlock()
+	for host, client := range clients.m {
+		client.Close()
+		delete(clients.m, host)
+	}
+	if clients.jump != nil {
+		clients.jump.Close()
+		clients.jump = nil
+	}
+}
+
+func useNative() bool {
+	return *sshTransport != TransportExec
+}
+
+// withCommandTimeout bounds ctx by --ssh-command-timeout
+func withCommandTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if *sshCommandTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, *sshCommandTimeout)
+}
+
+// hostAddress returns the address to dial for the host, following the
+// overrides of AddHostnameIP and AddHostPort
+func hostAddress(host string) string {
+	addr := host
+	hostnameIPOverrides.RLock()
+	if ip, found := hostnameIPOverrides.m[host]; found {
+		addr = ip
+	}
+	hostnameIPOverrides.RUnlock()
+	port := 22
+	hostPortOverrides.RLock()
+	if p, found := hostPortOverrides.m[host]; found {
+		port = p
+	}
+	hostPortOverrides.RUnlock()
+	return net.JoinHostPort(addr, strconv.Itoa(port))
+}
+
+func clientConfig(host string, user string, hostKeyCallback ssh.HostKeyCallback) (*ssh.ClientConfig, error) {
+	keyPath, err := getPrivateSSHKey(host)
+	if err != nil {
+		return nil, fmt.Errorf("private SSH key (%s) does not exist", keyPath)
+	}
+	if keyPath == "" {
+		return nil, errNoNativeAuth
+	}
+	pem, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		// e.g. a passphrase protected key, which only the agent of the ssh binary can use
+		return nil, fmt.Errorf("%w: parsing %s: %v", errNoNativeAuth, keyPath, err)
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         dialTimeout,
+	}, nil
+}
+
+// handshake runs the ssh handshake over conn, bounded by dialTimeout
+func handshake(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// jumpClient returns the pooled connection to --ssh-jump-host, dialing it if needed
+func jumpClient(ctx context.Context, host string) (*ssh.Client, error) {
+	clients.Lock()
+	client := clients.jump
+	clients.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	// dial without holding the pool, like getClient
+	user, addr := GetSSHUser(), *sshJumpHost
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		user, addr = addr[:i], addr[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	hostKeyCallback, err := jumpHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	// the jump host is reached with the key of the node behind it
+	config, err := clientConfig(host, user, hostKeyCallback)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing jump host %s: %w", addr, err)
+	}
+	client, err = handshake(conn, addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to jump host %s: %w", addr, err)
+	}
+
+	clients.Lock()
+	defer clients.Unlock()
+	if clients.jump != nil {
+		client.Close()
+		return clients.jump, nil
+	}
+	clients.jump = client
+	return client, nil
+}
+
+// getClient returns the pooled connection to the host, dialing it if needed
+func getClient(ctx context.Context, host string) (*ssh.Client, error) {
+	clients.Lock()
+	client, ok := clients.m[host]
+	clients.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	// dial without holding the pool, the nodes are usually all dialed at once
+	hostKeyCallback, err := HostKeyCallback(host)
+	if err != nil {
+		return nil, err
+	}
+	config, err := clientConfig(host, GetSSHUser(), hostKeyCallback)
+	if err != nil {
+		return nil, err
+	}
+	addr := hostAddress(host)
+	conn, err := dial(ctx, host, addr)
+	if err != nil {
+		return nil, err
+	}
+	client, err = handshake(conn, addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+
+	clients.Lock()
+	defer clients.Unlock()
+	if existing, ok := clients.m[host]; ok {
+		client.Close()
+		return existing, nil
+	}
+	clients.m[host] = client
+	return client, nil
+}
+
+// dial connects to the ssh port of the host, through --ssh-jump-host if set
+func dial(ctx context.Context, host string, addr string) (net.Conn, error) {
+	if *sshJumpHost == "" {
+		conn, err := (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w", addr, err)
+		}
+		return conn, nil
+	}
+	jump, err := jumpClient(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		// the jump connection may be stale, redial it next time
+		clients.Lock()
+		if clients.jump == jump {
+			clients.jump = nil
+		}
+		clients.Unlock()
+		jump.Close()
+		return nil, fmt.Errorf("dialing %s through %s: %w", addr, *sshJumpHost, err)
+	}
+	return conn, nil
+}
+
+// dropClient removes a connection that stopped working from the pool
+func dropClient(host string, client *ssh.Client) {
+	clients.Lock()
+	defer clients.Unlock()
+	if clients.m[host] == client {
+		delete(clients.m, host)
+	}
+	client.Close()
+}
+
+// newSession opens a session on the pooled connection, redialing once if the
+// connection went away since it was last used
+func newSession(ctx context.Context, host string) (*ssh.Session, error) {
+	client, err := getClient(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+	dropClient(host, client)
+	client, err = getClient(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	session, err = client.NewSession()
+	if err != nil {
+		dropClient(host, client)
+		return nil, err
+	}
+	return session, nil
+}
+
+// runNative runs the command like `ssh host -- cmd` would: its arguments are joined
+// and interpreted by the login shell of the remote user.
+func runNative(ctx context.Context, host string, cmd []string) (string, error) {
+	ctx, cancel := withCommandTimeout(ctx)
+	defer cancel()
+	session, err := newSession(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	command := strings.Join(cmd, " ")
+	klog.Infof("Running the command on %s: %s", host, command)
+	type result struct {
+		output []byte
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := session.CombinedOutput(command)
+		done <- result{output: output, err: err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			klog.Errorf("failed to run SSH command: out: %s, err: %v", r.output, r.err)
+			return string(r.output), fmt.Errorf("command [%s] on %s failed with error: %w", command, host, r.err)
+		}
+		return string(r.output), nil
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		session.Close()
+		return "", fmt.Errorf("command [%s] on %s: %w", command, host, ctx.Err())
+	}
+}
+
+// copyNative downloads srcPath from the host into localDir over SFTP, like `scp -r`
+func copyNative(ctx context.Context, host string, srcPath string, localDir string) error {
+	ctx, cancel := withCommandTimeout(ctx)
+	defer cancel()
+	client, err := getClient(ctx, host)
+	if err != nil {
+		return err
+	}
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		// maybe a stale connection, try a fresh one
+		dropClient(host, client)
+		if client, err = getClient(ctx, host); err != nil {
+			return err
+		}
+		if sftpClient, err = sftp.NewClient(client); err != nil {
+			return err
+		}
+	}
+	klog.Infof("Copying %s:%s to %s", host, srcPath, localDir)
+	done := make(chan error, 1)
+	go func() {
+		done <- copyTree(ctx, sftpClient, srcPath, localDir)
+	}()
+	select {
+	case err = <-done:
+		sftpClient.Close()
+	case <-ctx.Done():
+		// unblocks the pending request
+		sftpClient.Close()
+		<-done
+		err = ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("copying %s:%s: %w", host, srcPath, err)
+	}
+	return nil
+}
+
+// copyTree downloads the remote file, or directory recursively, into localDir the way
+// `scp -r` does. The last element of remotePath may be a glob pattern.
+func copyTree(ctx context.Context, client *sftp.Client, remotePath string, localDir string) error {
+	remotePath = strings.TrimSuffix(remotePath, "/")
+	sources, err := client.Glob(remotePath)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("%s: no such file or directory", remotePath)
+	}
+	var errs []error
+	for _, source := range sources {
+		if err := copyFile(ctx, client, source, filepath.Join(localDir, path.Base(source))); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func copyFile(ctx context.Context, client *sftp.Client, remotePath string, localPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// scp follows symlinks, so stat rather than lstat
+	info, err := client.Stat(remotePath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", remotePath, err)
+	}
+	switch {
+	case info.IsDir():
+		if err := os.MkdirAll(localPath, os.ModePerm); err != nil {
+			return err
+		}
+		children, err := client.ReadDir(remotePath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", remotePath, err)
+		}
+		var errs []error
+		for _, child := range children {
+			if err := copyFile(ctx, client, path.Join(remotePath, child.Name()), filepath.Join(localPath, child.Name())); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	case info.Mode().IsRegular():
+		return download(client, remotePath, localPath)
+	default:
+		return nil
+	}
+}
+
+func download(client *sftp.Client, remotePath string, localPath string) error {
+	src, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", remotePath, err)
+	}
+	defer src.Close()
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("%s: %w", remotePath, err)
+	}
+	return dst.Close()
+}
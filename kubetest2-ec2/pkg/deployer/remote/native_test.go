@@ -0,0 +1,171 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestSFTPClient serves files, keyed by path, from an in-memory SFTP server.
+// Values starting with "->" are symlinks to the rest of the value.
+func newTestSFTPClient(t *testing.T, files map[string]string) *sftp.Client {
+	t.Helper()
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter}, sftp.InMemHandler())
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatalf("starting the sftp client: %v", err)
+	}
+	t.Cleanup(func() {
+		// the client waits for the server to hang up
+		server.Close()
+		client.Close()
+	})
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := client.MkdirAll(path.Dir(name)); err != nil {
+			t.Fatalf("creating %s: %v", path.Dir(name), err)
+		}
+		content := files[name]
+		if len(content) > 2 && content[:2] == "->" {
+			continue
+		}
+		f, err := client.Create(name)
+		if err != nil {
+			t.Fatalf("creating %s: %v", name, err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+		f.Close()
+	}
+	for _, name := range names {
+		if content := files[name]; len(content) > 2 && content[:2] == "->" {
+			if err := client.Symlink(content[2:], name); err != nil {
+				t.Fatalf("linking %s: %v", name, err)
+			}
+		}
+	}
+	return client
+}
+
+// localFiles returns the content of the files under dir keyed by their relative path
+func localFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, name)
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking %s: %v", dir, err)
+	}
+	return files
+}
+
+func TestCopyTree(t *testing.T) {
+	remote := map[string]string{
+		"/var/log/kubelet.log":           "kubelet",
+		"/var/log/pods/a/0.log":          "pod a",
+		"/var/log/pods/b/0.log":          "pod b",
+		"/var/log/containers/a.log":      "->/var/log/pods/a/0.log",
+		"/tmp/results/junit_01.xml":      "junit 1",
+		"/tmp/results/junit_02.xml":      "junit 2",
+		"/tmp/results/build-log.txt":     "build",
+		"/tmp/results/nested/junit_9.xs": "nested",
+	}
+	tests := []struct {
+		name       string
+		remotePath string
+		want       map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "file",
+			remotePath: "/var/log/kubelet.log",
+			want:       map[string]string{"kubelet.log": "kubelet"},
+		},
+		{
+			name:       "directory",
+			remotePath: "/var/log/pods/",
+			want:       map[string]string{"pods/a/0.log": "pod a", "pods/b/0.log": "pod b"},
+		},
+		{
+			name:       "symlink",
+			remotePath: "/var/log/containers",
+			want:       map[string]string{"containers/a.log": "pod a"},
+		},
+		{
+			name:       "glob",
+			remotePath: "/tmp/results/junit*",
+			want:       map[string]string{"junit_01.xml": "junit 1", "junit_02.xml": "junit 2"},
+		},
+		{
+			name:       "missing file",
+			remotePath: "/var/log/missing.log",
+			want:       map[string]string{},
+			wantErr:    true,
+		},
+		{
+			name:       "glob without matches",
+			remotePath: "/tmp/results/*.tar",
+			want:       map[string]string{},
+			wantErr:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newTestSFTPClient(t, remote)
+			localDir := t.TempDir()
+			err := copyTree(context.Background(), client, tc.remotePath, localDir)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("copyTree(%s) returned %v, want error %t", tc.remotePath, err, tc.wantErr)
+			}
+			if got := localFiles(t, localDir); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("copyTree(%s) copied %v, want %v", tc.remotePath, got, tc.want)
+			}
+		})
+	}
+}
@@ -17,11 +17,14 @@ limitations under the License.
 package remote
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -70,6 +73,19 @@ func AddHostnameIP(hostname, ip string) {
 	hostnameIPOverrides.m[hostname] = ip
 }
 
+var hostPortOverrides = struct {
+	sync.RWMutex
+	m map[string]int
+}{m: make(map[string]int)}
+
+// AddHostPort makes ssh and scp connect to the hostname on the given port instead of 22,
+// e.g. the local end of a tunnel.
+func AddHostPort(hostname string, port int) {
+	hostPortOverrides.Lock()
+	defer hostPortOverrides.Unlock()
+	hostPortOverrides.m[hostname] = port
+}
+
 var sshKeyOverrides = struct {
 	sync.RWMutex
 	m map[string]string
@@ -112,17 +128,41 @@ func GetHostnameOrIP(hostname string) string {
 
 // SSH executes ssh command with runSSHCommand as root. The `sudo` makes sure that all commands
 // are executed by root, so that there won't be permission mismatch between different commands.
-func SSH(host string, cmd ...string) (string, error) {
-	return runSSHCommand(host, "ssh", append([]string{GetHostnameOrIP(host), "--", "sudo"}, cmd...)...)
+// The command is killed when the context is done, or after --ssh-command-timeout.
+func SSH(ctx context.Context, host string, cmd ...string) (string, error) {
+	if useNative() {
+		output, err := runNative(ctx, host, append([]string{"sudo"}, cmd...))
+		if !errors.Is(err, errNoNativeAuth) {
+			return output, err
+		}
+		klog.Warningf("falling back to the ssh binary for %s: %v", host, err)
+	}
+	ctx, cancel := withCommandTimeout(ctx)
+	defer cancel()
+	return runSSHCommand(ctx, host, "ssh", append([]string{GetHostnameOrIP(host), "--", "sudo"}, cmd...)...)
 }
 
-func SCP(host string, srcDir string, logDir string) (string, error) {
-	return runSSHCommand(host, "scp", "-r",
+// SCP copies srcDir from the host into logDir recursively, srcDir may end with a glob
+// pattern. The native transport copies over SFTP, which runs as the ssh user.
+func SCP(ctx context.Context, host string, srcDir string, logDir string) (string, error) {
+	if useNative() {
+		err := copyNative(ctx, host, srcDir, logDir)
+		if !errors.Is(err, errNoNativeAuth) {
+			if err != nil {
+				return err.Error(), err
+			}
+			return "", nil
+		}
+		klog.Warningf("falling back to the scp binary for %s: %v", host, err)
+	}
+	ctx, cancel := withCommandTimeout(ctx)
+	defer cancel()
+	return runSSHCommand(ctx, host, "scp", "-r",
 		fmt.Sprintf("%s:%s", GetHostnameOrIP(host), srcDir), logDir)
 }
 
 // runSSHCommand executes the ssh or scp command, adding the flag provided --ssh-options
-func runSSHCommand(host, cmd string, args ...string) (string, error) {
+func runSSHCommand(ctx context.Context, host, cmd string, args ...string) (string, error) {
 	if key, err := getPrivateSSHKey(host); len(key) != 0 {
 		if err != nil {
 			klog.Errorf("private SSH key (%s) not found. Check if the SSH key is configured properly:, err: %v", key, err)
@@ -131,14 +171,28 @@ func runSSHCommand(host, cmd string, args ...string) (string, error) {
 
 		args = append([]string{"-i", key}, args...)
 	}
-	if env, found := sshOptionsMap[*sshEnv]; found {
+	hostPortOverrides.RLock()
+	port, found := hostPortOverrides.m[host]
+	hostPortOverrides.RUnlock()
+	if found {
+		// scp takes the port with a capital P
+		portFlag := "-p"
+		if cmd == "scp" {
+			portFlag = "-P"
+		}
+		args = append([]string{portFlag, strconv.Itoa(port)}, args...)
+	}
+	if options := knownHostsOptions(host); options != nil {
+		// replaces the environment options, which accept any host key
+		args = append(options, args...)
+	} else if env, found := sshOptionsMap[*sshEnv]; found {
 		args = append(strings.Split(env, " "), args...)
 	}
 	if *sshOptions != "" {
 		args = append(strings.Split(*sshOptions, " "), args...)
 	}
 	klog.Infof("Running the command %s, with args: %v", cmd, args)
-	output, err := exec.Command(cmd, args...).CombinedOutput()
+	output, err := exec.CommandContext(ctx, cmd, args...).CombinedOutput()
 	if err != nil {
 		klog.Errorf("failed to run SSH command: out: %s, err: %v", output, err)
 		return string(output), fmt.Errorf("command [%s %s] failed with error: %w", cmd, strings.Join(args, " "), err)
@@ -0,0 +1,160 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// createdResources tracks what Up() created besides the instances themselves,
+// so that a failed Up() can be rolled back.
+type createdResources struct {
+	mu sync.Mutex
+	// security group ID -> IDs of the ingress rules we added to it
+	securityGroupRules map[string][]string
+	tempFiles          []string
+	loadBalancer       bool
+	network            bool
+}
+
+func (c *createdResources) addSecurityGroupRules(groupID string, ruleIDs []string) {
+	if len(ruleIDs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.securityGroupRules == nil {
+		c.securityGroupRules = map[string][]string{}
+	}
+	c.securityGroupRules[groupID] = append(c.securityGroupRules[groupID], ruleIDs...)
+}
+
+func (c *createdResources) setLoadBalancer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadBalancer = true
+}
+
+func (c *createdResources) setNetwork() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.network = true
+}
+
+func (c *createdResources) addTempFile(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tempFiles = append(c.tempFiles, path)
+}
+
+// terminateInstances terminates all the known instances of the cluster
+func (d *deployer) terminateInstances(ctx context.Context) error {
+	if len(d.runner.instances) == 0 {
+		klog.Infof("no instances found for cluster %s, nothing to delete", d.ClusterID)
+		return nil
+	}
+	return d.runner.terminate(ctx, d.runner.instances)
+}
+
+func (a *AWSRunner) terminate(ctx context.Context, instances []*awsInstance) error {
+	var instanceIDs []string
+	for _, instance := range instances {
+		instanceIDs = append(instanceIDs, instance.instanceID)
+	}
+	_, err := a.ec2Service.TerminateInstances(ctx, &ec2v2.TerminateInstancesInput{
+		InstanceIds: instanceIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete instances %v : %w", instanceIDs, err)
+	}
+	klog.Infof("deleted instance ids: %v", instanceIDs)
+	return nil
+}
+
+// rollbackUp collects the logs of a failed Up() and deletes everything it created,
+// unless --keep-on-failure is set.
+func (d *deployer) rollbackUp(ctx context.Context, upErr error) error {
+	klog.Errorf("Up() failed: %v", upErr)
+	if err := d.dumpClusterLogs(ctx); err != nil {
+		klog.Warningf("Dumping cluster logs when Up() failed: %s", err)
+	}
+	if d.KeepOnFailure {
+		klog.Warningf("--keep-on-failure is set, leaving cluster %s up for debugging, use --down to delete it", d.ClusterID)
+		return nil
+	}
+
+	klog.Infof("rolling back cluster %s", d.ClusterID)
+	remote.CloseConnections()
+	d.runner.closeTunnels(ctx)
+	var errs []error
+	if err := d.terminateInstances(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := d.unmergeKubeconfig(); err != nil {
+		klog.Warningf("unable to remove context %s from %s: %v", d.ClusterID, d.MergeKubeconfig, err)
+	}
+
+	created := &d.runner.created
+	created.mu.Lock()
+	defer created.mu.Unlock()
+	if created.loadBalancer {
+		if err := utils.DeleteAPIServerLoadBalancer(ctx, d.runner.elbService, d.ClusterID); err != nil {
+			errs = append(errs, err)
+		} else {
+			created.loadBalancer = false
+		}
+	}
+	if created.network {
+		if err := utils.DeleteClusterNetwork(ctx, d.runner.ec2Service, d.ClusterID); err != nil {
+			errs = append(errs, err)
+		} else {
+			created.network = false
+		}
+	}
+	for groupID, ruleIDs := range created.securityGroupRules {
+		if err := utils.RevokeSecurityGroupIngress(ctx, d.runner.ec2Service, groupID, ruleIDs); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		klog.Infof("revoked ingress rules %v of security group %s", ruleIDs, groupID)
+		// so that a later Down() does not revoke them again
+		delete(d.runner.apiServerIngressRules, groupID)
+	}
+	created.securityGroupRules = nil
+	for _, file := range created.tempFiles {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	created.tempFiles = nil
+
+	if len(errs) == 0 {
+		removeClusterState()
+	}
+	return errors.Join(errs...)
+}
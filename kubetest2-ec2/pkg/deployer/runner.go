@@ -20,9 +20,10 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"os"
-	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -33,6 +34,7 @@ import (
 	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	ec2instanceconnectv2 "github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
 	iamv2 "github.com/aws/aws-sdk-go-v2/service/iam"
 	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
 	ssmv2 "github.com/aws/aws-sdk-go-v2/service/ssm"
@@ -40,7 +42,6 @@ import (
 	"golang.org/x/crypto/ssh"
 	"k8s.io/klog/v2"
 
-	"sigs.k8s.io/kubetest2/pkg/fs"
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/config"
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
@@ -53,23 +54,51 @@ type AWSRunner struct {
 	ssmService         *ssmv2.Client
 	iamService         *iamv2.Client
 	s3Service          *s3v2.Client
+	elbService         *elbv2.Client
 	instanceNamePrefix string
 	internalAWSImages  []utils.InternalAWSImage
 	instances          []*awsInstance
 	token              string
 	certificateKey     string
 	controlPlaneIP     string
-	subnetID           string
+	vpcID              string
+	subnets            []utils.Subnet
 	sshKeyMu           sync.Mutex // guards kube_aws_rsa creation in assignNewSSHKey
+	created            createdResources
+	// availability zones each of the instance types is offered in, nil when unknown
+	instanceTypeZones map[string][]string
+	// subnet of the control plane, the workers are launched next to it when possible
+	subnetID string
+	// security group the instances are launched in
+	securityGroupID string
+	// load balancer in front of the API servers when there is more than one control plane
+	loadBalancer *utils.LoadBalancer
+	// security group ID -> IDs of the ingress rules opening the API server port to the load balancer
+	apiServerIngressRules map[string][]string
+	// controlPlaneEndpoint is the DNS name of loadBalancer, nodes join the cluster
+	// through it instead of controlPlaneIP when set
+	controlPlaneEndpoint string
+	// subnets of the workers when they differ from the ones of the control plane
+	workerSubnets []utils.Subnet
+	// network is the VPC created for the cluster with --create-vpc
+	network *utils.ClusterNetwork
+	// SSM tunnels to the nodes with --private-nodes, by instance ID and port
+	tunnelsMu sync.Mutex
+	tunnels   map[string]*utils.SSMTunnel
+	// version is the Kubernetes version the nodes install
+	version string
 }
 
 type awsInstance struct {
 	instance         *ec2typesv2.Instance
 	instanceID       string
+	role             string
 	sshKey           *utils.TemporarySSHKey
 	publicIP         string
 	privateIP        string
 	sshPublicKeyFile string
+	// sshAddress is where to dial SSH, the public IP or the local end of an SSM tunnel
+	sshAddress string
 }
 
 var operatingSystems = []string{
@@ -77,23 +106,72 @@ var operatingSystems = []string{
 	"al2023",
 }
 
-func (a *AWSRunner) Validate() error {
+// osImageParameter returns the SSM parameter holding the latest AMI of one of
+// operatingSystems for the architecture, ubuntu if it is empty, and the default user
+// data file for it
+func osImageParameter(operatingSystem string, arch string) (string, string, error) {
+	switch operatingSystem {
+	case "al2023":
+		if arch == "amd64" {
+			return "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64", "al2023.sh", nil
+		}
+		return "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-" + arch, "al2023.sh", nil
+	case "ubuntu", "":
+		return "/aws/service/canonical/ubuntu/server/26.04/stable/20260722/" + arch + "/hvm/ebs-gp3/ami-id", "ubuntu2604.yaml", nil
+	default:
+		return "", "", fmt.Errorf("unrecognized operating system %q", operatingSystem)
+	}
+}
+
+func (a *AWSRunner) Validate(ctx context.Context) error {
 	// Mutual exclusion: cannot use both device plugin and DRA
 	if a.deployer.DevicePluginNvidia && a.deployer.DRANvidia {
 		return fmt.Errorf("--device-plugin-nvidia and --dra-nvidia are mutually exclusive; use one or the other")
 	}
+	if err := a.deployer.validateArchs(); err != nil {
+		return err
+	}
+	// before the launch options, it sets --num-nodes
+	if err := a.deployer.loadClusterSpec(); err != nil {
+		return err
+	}
+	if err := a.deployer.validateLaunchOptions(); err != nil {
+		return err
+	}
+	if err := a.deployer.validateNetworkOptions(); err != nil {
+		return err
+	}
+	if _, err := a.deployer.loadLogSpec(); err != nil {
+		return err
+	}
+	if _, err := a.deployer.readinessGatesOf(); err != nil {
+		return err
+	}
+	if err := a.deployer.validateTags(); err != nil {
+		return err
+	}
+	if err := a.deployer.validateMaxLifetime(); err != nil {
+		return err
+	}
 
-	_, err := a.InitializeServices()
+	_, err := a.InitializeServices(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to initialize AWS services : %w", err)
 	}
 
+	if a.deployer.ArtifactsBucket != "" {
+		artifactsBucket, _ := a.deployer.artifactsBucket()
+		if _, err := a.s3Service.HeadBucket(ctx, &s3v2.HeadBucketInput{Bucket: awsv2.String(artifactsBucket)}); err != nil {
+			return fmt.Errorf("unable to access --artifacts-bucket %s: %w", artifactsBucket, err)
+		}
+	}
+
 	bucket := a.deployer.BuildOptions.CommonBuildOptions.StageLocation
 	if bucket == "" {
 		return fmt.Errorf("please specify --stage with the s3 bucket")
 	}
 	if !strings.Contains(bucket, "://") {
-		_, err = a.s3Service.HeadBucket(context.TODO(),
+		_, err = a.s3Service.HeadBucket(ctx,
 			&s3v2.HeadBucketInput{Bucket: awsv2.String(bucket)})
 		if err != nil {
 			return fmt.Errorf("unable to find bucket %q, %v", bucket, err)
@@ -101,48 +179,31 @@ func (a *AWSRunner) Validate() error {
 	}
 
 	if a.deployer.Image == "" || slices.Contains(operatingSystems, a.deployer.Image) {
-		arch := strings.Split(a.deployer.BuildOptions.CommonBuildOptions.TargetBuildArch, "/")[1]
-
-		path := ""
-		switch a.deployer.Image {
-		case "al2023":
-			if arch == "amd64" {
-				path = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64"
-			} else {
-				path = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-" + arch
-			}
-			if a.deployer.UserDataFile == "" {
-				a.deployer.UserDataFile = "al2023.sh"
-			}
-		case "ubuntu", "":
-			path = "/aws/service/canonical/ubuntu/server/26.04/stable/20260722/" + arch + "/hvm/ebs-gp3/ami-id"
-			if a.deployer.UserDataFile == "" {
-				a.deployer.UserDataFile = "ubuntu2604.yaml"
-			}
-		default:
+		arch := a.deployer.controlPlaneArch()
+
+		path, userDataFile, err := osImageParameter(a.deployer.Image, arch)
+		if err != nil {
 			return fmt.Errorf("unrecognized parameter --image : %s", a.deployer.Image)
 		}
+		if a.deployer.UserDataFile == "" {
+			a.deployer.UserDataFile = userDataFile
+		}
 		klog.Infof("looking up latest image in SSM:")
 		klog.Infof("%s", path)
 
-		id, err := utils.GetSSMImage(a.ssmService, path)
+		id, err := utils.GetSSMImage(ctx, a.ssmService, path)
 		if err == nil {
 			klog.Infof("using image id from ssm %s", id)
 			a.deployer.Image = id
 		} else {
 			return fmt.Errorf("error looking up ssm : %w", err)
 		}
-
-		// Looks like we need an arm64 image and the default instance type is amd64, so
-		// pick an equivalent image to t3a.medium which is t4g.medium.
-		if a.deployer.InstanceType == defaultAMD64InstanceType && arch == "arm64" {
-			a.deployer.InstanceType = defaultARM64InstanceTYpe
-		}
-		if a.deployer.WorkerInstanceType == defaultAMD64InstanceType && arch == "arm64" {
-			a.deployer.WorkerInstanceType = defaultARM64InstanceTYpe
-		}
 	}
 
+	// the default instance types follow the architecture of each role
+	a.deployer.InstanceType = instanceTypeForArch(a.deployer.InstanceType, a.deployer.controlPlaneArch())
+	a.deployer.WorkerInstanceType = instanceTypeForArch(a.deployer.WorkerInstanceType, a.deployer.workerArch())
+
 	if len(a.deployer.Image) == 0 {
 		return fmt.Errorf("must specify an Ubuntu AMI using --image")
 	}
@@ -152,45 +213,24 @@ func (a *AWSRunner) Validate() error {
 	}
 
 	if a.deployer.WorkerImage == "" || slices.Contains(operatingSystems, a.deployer.WorkerImage) {
-		arch := strings.Split(a.deployer.BuildOptions.CommonBuildOptions.TargetBuildArch, "/")[1]
-
-		path := ""
-		switch a.deployer.WorkerImage {
-		case "al2023":
-			if arch == "amd64" {
-				path = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-x86_64"
-			} else {
-				path = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-" + arch
-			}
-			if a.deployer.WorkerUserDataFile == "" {
-				a.deployer.WorkerUserDataFile = "al2023.sh"
-			}
-		case "ubuntu", "":
-			path = "/aws/service/canonical/ubuntu/server/26.04/stable/20260722/" + arch + "/hvm/ebs-gp3/ami-id"
-			if a.deployer.WorkerUserDataFile == "" {
-				a.deployer.WorkerUserDataFile = "ubuntu2604.yaml"
-			}
-		default:
+		arch := a.deployer.workerArch()
+
+		path, userDataFile, err := osImageParameter(a.deployer.WorkerImage, arch)
+		if err != nil {
 			return fmt.Errorf("unrecognized parameter --worker-image : %s", a.deployer.WorkerImage)
 		}
+		if a.deployer.WorkerUserDataFile == "" {
+			a.deployer.WorkerUserDataFile = userDataFile
+		}
 		klog.Infof("looking up latest image in SSM:")
 		klog.Infof("%s", path)
-		id, err := utils.GetSSMImage(a.ssmService, path)
+		id, err := utils.GetSSMImage(ctx, a.ssmService, path)
 		if err == nil {
 			klog.Infof("using image id from ssm %s", id)
 			a.deployer.WorkerImage = id
 		} else {
 			return fmt.Errorf("error looking up ssm : %w", err)
 		}
-
-		// Looks like we need an arm64 image and the default instance type is amd64, so
-		// pick an equivalent image to t3a.medium which is t4g.medium.
-		if a.deployer.InstanceType == defaultAMD64InstanceType && arch == "arm64" {
-			a.deployer.InstanceType = defaultARM64InstanceTYpe
-		}
-		if a.deployer.WorkerInstanceType == defaultAMD64InstanceType && arch == "arm64" {
-			a.deployer.WorkerInstanceType = defaultARM64InstanceTYpe
-		}
 	}
 
 	if len(a.deployer.WorkerImage) == 0 {
@@ -200,24 +240,35 @@ func (a *AWSRunner) Validate() error {
 	if !strings.HasPrefix(a.deployer.WorkerImage, "ami-") {
 		return fmt.Errorf("invalid AMI id format for %q", a.deployer.WorkerImage)
 	}
+	if err = a.resolveNodePools(ctx); err != nil {
+		return err
+	}
 
-	if err = a.ensureInstanceProfileAndRole(); err != nil {
+	if err = a.ensureInstanceProfileAndRole(ctx); err != nil {
 		return fmt.Errorf("while creating instance profile / roles : %v", err)
 	}
 
-	a.internalAWSImages, err = a.prepareAWSImages()
+	a.internalAWSImages, err = a.prepareAWSImages(ctx)
 	if err != nil {
 		return fmt.Errorf("while preparing AWS images: %v", err)
 	}
 	return nil
 }
 
-func (a *AWSRunner) isAWSInstanceRunning(testInstance *awsInstance) (*awsInstance, error) {
+// isAWSInstanceRunning waits until the instance runs, is reachable and its node is set up
+func (a *AWSRunner) isAWSInstanceRunning(ctx context.Context, testInstance *awsInstance) (*awsInstance, error) {
+	timer := a.deployer.startPhase("instance-ready").forInstance(testInstance)
+	instance, err := a.waitForAWSInstance(ctx, testInstance)
+	timer.forInstance(instance).stop(err)
+	return instance, err
+}
+
+func (a *AWSRunner) waitForAWSInstance(ctx context.Context, testInstance *awsInstance) (*awsInstance, error) {
 	instanceRunning := false
 	createdSSHKey := false
 	klog.Infof("waiting for %s to start (5 mins)", testInstance.instanceID)
 
-	err := ec2v2.NewInstanceRunningWaiter(a.ec2Service).Wait(context.TODO(), &ec2v2.DescribeInstancesInput{
+	err := ec2v2.NewInstanceRunningWaiter(a.ec2Service).Wait(ctx, &ec2v2.DescribeInstancesInput{
 		InstanceIds: []string{testInstance.instanceID},
 	}, 5*time.Minute)
 
@@ -226,10 +277,12 @@ func (a *AWSRunner) isAWSInstanceRunning(testInstance *awsInstance) (*awsInstanc
 	}
 	for i := 0; i < 30 && !instanceRunning; i++ {
 		if i > 0 {
-			time.Sleep(time.Second * 15)
+			if err := utils.Sleep(ctx, time.Second*15); err != nil {
+				return testInstance, fmt.Errorf("waiting for instance %s: %w", testInstance.instanceID, err)
+			}
 		}
 
-		op, err := a.ec2Service.DescribeInstances(context.TODO(),
+		op, err := a.ec2Service.DescribeInstances(ctx,
 			&ec2v2.DescribeInstancesInput{
 				InstanceIds: []string{testInstance.instanceID},
 			})
@@ -251,18 +304,27 @@ func (a *AWSRunner) isAWSInstanceRunning(testInstance *awsInstance) (*awsInstanc
 				NetworkInterfaceId: networkInterfaceID,
 				SourceDestCheck:    &ec2typesv2.AttributeBooleanValue{Value: awsv2.Bool(false)},
 			}
-			_, err = a.ec2Service.ModifyNetworkInterfaceAttribute(context.TODO(), modifyInput)
+			_, err = a.ec2Service.ModifyNetworkInterfaceAttribute(ctx, modifyInput)
 			if err != nil {
 				klog.Infof("unable to set SourceDestCheck on instance %s", testInstance.instanceID)
 			}
 		}
-		testInstance.publicIP = *instance.PublicIpAddress
+		testInstance.instance = &instance
+		testInstance.publicIP = awsv2.ToString(instance.PublicIpAddress)
 		testInstance.privateIP = *instance.PrivateIpAddress
 
+		if err = a.registerSSHHost(ctx, testInstance); err != nil {
+			continue
+		}
+		if err = a.learnHostKeys(ctx, testInstance); err != nil {
+			klog.Infof("host keys of %s: %v", testInstance.instanceID, err)
+			continue
+		}
+
 		// generate a temporary SSH key and send it to the node via instance-connect
 		if a.deployer.Ec2InstanceConnect && !createdSSHKey {
 			klog.Info("instance-connect flag is set, using ec2 instance connect to configure a temporary SSH key")
-			err = a.assignNewSSHKey(testInstance)
+			err = a.assignNewSSHKey(ctx, testInstance)
 			if err != nil {
 				klog.Infof("instance connect err = %s", err)
 				continue
@@ -270,12 +332,9 @@ func (a *AWSRunner) isAWSInstanceRunning(testInstance *awsInstance) (*awsInstanc
 			createdSSHKey = true
 		}
 
-		klog.Infof("registering %s/%s", testInstance.instanceID, testInstance.publicIP)
-		remote.AddHostnameIP(testInstance.instanceID, testInstance.publicIP)
-
 		// ensure that containerd or CRIO is running
 		var output string
-		output, err = remote.SSH(testInstance.instanceID, "sh", "-c", "systemctl list-units  --type=service  --state=running | grep -e containerd -e crio")
+		output, err = remote.SSH(ctx, testInstance.instanceID, "sh", "-c", "systemctl list-units  --type=service  --state=running | grep -e containerd -e crio")
 		if err != nil {
 			err = fmt.Errorf("instance %s not running containerd/crio daemon - Command failed: %s", testInstance.instanceID, output)
 			continue
@@ -286,7 +345,7 @@ func (a *AWSRunner) isAWSInstanceRunning(testInstance *awsInstance) (*awsInstanc
 			continue
 		}
 
-		output, err = remote.SSH(testInstance.instanceID, "sh", "-c", "systemctl status cloud-init.service")
+		output, err = remote.SSH(ctx, testInstance.instanceID, "sh", "-c", "systemctl status cloud-init.service")
 		if err != nil {
 			err = fmt.Errorf("checking instance %s is running cloud-init - Command failed: %s", testInstance.instanceID, output)
 			continue
@@ -297,12 +356,12 @@ func (a *AWSRunner) isAWSInstanceRunning(testInstance *awsInstance) (*awsInstanc
 		}
 
 		if a.controlPlaneIP == *testInstance.instance.PrivateIpAddress {
-			output, err = remote.SSH(testInstance.instanceID, "kubectl --kubeconfig /etc/kubernetes/admin.conf version")
+			output, err = remote.SSH(ctx, testInstance.instanceID, "kubectl --kubeconfig /etc/kubernetes/admin.conf version")
 			if err != nil {
 				err = fmt.Errorf("checking instance %s is api server running - Command failed: %s", testInstance.instanceID, output)
 				continue
 			}
-			output, err = remote.SSH(testInstance.instanceID, "kubectl --kubeconfig /etc/kubernetes/admin.conf get nodes -o name")
+			output, err = remote.SSH(ctx, testInstance.instanceID, "kubectl --kubeconfig /etc/kubernetes/admin.conf get nodes -o name")
 			if err != nil {
 				err = fmt.Errorf("checking instance %s is node present - Command failed: %s", testInstance.instanceID, output)
 				continue
@@ -321,10 +380,17 @@ func (a *AWSRunner) isAWSInstanceRunning(testInstance *awsInstance) (*awsInstanc
 	} else {
 		if a.controlPlaneIP == *testInstance.instance.PrivateIpAddress {
 			if a.deployer.KubeconfigPath == "" {
-				a.deployer.KubeconfigPath = downloadKubeConfig(testInstance.instanceID, testInstance.publicIP)
-				klog.Infof("Updating $HOME/.kube/config")
-				home, _ := os.UserHomeDir()
-				_ = fs.CopyFile(a.deployer.KubeconfigPath, filepath.Join(home, ".kube", "config"))
+				address, serverName, err := a.apiServerAddress(ctx, testInstance)
+				if err != nil {
+					return testInstance, err
+				}
+				a.deployer.KubeconfigPath, err = downloadKubeConfig(ctx, testInstance.instanceID, address, serverName)
+				if err != nil {
+					return testInstance, err
+				}
+				if err := a.deployer.mergeKubeconfig(); err != nil {
+					klog.Warningf("unable to merge the kubeconfig into %s: %v", a.deployer.MergeKubeconfig, err)
+				}
 			}
 		}
 	}
@@ -332,9 +398,9 @@ func (a *AWSRunner) isAWSInstanceRunning(testInstance *awsInstance) (*awsInstanc
 	return testInstance, nil
 }
 
-func (a *AWSRunner) InitializeServices() (*awsv2.Config, error) {
+func (a *AWSRunner) InitializeServices(ctx context.Context) (*awsv2.Config, error) {
 
-	cfg, err := configv2.LoadDefaultConfig(context.TODO(),
+	cfg, err := configv2.LoadDefaultConfig(ctx,
 		configv2.WithRegion(a.deployer.Region),
 	)
 	if err != nil {
@@ -346,6 +412,7 @@ func (a *AWSRunner) InitializeServices() (*awsv2.Config, error) {
 	a.ssmService = ssmv2.NewFromConfig(cfg)
 	a.iamService = iamv2.NewFromConfig(cfg)
 	a.s3Service = s3v2.NewFromConfig(cfg)
+	a.elbService = elbv2.NewFromConfig(cfg)
 	a.deployer.BuildOptions.CommonBuildOptions.S3Service = a.s3Service
 	a.deployer.BuildOptions.CommonBuildOptions.S3Uploader = s3managerv2.NewUploader(a.s3Service, func(u *s3managerv2.Uploader) {
 		u.PartSize = 10 * 1024 * 1024 // 10 MB
@@ -354,22 +421,20 @@ func (a *AWSRunner) InitializeServices() (*awsv2.Config, error) {
 	return &cfg, nil
 }
 
-func (a *AWSRunner) ensureInstanceProfileAndRole() error {
-	err := utils.EnsureRole(a.iamService, a.deployer.RoleName)
+func (a *AWSRunner) ensureInstanceProfileAndRole(ctx context.Context) error {
+	err := utils.EnsureRole(ctx, a.iamService, a.deployer.RoleName, a.deployer.sharedResourceTags())
 	if err != nil {
 		klog.Infof("error with ensure role: %v\n", err)
 	}
-	err = utils.EnsureInstanceProfile(a.iamService, a.deployer.InstanceProfile,
-		a.deployer.RoleName)
+	err = utils.EnsureInstanceProfile(ctx, a.iamService, a.deployer.InstanceProfile,
+		a.deployer.RoleName, a.deployer.sharedResourceTags())
 	if err != nil {
 		klog.Infof("error with ensure instance profile: %v\n", err)
 	}
 	return err
 }
 
-func (a *AWSRunner) prepareAWSImages() ([]utils.InternalAWSImage, error) {
-	var ret []utils.InternalAWSImage
-
+func (a *AWSRunner) prepareAWSImages(ctx context.Context) ([]utils.InternalAWSImage, error) {
 	var version string
 	var err error
 	if a.deployer.BuildOptions.CommonBuildOptions.StageVersion == "" {
@@ -381,51 +446,138 @@ func (a *AWSRunner) prepareAWSImages() ([]utils.InternalAWSImage, error) {
 	} else {
 		version = a.deployer.BuildOptions.CommonBuildOptions.StageVersion
 	}
+	a.version = version
 
-	err = utils.ValidateS3Bucket(a.s3Service,
+	err = utils.ValidateS3Bucket(ctx, a.s3Service,
 		a.deployer.BuildOptions.CommonBuildOptions.StageLocation,
 		a.deployer.BuildOptions.CommonBuildOptions.StageVersion,
 		version)
 	if err != nil {
 		return nil, fmt.Errorf("unable to validate s3 bucket : %w", err)
 	}
+	err = utils.ValidateStagedArchs(ctx, a.s3Service,
+		a.deployer.BuildOptions.CommonBuildOptions.StageLocation, version, a.deployer.clusterArchs())
+	if err != nil {
+		return nil, err
+	}
+	return a.awsImages(version)
+}
 
-	userControlPlane, err := a.getUserData(a.deployer.UserDataFile, version, true)
+// awsImages returns the images of the control plane and the workers of the version
+func (a *AWSRunner) awsImages(version string) ([]utils.InternalAWSImage, error) {
+	var ret []utils.InternalAWSImage
+	userControlPlane, err := a.getUserData(a.deployer.UserDataFile, version, kubeadmInit, nil)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load controlplane user data %s : %w", a.deployer.UserDataFile, err)
 	}
-	if len(userControlPlane) > 16384 { // 16KB
-		return nil, fmt.Errorf("worker user data is too large, must be less than 16384 bytes, is %d\n\n%s", len(userControlPlane), userControlPlane)
+	controlPlaneSize, err := utils.UserDataSize(userControlPlane)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress user data: %w", err)
+	}
+	if controlPlaneSize > utils.MaxUserDataSize {
+		return nil, fmt.Errorf("worker user data is too large, must be less than 16384 bytes gzipped, is %d\n\n%s", controlPlaneSize, userControlPlane)
+	}
+
+	var userJoinControlPlane string
+	if a.deployer.ControlPlaneCount > 1 {
+		userJoinControlPlane, err = a.getUserData(a.deployer.UserDataFile, version, kubeadmJoinControlPlane, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load controlplane user data %s : %w", a.deployer.UserDataFile, err)
+		}
 	}
 
-	userDataWorkerNode, err := a.getUserData(a.deployer.WorkerUserDataFile, version, false)
+	userDataWorkerNode, err := a.getUserData(a.deployer.WorkerUserDataFile, version, kubeadmJoin, nil)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load worker user data %s : %w", a.deployer.WorkerUserDataFile, err)
 	}
-	if len(userDataWorkerNode) > 16384 { // 16KB
-		return nil, fmt.Errorf("worker user data is too large, must be less than 16384 bytes, is %d\n\n%s", len(userDataWorkerNode), userDataWorkerNode)
+	workerSize, err := utils.UserDataSize(userDataWorkerNode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress user data: %w", err)
+	}
+	if workerSize > utils.MaxUserDataSize {
+		return nil, fmt.Errorf("worker user data is too large, must be less than 16384 bytes gzipped, is %d\n\n%s", workerSize, userDataWorkerNode)
 	}
 
+	instanceTypes := a.deployer.InstanceTypes
+	if len(instanceTypes) == 0 {
+		instanceTypes = []string{a.deployer.InstanceType}
+	}
+	workerInstanceTypes := a.deployer.WorkerInstanceTypes
+	if len(workerInstanceTypes) == 0 {
+		workerInstanceTypes = []string{a.deployer.WorkerInstanceType}
+	}
+
+	tags := a.deployer.resourceTags()
 	klog.Infof("using %s for control plane image", a.deployer.Image)
 	klog.Infof("using %s for worker node image", a.deployer.WorkerImage)
 	ret = append(ret, utils.InternalAWSImage{
-		AmiID:           a.deployer.Image,
-		UserData:        userControlPlane,
-		InstanceType:    a.deployer.InstanceType,
-		InstanceProfile: a.deployer.InstanceProfile,
+		AmiID:            a.deployer.Image,
+		UserData:         userControlPlane,
+		InstanceType:     instanceTypes[0],
+		InstanceTypes:    instanceTypes,
+		InstanceProfile:  a.deployer.InstanceProfile,
+		Role:             utils.RoleControlPlane,
+		CapacityType:     a.deployer.CapacityType,
+		SecurityGroupIDs: a.deployer.SecurityGroupIDs,
+		NoPublicIP:       a.deployer.PrivateNodes,
+		Tags:             tags,
+
+		TerminateOnShutdown: !a.deployer.expiresAt.IsZero(),
 	})
+	for i := 1; i < a.deployer.ControlPlaneCount; i++ {
+		ret = append(ret, utils.InternalAWSImage{
+			AmiID:            a.deployer.Image,
+			UserData:         userJoinControlPlane,
+			InstanceType:     instanceTypes[0],
+			InstanceTypes:    instanceTypes,
+			InstanceProfile:  a.deployer.InstanceProfile,
+			Role:             utils.RoleControlPlane,
+			CapacityType:     a.deployer.CapacityType,
+			SecurityGroupIDs: a.deployer.SecurityGroupIDs,
+			NoPublicIP:       a.deployer.PrivateNodes,
+			Tags:             tags,
+
+			TerminateOnShutdown: !a.deployer.expiresAt.IsZero(),
+		})
+	}
+	if len(a.deployer.nodePools) > 0 {
+		workers, err := a.nodePoolImages(version, tags)
+		if err != nil {
+			return nil, err
+		}
+		return append(ret, workers...), nil
+	}
 	for i := 0; i < a.deployer.NumNodes; i++ {
 		ret = append(ret, utils.InternalAWSImage{
-			AmiID:           a.deployer.WorkerImage,
-			UserData:        userDataWorkerNode,
-			InstanceType:    a.deployer.WorkerInstanceType,
-			InstanceProfile: a.deployer.InstanceProfile,
+			AmiID:            a.deployer.WorkerImage,
+			UserData:         userDataWorkerNode,
+			InstanceType:     workerInstanceTypes[0],
+			InstanceTypes:    workerInstanceTypes,
+			InstanceProfile:  a.deployer.InstanceProfile,
+			Role:             utils.RoleWorker,
+			CapacityType:     a.deployer.WorkerCapacityType,
+			SecurityGroupIDs: a.deployer.SecurityGroupIDs,
+			NoPublicIP:       a.deployer.PrivateNodes,
+			Tags:             tags,
+
+			TerminateOnShutdown: !a.deployer.expiresAt.IsZero(),
 		})
 	}
 	return ret, nil
 }
 
-func (a *AWSRunner) getUserData(dataFile string, version string, controlPlane bool) (string, error) {
+const (
+	// kubeadmInit is the first control plane node, it runs kubeadm init
+	kubeadmInit = "init"
+	// kubeadmJoinControlPlane is any other control plane node, it joins with the certificate key
+	kubeadmJoinControlPlane = "join-control-plane"
+	// kubeadmJoin is a worker node
+	kubeadmJoin = "join"
+)
+
+// getUserData renders the user data template dataFile for the kubeadm mode.
+// kubeletArgs are added to the kubeletExtraArgs of the join configuration.
+func (a *AWSRunner) getUserData(dataFile string, version string, kubeadmMode string, kubeletArgs []utils.KubeletArg) (string, error) {
 	var userdata string
 	if dataFile != "" {
 		_, err := config.ConfigFS.Open(dataFile)
@@ -491,15 +643,30 @@ func (a *AWSRunner) getUserData(dataFile string, version string, controlPlane bo
 	}
 	userdata = strings.ReplaceAll(userdata, "{{KUBEADM_INIT_YAML}}", yamlString)
 
+	// the join configuration is either a file of its own or part of the template
+	addedKubeletArgs := len(kubeletArgs) == 0
 	yamlString, err = utils.FetchKubeadmJoinYaml(a.deployer.KubeadmJoinFile, func(data string) string {
 		data = strings.ReplaceAll(data, "{{EXTERNAL_CLOUD_PROVIDER}}", provider)
 		data = strings.ReplaceAll(data, "{{FEATURE_GATES}}", a.deployer.FeatureGates)
+		if len(kubeletArgs) > 0 && strings.Contains(userdata, "{{KUBEADM_JOIN_YAML}}") {
+			var added bool
+			data, added = utils.WithKubeletExtraArgs(data, kubeletArgs)
+			addedKubeletArgs = addedKubeletArgs || added
+		}
 		return data
 	})
 	if err != nil {
 		return "", fmt.Errorf("unable to fetch kubeadm-join.yaml : %w", err)
 	}
 	userdata = strings.ReplaceAll(userdata, "{{KUBEADM_JOIN_YAML}}", yamlString)
+	if !addedKubeletArgs {
+		var added bool
+		userdata, added = utils.WithKubeletExtraArgs(userdata, kubeletArgs)
+		if !added {
+			return "", fmt.Errorf("found no kubeletExtraArgs in the join configuration of %s to add %d kubelet arguments to",
+				dataFile, len(kubeletArgs))
+		}
+	}
 
 	scriptString, err := utils.FetchRunKubeadmSH(func(data string) string {
 		data = strings.ReplaceAll(data, "{{STAGING_BUCKET}}",
@@ -576,75 +743,237 @@ func (a *AWSRunner) getUserData(dataFile string, version string, controlPlane bo
 	}
 	userdata = strings.ReplaceAll(userdata, "{{RUN_POST_INSTALL_SH}}", scriptString)
 
-	if controlPlane {
+	if kubeadmMode == kubeadmInit {
 		userdata = strings.ReplaceAll(userdata, "{{KUBEADM_CONTROL_PLANE}}", "true")
 	} else {
 		userdata = strings.ReplaceAll(userdata, "{{KUBEADM_CONTROL_PLANE}}", "false")
 	}
-	return userdata, nil
-}
-
-func (a *AWSRunner) createAWSInstance(img utils.InternalAWSImage) (*awsInstance, error) {
-	if a.deployer.SSHUser == "" {
-		return nil, fmt.Errorf("please set '--ssh-user' parameter")
+	if kubeadmMode == kubeadmJoinControlPlane {
+		userdata = strings.ReplaceAll(userdata, "{{KUBEADM_JOIN_CONTROL_PLANE}}", "true")
 	} else {
-		err := flag.Set("ssh-user", a.deployer.SSHUser)
-		if err != nil {
-			return nil, fmt.Errorf("unable to set flag ssh-user: %w", err)
-		}
-		err = flag.Set("ssh-env", "aws")
+		userdata = strings.ReplaceAll(userdata, "{{KUBEADM_JOIN_CONTROL_PLANE}}", "false")
+	}
+	if !a.deployer.expiresAt.IsZero() {
+		userdata, err = utils.WithMaxLifetime(userdata, a.deployer.expiresAt)
 		if err != nil {
-			return nil, fmt.Errorf("unable to set flag ssh-env: %w", err)
+			return "", fmt.Errorf("unable to add --max-lifetime to user data: %w", err)
 		}
 	}
+	return userdata, nil
+}
+
+// joinAddress is the address nodes use to reach the API server when joining the cluster
+func (a *AWSRunner) joinAddress() string {
+	if a.controlPlaneEndpoint != "" {
+		return a.controlPlaneEndpoint
+	}
+	return a.controlPlaneIP
+}
 
-	if a.subnetID == "" {
-		var err error
-		var vpcID string
-		a.subnetID, vpcID, err = utils.PickSubnetID(a.ec2Service, a.deployer.IPFamily)
+// controlPlaneEndpointAddress is the controlPlaneEndpoint of the kubeadm ClusterConfiguration,
+// empty for a single control plane node
+func (a *AWSRunner) controlPlaneEndpointAddress() string {
+	if a.controlPlaneEndpoint == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", a.controlPlaneEndpoint, utils.APIServerPort)
+}
+
+// apiServerAddress is the host:port kubeconfig files should point at to reach the API
+// server through the given control plane node. Through an SSM tunnel the address is a
+// local one, so it also returns the server name to verify the certificate against.
+func (a *AWSRunner) apiServerAddress(ctx context.Context, controlPlane *awsInstance) (string, string, error) {
+	if a.deployer.PrivateNodes {
+		tunnel, err := a.tunnel(ctx, controlPlane.instanceID, utils.APIServerPort)
 		if err != nil {
-			return nil, fmt.Errorf("picking subnet: %w in vpc (%s)", err, vpcID)
+			return "", "", err
 		}
-		// Best effort: the KUBE_SSH_BASTION hop needs tcp/22 between
-		// instances; do not fail if the CI role cannot edit the group.
-		if err := utils.EnsureSSHSelfIngress(a.ec2Service, vpcID); err != nil {
-			klog.Warningf("could not ensure ssh ingress within default security group: %v", err)
+		// kubeadm always puts this name in the serving certificate of the API server
+		return tunnel.Address(), "kubernetes", nil
+	}
+	host := controlPlane.publicIP
+	if a.controlPlaneEndpoint != "" {
+		host = a.controlPlaneEndpoint
+	}
+	return net.JoinHostPort(host, strconv.Itoa(utils.APIServerPort)), "", nil
+}
+
+// configureSSH points the remote package at the ssh user and environment of the deployer
+func (a *AWSRunner) configureSSH() error {
+	if a.deployer.SSHUser == "" {
+		return fmt.Errorf("please set '--ssh-user' parameter")
+	}
+	err := flag.Set("ssh-user", a.deployer.SSHUser)
+	if err != nil {
+		return fmt.Errorf("unable to set flag ssh-user: %w", err)
+	}
+	err = flag.Set("ssh-env", "aws")
+	if err != nil {
+		return fmt.Errorf("unable to set flag ssh-env: %w", err)
+	}
+	switch a.deployer.SSHTransport {
+	case remote.TransportNative, remote.TransportExec:
+	default:
+		return fmt.Errorf("unrecognized parameter --ssh-transport : %s", a.deployer.SSHTransport)
+	}
+	if a.deployer.SSHVerifyHostKeys {
+		remote.SetKnownHostsFile(knownHostsPath(a.deployer.ClusterID))
+	} else {
+		remote.SetKnownHostsFile("")
+	}
+	for name, value := range map[string]string{
+		"ssh-transport":       a.deployer.SSHTransport,
+		"ssh-jump-host":       a.deployer.SSHJumpHost,
+		"ssh-command-timeout": a.deployer.SSHCommandTimeout.String(),
+	} {
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("unable to set flag %s: %w", name, err)
 		}
 	}
+	return nil
+}
 
+// createAWSInstance launches an instance of the image
+func (a *AWSRunner) createAWSInstance(ctx context.Context, img utils.InternalAWSImage) (*awsInstance, error) {
+	timer := a.deployer.startPhase("run-instances").forImage(img)
+	instance, err := a.launchAWSInstance(ctx, img)
+	timer.forInstance(instance).stop(err)
+	return instance, err
+}
+
+func (a *AWSRunner) launchAWSInstance(ctx context.Context, img utils.InternalAWSImage) (*awsInstance, error) {
+	img.UserData = strings.ReplaceAll(img.UserData, "{{CONTROL_PLANE_ENDPOINT}}", a.controlPlaneEndpointAddress())
+	subnets := a.subnets
+	if img.Role == utils.RoleWorker && len(a.workerSubnets) > 0 {
+		subnets = a.workerSubnets
+	}
+	placements := utils.Placements(img.InstanceTypes, subnets, a.instanceTypeZones, a.subnetID)
+	if len(placements) == 0 {
+		return nil, fmt.Errorf("none of the instance types %v is offered in the subnets of vpc %s", img.InstanceTypes, a.vpcID)
+	}
 	var instance *ec2typesv2.Instance
-	newInstance, err := utils.LaunchNewInstance(
-		a.ec2Service,
-		a.iamService,
-		a.deployer.ClusterID,
-		a.controlPlaneIP,
-		img,
-		a.subnetID,
-		a.deployer.IPFamily)
+	var err error
+	for _, placement := range placements {
+		img.InstanceType = placement.InstanceType
+		instance, err = utils.LaunchNewInstance(
+			ctx,
+			a.ec2Service,
+			a.iamService,
+			a.deployer.ClusterID,
+			a.joinAddress(),
+			img,
+			placement.Subnet.ID,
+			a.deployer.IPFamily)
+		if err == nil {
+			if a.subnetID == "" {
+				a.subnetID = placement.Subnet.ID
+			}
+			break
+		}
+		if !utils.IsCapacityError(err) || ctx.Err() != nil {
+			break
+		}
+		klog.Warningf("no capacity for %s in %s, trying the next placement: %v",
+			placement.InstanceType, placement.Subnet.AvailabilityZone, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to launch instance : %w", err)
 	}
-	instance = newInstance
-	klog.Infof("launched new instance %s with ami-id: %s on instance type: %s",
-		*instance.InstanceId, *instance.ImageId, instance.InstanceType)
+	klog.Infof("launched new %s instance %s with ami-id: %s on instance type: %s",
+		utils.InstanceTag(*instance, utils.CapacityTypeTagKey), *instance.InstanceId, *instance.ImageId, instance.InstanceType)
 
-	if instance.PublicIpAddress == nil {
-		return nil, fmt.Errorf("missing public ip address for instance id : %s", *instance.InstanceId)
+	// the instance exists from here on, return it even on error so it gets cleaned up
+	testInstance := &awsInstance{
+		instanceID: *instance.InstanceId,
+		instance:   instance,
+		role:       img.Role,
+	}
+	if instance.PublicIpAddress == nil && !img.NoPublicIP {
+		return testInstance, fmt.Errorf("missing public ip address for instance id : %s", *instance.InstanceId)
 	}
 	if instance.PrivateIpAddress == nil {
-		return nil, fmt.Errorf("missing private ip address for instance id : %s", *instance.InstanceId)
+		return testInstance, fmt.Errorf("missing private ip address for instance id : %s", *instance.InstanceId)
 	}
-	return &awsInstance{
-		instanceID: *instance.InstanceId,
-		instance:   instance,
-		publicIP:   *instance.PublicIpAddress,
-		privateIP:  *instance.PrivateIpAddress,
-	}, nil
+	testInstance.publicIP = awsv2.ToString(instance.PublicIpAddress)
+	testInstance.privateIP = *instance.PrivateIpAddress
+	return testInstance, nil
+}
+
+// prepareSubnets creates or looks up the subnets instances can be launched in, and
+// looks up the availability zones that offer the instance types we need.
+func (a *AWSRunner) prepareSubnets(ctx context.Context) error {
+	if a.deployer.CreateVPC {
+		if err := a.createNetwork(ctx); err != nil {
+			return err
+		}
+	} else if err := a.useExistingNetwork(ctx); err != nil {
+		return err
+	}
+
+	var instanceTypes []string
+	for _, img := range a.internalAWSImages {
+		for _, instanceType := range img.InstanceTypes {
+			if !slices.Contains(instanceTypes, instanceType) {
+				instanceTypes = append(instanceTypes, instanceType)
+			}
+		}
+	}
+	zones, err := utils.InstanceTypeZones(ctx, a.ec2Service, instanceTypes)
+	if err != nil {
+		// without the offerings every subnet is a candidate, capacity errors still
+		// move on to the next one
+		klog.Warningf("unable to look up instance type offerings, trying all subnets: %v", err)
+		zones = nil
+	} else {
+		for _, instanceType := range instanceTypes {
+			klog.Infof("instance type %s is offered in %v", instanceType, zones[instanceType])
+		}
+	}
+	a.instanceTypeZones = zones
+	return nil
+}
+
+// useExistingNetwork looks up the subnets of the default VPC, or of the one passed on
+// the command line. Subnets and security groups passed on the command line are used
+// as is, they are only validated.
+func (a *AWSRunner) useExistingNetwork(ctx context.Context) error {
+	subnets, vpcID, err := utils.ListSubnets(ctx, a.ec2Service, a.deployer.VpcID, a.deployer.SubnetIDs, a.deployer.IPFamily)
+	if err != nil {
+		return fmt.Errorf("picking subnets: %w", err)
+	}
+	var workerSubnets []utils.Subnet
+	if len(a.deployer.WorkerSubnetIDs) > 0 {
+		workerSubnets, _, err = utils.ListSubnets(ctx, a.ec2Service, vpcID, a.deployer.WorkerSubnetIDs, a.deployer.IPFamily)
+		if err != nil {
+			return fmt.Errorf("picking worker subnets: %w", err)
+		}
+	}
+
+	if len(a.deployer.SecurityGroupIDs) > 0 {
+		if err := utils.ValidateSecurityGroups(ctx, a.ec2Service, vpcID, a.deployer.SecurityGroupIDs); err != nil {
+			return err
+		}
+		a.securityGroupID = a.deployer.SecurityGroupIDs[0]
+	} else {
+		// Best effort: the KUBE_SSH_BASTION hop needs tcp/22 between
+		// instances; do not fail if the CI role cannot edit the group.
+		groupID, ruleIDs, err := utils.EnsureSSHSelfIngress(ctx, a.ec2Service, vpcID)
+		if err != nil {
+			klog.Warningf("could not ensure ssh ingress within default security group: %v", err)
+		}
+		a.created.addSecurityGroupRules(groupID, ruleIDs)
+		a.securityGroupID = groupID
+	}
+
+	a.vpcID = vpcID
+	a.subnets = subnets
+	a.workerSubnets = workerSubnets
+	return nil
 }
 
 // assignNewSSHKey generates a new SSH key-pair and assigns it to the EC2 instance using EC2-instance connect. It then
 // connects via SSH and makes the key permanent by writing it to ~/.ssh/authorized_keys
-func (a *AWSRunner) assignNewSSHKey(testInstance *awsInstance) error {
+func (a *AWSRunner) assignNewSSHKey(ctx context.Context, testInstance *awsInstance) error {
 	var key *utils.TemporarySSHKey
 	var err error
 
@@ -674,7 +1003,7 @@ func (a *AWSRunner) assignNewSSHKey(testInstance *awsInstance) error {
 			klog.Info("loading existing kube_aws_rsa key")
 			key, err = utils.LoadExistingSSHKey("kube_aws_rsa")
 		} else {
-			klog.Infof("assigning new SSH key-pair for %s@%s", a.deployer.SSHUser, testInstance.publicIP)
+			klog.Infof("assigning new SSH key-pair for %s@%s", a.deployer.SSHUser, testInstance.sshAddress)
 			key, err = utils.GenerateSSHKeypair()
 			if err == nil {
 				err = utils.SaveSSHKeyAs(key, "kube_aws_rsa")
@@ -686,7 +1015,7 @@ func (a *AWSRunner) assignNewSSHKey(testInstance *awsInstance) error {
 		}
 	}
 	testInstance.sshKey = key
-	_, err = a.ec2icService.SendSSHPublicKey(context.TODO(), &ec2instanceconnectv2.SendSSHPublicKeyInput{
+	_, err = a.ec2icService.SendSSHPublicKey(ctx, &ec2instanceconnectv2.SendSSHPublicKeyInput{
 		InstanceId:       awsv2.String(testInstance.instanceID),
 		InstanceOSUser:   awsv2.String(a.deployer.SSHUser),
 		SSHPublicKey:     awsv2.String(string(key.Public)),
@@ -695,17 +1024,30 @@ func (a *AWSRunner) assignNewSSHKey(testInstance *awsInstance) error {
 	if err != nil {
 		return fmt.Errorf("sending SSH Public key for serial console access for %s, %w", a.deployer.SSHUser, err)
 	}
-	klog.Infof("dialing ssh %s@%s", a.deployer.SSHUser, testInstance.publicIP)
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", testInstance.publicIP), &ssh.ClientConfig{
+	klog.Infof("dialing ssh %s@%s", a.deployer.SSHUser, testInstance.sshAddress)
+	addr := testInstance.sshAddress
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("dialing SSH %s@%s %w", a.deployer.SSHUser, addr, err)
+	}
+	hostKeyCallback, err := remote.HostKeyCallback(testInstance.instanceID)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
 		User:            a.deployer.SSHUser,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(key.Signer),
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("dialing SSH %s@%s %w", a.deployer.SSHUser, testInstance.publicIP, err)
+		conn.Close()
+		return fmt.Errorf("dialing SSH %s@%s %w", a.deployer.SSHUser, addr, err)
 	}
+	client := ssh.NewClient(clientConn, chans, reqs)
+	defer client.Close()
 
 	// add our ssh key to authorized keys so it will last longer than 60 seconds
 	sess, err := client.NewSession()
@@ -725,6 +1067,7 @@ func (a *AWSRunner) assignNewSSHKey(testInstance *awsInstance) error {
 			return fmt.Errorf("creating SSH key, %w", err)
 		}
 		sshKeyFile := f.Name()
+		a.created.addTempFile(sshKeyFile)
 		if err = os.Chmod(sshKeyFile, 0400); err != nil {
 			return fmt.Errorf("chmod'ing SSH key, %w", err)
 		}
@@ -0,0 +1,252 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/kubetest2/pkg/artifacts"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+const clusterStateFile = "cluster-state.json"
+
+// clusterState is persisted in the artifacts directory so that a cluster
+// brought up by one kubetest2 invocation can be inspected and torn down by
+// another one (e.g. `--down` in a separate CI step).
+type clusterState struct {
+	ClusterID      string          `json:"clusterID"`
+	Region         string          `json:"region"`
+	KubeconfigPath string          `json:"kubeconfigPath,omitempty"`
+	Instances      []instanceState `json:"instances"`
+	// ControlPlaneEndpoint is the DNS name of the API server load balancer, if any
+	ControlPlaneEndpoint string `json:"controlPlaneEndpoint,omitempty"`
+	// VpcID is the VPC created for the cluster with --create-vpc, if any
+	VpcID string `json:"vpcID,omitempty"`
+	// MergeKubeconfig is the kubeconfig the cluster was added to, if any
+	MergeKubeconfig string `json:"mergeKubeconfig,omitempty"`
+	// APIServerIngressRules are the ingress rules opening the API server port to the load
+	// balancer, by security group ID, that Down() revokes
+	APIServerIngressRules map[string][]string `json:"apiServerIngressRules,omitempty"`
+	// PrivateNodes are reached through SSM tunnels, which do not outlive the invocation
+	// that started them
+	PrivateNodes bool `json:"privateNodes,omitempty"`
+}
+
+type instanceState struct {
+	InstanceID string `json:"instanceID"`
+	Role       string `json:"role"`
+	PublicIP   string `json:"publicIP,omitempty"`
+	PrivateIP  string `json:"privateIP,omitempty"`
+	SSHKeyPath string `json:"sshKeyPath,omitempty"`
+}
+
+func clusterStatePath() string {
+	return filepath.Join(artifacts.BaseDir(), clusterStateFile)
+}
+
+func loadClusterState() (*clusterState, error) {
+	data, err := os.ReadFile(clusterStatePath())
+	if err != nil {
+		return nil, err
+	}
+	state := &clusterState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", clusterStatePath(), err)
+	}
+	return state, nil
+}
+
+// saveClusterState records the current inventory of the cluster, it is best
+// effort as the state file is only an aid for later invocations.
+func (d *deployer) saveClusterState() {
+	state := clusterState{
+		ClusterID:      d.ClusterID,
+		Region:         d.Region,
+		KubeconfigPath: d.KubeconfigPath,
+		PrivateNodes:   d.PrivateNodes,
+	}
+	// recorded ahead of the merge, so that Down() removes the context even if Up() fails
+	state.MergeKubeconfig = d.MergeKubeconfig
+	if d.runner != nil {
+		state.ControlPlaneEndpoint = d.runner.controlPlaneEndpoint
+		state.APIServerIngressRules = d.runner.apiServerIngressRules
+		if d.runner.network != nil {
+			state.VpcID = d.runner.network.VpcID
+		}
+		for _, instance := range d.runner.instances {
+			s := instanceState{
+				InstanceID: instance.instanceID,
+				Role:       instance.role,
+				PublicIP:   instance.publicIP,
+				PrivateIP:  instance.privateIP,
+			}
+			if instance.sshKey != nil {
+				s.SSHKeyPath = instance.sshKey.PrivateKeyPath
+			}
+			state.Instances = append(state.Instances, s)
+		}
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		klog.Warningf("unable to marshal cluster state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(artifacts.BaseDir(), os.ModePerm); err != nil {
+		klog.Warningf("unable to create %s: %v", artifacts.BaseDir(), err)
+		return
+	}
+	if err := os.WriteFile(clusterStatePath(), data, 0644); err != nil {
+		klog.Warningf("unable to write cluster state to %s: %v", clusterStatePath(), err)
+	}
+}
+
+func removeClusterState() {
+	if err := os.Remove(clusterStatePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		klog.Warningf("unable to remove %s: %v", clusterStatePath(), err)
+	}
+}
+
+// ensureClusterInventory makes sure d.runner knows about the instances of the
+// cluster. When Up() ran in this process the inventory is already there,
+// otherwise it is rebuilt from the persisted cluster state and the cluster tag
+// that utils.LaunchNewInstance applies to every instance.
+func (d *deployer) ensureClusterInventory(ctx context.Context) error {
+	if d.runner != nil && len(d.runner.instances) > 0 {
+		return nil
+	}
+
+	var saved map[string]instanceState
+	var controlPlaneEndpoint, vpcID string
+	var apiServerIngressRules map[string][]string
+	state, err := loadClusterState()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		klog.Warningf("ignoring cluster state: %v", err)
+	}
+	if state != nil {
+		// only adopt the saved state if it is for the cluster we were asked about, a
+		// --cluster-id that was not explicitly passed means "the last cluster".
+		if d.ClusterID == d.defaultClusterID || d.ClusterID == state.ClusterID {
+			klog.Infof("using cluster state from %s for cluster %s", clusterStatePath(), state.ClusterID)
+			d.ClusterID = state.ClusterID
+			if state.Region != "" {
+				d.Region = state.Region
+			}
+			if state.PrivateNodes {
+				d.PrivateNodes = true
+			}
+			// the kubeconfig of private nodes points at a tunnel that is gone, it is
+			// downloaded again through a new one
+			if d.KubeconfigPath == "" && !d.PrivateNodes {
+				d.KubeconfigPath = state.KubeconfigPath
+			}
+			if d.MergeKubeconfig == "" {
+				d.MergeKubeconfig = state.MergeKubeconfig
+			}
+			controlPlaneEndpoint = state.ControlPlaneEndpoint
+			vpcID = state.VpcID
+			apiServerIngressRules = state.APIServerIngressRules
+			saved = map[string]instanceState{}
+			for _, instance := range state.Instances {
+				saved[instance.InstanceID] = instance
+			}
+		} else {
+			klog.Infof("ignoring cluster state for cluster %s, looking for cluster %s",
+				state.ClusterID, d.ClusterID)
+		}
+	}
+
+	if d.runner == nil {
+		runner := d.NewAWSRunner()
+		if _, err := runner.InitializeServices(ctx); err != nil {
+			return fmt.Errorf("unable to initialize AWS services : %w", err)
+		}
+	}
+	if err := d.runner.configureSSH(); err != nil {
+		return err
+	}
+	if d.runner.controlPlaneEndpoint == "" {
+		d.runner.controlPlaneEndpoint = controlPlaneEndpoint
+	}
+	if d.runner.network == nil && vpcID != "" {
+		d.runner.network = &utils.ClusterNetwork{VpcID: vpcID}
+	}
+	if d.runner.apiServerIngressRules == nil {
+		d.runner.apiServerIngressRules = apiServerIngressRules
+	}
+
+	found, err := utils.DescribeClusterInstances(ctx, d.runner.ec2Service, d.ClusterID)
+	if err != nil {
+		return err
+	}
+	for i := range found {
+		instance := &found[i]
+		testInstance := &awsInstance{
+			instance:   instance,
+			instanceID: *instance.InstanceId,
+			role:       utils.InstanceTag(*instance, utils.RoleTagKey),
+		}
+		if instance.PublicIpAddress != nil {
+			testInstance.publicIP = *instance.PublicIpAddress
+		}
+		if instance.PrivateIpAddress != nil {
+			testInstance.privateIP = *instance.PrivateIpAddress
+		}
+		if s, ok := saved[testInstance.instanceID]; ok {
+			if testInstance.role == "" {
+				testInstance.role = s.Role
+			}
+			if s.SSHKeyPath != "" {
+				if _, err := os.Stat(s.SSHKeyPath); err == nil {
+					testInstance.sshKey = &utils.TemporarySSHKey{PrivateKeyPath: s.SSHKeyPath}
+					remote.AddSSHKey(testInstance.instanceID, s.SSHKeyPath)
+				}
+			}
+		}
+		if testInstance.publicIP != "" {
+			remote.AddHostnameIP(testInstance.instanceID, testInstance.publicIP)
+		} else if d.PrivateNodes {
+			if err := d.runner.registerSSHHost(ctx, testInstance); err != nil {
+				klog.Warningf("unable to reach %s, its logs will be missing: %v", testInstance.instanceID, err)
+			}
+		}
+		if err := d.runner.learnHostKeys(ctx, testInstance); err != nil {
+			klog.Warningf("unable to verify the host key of %s, its logs will be missing: %v", testInstance.instanceID, err)
+		}
+		d.runner.instances = append(d.runner.instances, testInstance)
+	}
+
+	// the rest of the deployer expects the control plane to be the first instance
+	sort.SliceStable(d.runner.instances, func(i, j int) bool {
+		return d.runner.instances[i].role == utils.RoleControlPlane &&
+			d.runner.instances[j].role != utils.RoleControlPlane
+	})
+	if len(d.runner.instances) > 0 && d.runner.instances[0].role == utils.RoleControlPlane {
+		d.runner.controlPlaneIP = d.runner.instances[0].privateIP
+	}
+	klog.Infof("found %d instances for cluster %s", len(d.runner.instances), d.ClusterID)
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// validateTags checks --tags
+func (d *deployer) validateTags() error {
+	if _, err := utils.ParseTags(d.Tags); err != nil {
+		return fmt.Errorf("invalid --tags: %w", err)
+	}
+	return nil
+}
+
+// resourceTags returns the tags of the resources the deployer creates: the run ID,
+// the name of the CI job if any, the deployer and the expiry time with --max-lifetime,
+// then --tags which may override them
+func (d *deployer) resourceTags() map[string]string {
+	tags := map[string]string{
+		utils.RunIDTagKey:     d.commonOptions.RunID(),
+		utils.CreatedByTagKey: utils.CreatedBy,
+	}
+	// set by prow, and by most CI systems
+	if job := os.Getenv("JOB_NAME"); job != "" {
+		tags[utils.JobNameTagKey] = job
+	}
+	if !d.expiresAt.IsZero() {
+		tags[utils.ExpiresAtTagKey] = d.expiresAt.Format(time.RFC3339)
+	}
+	extra, _ := utils.ParseTags(d.Tags)
+	for key, value := range extra {
+		tags[key] = value
+	}
+	return tags
+}
+
+// sharedResourceTags returns the tags of the resources later runs reuse, the IAM role
+// and instance profile: only created-by, as the other tags are those of a run and
+// expires-at would let the janitor delete them from under the runs still using them
+func (d *deployer) sharedResourceTags() map[string]string {
+	return map[string]string{utils.CreatedByTagKey: utils.CreatedBy}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// tunnel returns the SSM tunnel to the port of the instance, starting it if needed
+func (a *AWSRunner) tunnel(ctx context.Context, instanceID string, port int) (*utils.SSMTunnel, error) {
+	key := fmt.Sprintf("%s:%d", instanceID, port)
+	a.tunnelsMu.Lock()
+	tunnel, ok := a.tunnels[key]
+	a.tunnelsMu.Unlock()
+	if ok {
+		return tunnel, nil
+	}
+
+	// starting a tunnel takes a few seconds, do not hold up the other instances
+	tunnel, err := utils.StartSSMTunnel(ctx, a.ssmService, a.deployer.Region, instanceID, port)
+	if err != nil {
+		return nil, err
+	}
+	a.tunnelsMu.Lock()
+	defer a.tunnelsMu.Unlock()
+	if existing, ok := a.tunnels[key]; ok {
+		tunnel.Close(ctx, a.ssmService)
+		return existing, nil
+	}
+	if a.tunnels == nil {
+		a.tunnels = map[string]*utils.SSMTunnel{}
+	}
+	a.tunnels[key] = tunnel
+	return tunnel, nil
+}
+
+// registerSSHHost tells the remote package how to reach the instance: on its public
+// IP, or through an SSM tunnel to its port 22 with --private-nodes.
+func (a *AWSRunner) registerSSHHost(ctx context.Context, instance *awsInstance) error {
+	if !a.deployer.PrivateNodes {
+		klog.Infof("registering %s/%s", instance.instanceID, instance.publicIP)
+		remote.AddHostnameIP(instance.instanceID, instance.publicIP)
+		instance.sshAddress = net.JoinHostPort(instance.publicIP, "22")
+		return nil
+	}
+	tunnel, err := a.tunnel(ctx, instance.instanceID, 22)
+	if err != nil {
+		// the SSM agent registers a little while after the instance is running
+		klog.Infof("unable to reach %s through ssm yet: %v", instance.instanceID, err)
+		return err
+	}
+	klog.Infof("registering %s/%s", instance.instanceID, tunnel.Address())
+	remote.AddHostnameIP(instance.instanceID, "127.0.0.1")
+	remote.AddHostPort(instance.instanceID, tunnel.LocalPort)
+	instance.sshAddress = tunnel.Address()
+	return nil
+}
+
+// downloadTunnelKubeconfig downloads the kubeconfig of the cluster again through a new
+// SSM tunnel to the API server. The tunnel the kubeconfig of an earlier invocation points
+// at, e.g. the one of --up when --test runs separately, went away with it.
+func (d *deployer) downloadTunnelKubeconfig(ctx context.Context) error {
+	if err := d.ensureClusterInventory(ctx); err != nil {
+		return fmt.Errorf("unable to find instances of cluster %s : %w", d.ClusterID, err)
+	}
+	if len(d.runner.instances) == 0 || d.runner.instances[0].role != utils.RoleControlPlane {
+		return fmt.Errorf("found no control plane of cluster %s", d.ClusterID)
+	}
+	controlPlane := d.runner.instances[0]
+	address, serverName, err := d.runner.apiServerAddress(ctx, controlPlane)
+	if err != nil {
+		return err
+	}
+	d.KubeconfigPath, err = downloadKubeConfig(ctx, controlPlane.instanceID, address, serverName)
+	if err != nil {
+		return err
+	}
+	if err := d.mergeKubeconfig(); err != nil {
+		klog.Warningf("unable to merge the kubeconfig into %s: %v", d.MergeKubeconfig, err)
+	}
+	return nil
+}
+
+// closeTunnels stops all the SSM tunnels
+func (a *AWSRunner) closeTunnels(ctx context.Context) {
+	a.tunnelsMu.Lock()
+	defer a.tunnelsMu.Unlock()
+	for key, tunnel := range a.tunnels {
+		tunnel.Close(ctx, a.ssmService)
+		delete(a.tunnels, key)
+	}
+}
@@ -17,21 +17,19 @@ limitations under the License.
 package deployer
 
 import (
+	"context"
 	"fmt"
 	"os"
-	osexec "os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 
-	"sigs.k8s.io/kubetest2/pkg/exec"
-	"sigs.k8s.io/kubetest2/pkg/fs"
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/remote"
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
 )
@@ -50,8 +48,15 @@ type AWSImage struct {
 }
 
 func (d *deployer) IsUp() (up bool, err error) {
+	ctx := context.Background()
+	if err := d.ensureClusterInventory(ctx); err != nil {
+		return false, fmt.Errorf("unable to find instances of cluster %s : %w", d.ClusterID, err)
+	}
+	if len(d.runner.instances) == 0 {
+		return false, nil
+	}
 	for _, instance := range d.runner.instances {
-		instance2, err := d.runner.isAWSInstanceRunning(instance)
+		instance2, err := d.runner.isAWSInstanceRunning(ctx, instance)
 		if err != nil {
 			return false, err
 		}
@@ -60,111 +65,71 @@ func (d *deployer) IsUp() (up bool, err error) {
 		}
 		klog.Infof("found instance2 id: %s", instance2.instanceID)
 		if d.KubeconfigPath == "" {
-			d.KubeconfigPath = downloadKubeConfig(instance2.instanceID, instance2.publicIP)
-			klog.Infof("Updating $HOME/.kube/config")
-			home, _ := os.UserHomeDir()
-			_ = fs.CopyFile(d.KubeconfigPath, filepath.Join(home, ".kube", "config"))
+			address, serverName, err := d.runner.apiServerAddress(ctx, instance2)
+			if err != nil {
+				return false, err
+			}
+			d.KubeconfigPath, err = downloadKubeConfig(ctx, instance2.instanceID, address, serverName)
+			if err != nil {
+				return false, err
+			}
+			if err := d.mergeKubeconfig(); err != nil {
+				klog.Warningf("unable to merge the kubeconfig into %s: %v", d.MergeKubeconfig, err)
+			}
 		}
 		break
 	}
-	args := []string{
-		d.kubectlPath,
-		"--kubeconfig",
-		d.KubeconfigPath,
-		"get",
-		"nodes",
-		"-o=name",
-	}
-	klog.Infof("Running kubectl command %v", args)
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.SetStderr(os.Stderr)
-	lines, err := exec.OutputLines(cmd)
+	client, err := d.kubeClient()
 	if err != nil {
-		return false, fmt.Errorf("is up failed to get nodes: %s", err)
+		return false, err
 	}
-
-	return len(lines) > 0, nil
-}
-
-// verifyKubectl checks if kubectl exists in kubetest2 artifacts or PATH
-// returns the path to the binary, error if it doesn't exist
-// kubectl detection using legacy verify-get-kube-binaries is unreliable
-// https://github.com/kubernetes/kubernetes/blob/b10d82b93bad7a4e39b9d3f5c5e81defa3af68f0/cluster/kubectl.sh#L25-L26
-func (d *deployer) verifyKubectl() (string, error) {
-	klog.Infof("checking locally built kubectl ...")
-	localKubectl := filepath.Join(d.commonOptions.RunDir(), "kubectl")
-	if _, err := os.Stat(localKubectl); err == nil {
-		return localKubectl, nil
-	}
-	klog.Infof("could not find locally built kubectl, checking existence of kubectl in $PATH ...")
-	kubectlPath, err := osexec.LookPath("kubectl")
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return "", fmt.Errorf("could not find kubectl in $PATH, please ensure your environment has the kubectl binary")
+		return false, fmt.Errorf("is up failed to get nodes: %w", err)
 	}
-	return kubectlPath, nil
+	return len(nodes.Items) > 0, nil
 }
 
 func (d *deployer) Up() error {
 	klog.Info("EC2 deployer starting Up()")
 
-	path, err := d.verifyKubectl()
-	if err != nil {
-		return err
+	ctx, cancel := newSignalContext(d.UpTimeout)
+	defer cancel()
+	upDone := d.startUp(cancel)
+	defer upDone()
+
+	timer := d.startPhase("up")
+	err := d.up(ctx)
+	timer.stop(err)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("cluster bring-up was interrupted (%v): %w", ctx.Err(), err)
+	}
+	if err != nil && d.runner != nil {
+		cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancelCleanup()
+		if rollbackErr := d.rollbackUp(cleanupCtx, err); rollbackErr != nil {
+			klog.Errorf("rolling back cluster %s failed: %v", d.ClusterID, rollbackErr)
+		}
 	}
-	d.kubectlPath = path
+	return err
+}
 
+func (d *deployer) up(ctx context.Context) error {
+	// ahead of Validate, which prepares the user data and the tags of the nodes
+	d.startLifetime()
 	runner := d.NewAWSRunner()
-	err = runner.Validate()
+	err := runner.Validate(ctx)
 	if err != nil {
 		return err
 	}
 
-	var wg sync.WaitGroup
-	fatalErrors := make(chan error)
-	wgDone := make(chan bool)
-
-	for _, image := range runner.internalAWSImages {
-		instance, err := runner.createAWSInstance(image)
-		if instance != nil {
-			runner.instances = append(runner.instances, instance)
-		}
-		if err != nil {
-			klog.Errorf("error starting instance for image %s : %s", image.AmiID, err)
-			if err2 := d.DumpClusterLogs(); err2 != nil {
-				klog.Warningf("Dumping cluster logs at the when Up() failed: %s", err2)
-			}
-			return err
-		}
-		if runner.controlPlaneIP == "" {
-			runner.controlPlaneIP = instance.privateIP
-		}
-		klog.Infof("started instance id: %s", instance.instanceID)
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			_, err := runner.isAWSInstanceRunning(instance)
-			if err != nil {
-				klog.Errorf("error checking instance is running %s : %s", instance.instanceID, err)
-				if err2 := d.DumpClusterLogs(); err2 != nil {
-					klog.Warningf("Dumping cluster logs at the when Up() failed: %s", err2)
-				}
-				fatalErrors <- err
-			}
-			klog.Infof("instance is running: %s", instance.instanceID)
-		}()
+	if err := runner.configureSSH(); err != nil {
+		return err
 	}
-
-	go func() {
-		wg.Wait()
-		close(wgDone)
-	}()
-
-	select {
-	case <-wgDone:
-		break
-	case err := <-fatalErrors:
-		close(fatalErrors)
+	if err := d.launchInstances(ctx); err != nil {
+		return err
+	}
+	if err := d.waitForInstances(ctx); err != nil {
 		return err
 	}
 
@@ -173,6 +138,7 @@ func (d *deployer) Up() error {
 	// framework's SSH through the control plane as a bastion: every node
 	// trusts the shared key that assignNewSSHKey persisted, and the ginkgo
 	// tester subprocess inherits these env vars.
+	// with --private-nodes there is no bastion, the nodes are only reachable through SSM
 	if len(runner.instances) > 0 && runner.instances[0].publicIP != "" {
 		if _, ok := os.LookupEnv("KUBE_SSH_BASTION"); !ok {
 			os.Setenv("KUBE_SSH_BASTION", runner.instances[0].publicIP+":22")
@@ -182,20 +148,18 @@ func (d *deployer) Up() error {
 		}
 	}
 
-	d.waitForKubectlNodes()
-	d.waitForKubectlNodesToBeReady()
-
-	// Wait for cloud-init to complete on control plane before starting tests.
-	// This ensures run-post-install.sh has finished deploying cluster resources
-	// like Cilium CNI and NVIDIA device plugin (if enabled).
-	if err := d.waitForCloudInitComplete(); err != nil {
-		klog.Warningf("cloud-init wait failed (continuing anyway): %v", err)
+	if err := d.writeClusterMetadata(); err != nil {
+		klog.Warningf("unable to write %s: %v", clusterMetadataPath(), err)
 	}
 
-	if d.ExternalCloudProvider {
-		d.waitForExternalProviderPods()
+	client, err := d.kubeClient()
+	if err != nil {
+		return err
 	}
-	return nil
+	if err := d.waitForReadinessGates(ctx, client); err != nil {
+		return err
+	}
+	return ctx.Err()
 }
 
 func (d *deployer) NewAWSRunner() *AWSRunner {
@@ -208,29 +172,38 @@ func (d *deployer) NewAWSRunner() *AWSRunner {
 	return d.runner
 }
 
-func downloadKubeConfig(instanceID string, publicIp string) string {
-	output, err := remote.SSH(instanceID, "cat /etc/kubernetes/admin.conf")
+// downloadKubeConfig fetches the admin kubeconfig from the control plane, points it at
+// apiServerAddress and writes it to the artifacts directory. A non-empty tlsServerName
+// is the name the serving certificate of the API server is verified against, when the
+// address is not in it.
+func downloadKubeConfig(ctx context.Context, instanceID string, apiServerAddress string, tlsServerName string) (string, error) {
+	output, err := remote.SSH(ctx, instanceID, "cat /etc/kubernetes/admin.conf")
 	if err != nil {
-		klog.Fatalf("error downloading KUBECONFIG file: %v", err)
+		return "", fmt.Errorf("error downloading KUBECONFIG file: %w", err)
 	}
 	// write our KUBECONFIG to disk and register it
-	f, err := os.CreateTemp("", ".kubeconfig-*")
-	if err != nil {
-		klog.Fatalf("creating KUBECONFIG file, %w", err)
+	kubeconfigFile := kubeconfigPath()
+	if err = os.MkdirAll(filepath.Dir(kubeconfigFile), os.ModePerm); err != nil {
+		return "", fmt.Errorf("creating KUBECONFIG directory: %w", err)
 	}
-	kubeconfigFile := f.Name()
-	if err = os.Chmod(kubeconfigFile, 0600); err != nil {
-		klog.Fatalf("chmod'ing KUBECONFIG file, %w", err)
+	f, err := os.OpenFile(kubeconfigFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("creating KUBECONFIG file: %w", err)
 	}
+	defer f.Close()
 
-	var re = regexp.MustCompile(`server: https://(.*):6443`)
-	output = re.ReplaceAllString(output, "server: https://"+publicIp+":6443")
+	var re = regexp.MustCompile(`(?m)^([ \t]*)server: https://(.*):6443$`)
+	server := "${1}server: https://" + apiServerAddress
+	if tlsServerName != "" {
+		server += "\n${1}tls-server-name: " + tlsServerName
+	}
+	output = re.ReplaceAllString(output, server)
 
 	if _, err = f.Write([]byte(output)); err != nil {
-		klog.Fatalf("writing KUBECONFIG file, %w", err)
+		return "", fmt.Errorf("writing KUBECONFIG file: %w", err)
 	}
 	klog.Infof("KUBECONFIG=%v", f.Name())
-	return f.Name()
+	return f.Name(), nil
 }
 
 // waitForCloudInitComplete waits for cloud-init to finish on the control plane.
@@ -240,8 +213,9 @@ func downloadKubeConfig(instanceID string, publicIp string) string {
 // - CoreDNS readiness check
 //
 // This fixes a race condition where tests could start before cloud-init finishes
-// deploying required cluster resources.
-func (d *deployer) waitForCloudInitComplete() error {
+// deploying required cluster resources. It waits until ctx expires, the cloud-init
+// readiness gate bounds it.
+func (d *deployer) waitForCloudInitComplete(ctx context.Context) error {
 	if len(d.runner.instances) == 0 {
 		return fmt.Errorf("no instances available")
 	}
@@ -251,17 +225,17 @@ func (d *deployer) waitForCloudInitComplete() error {
 
 	klog.Info("Waiting for cloud-init to complete on control plane...")
 
-	timeout := 5 * time.Minute
 	pollInterval := 10 * time.Second
-	deadline := time.Now().Add(timeout)
 
-	for time.Now().Before(deadline) {
+	for {
 		// Use "cloud-init status" to check completion
 		// --wait flag would block, so we poll instead for better logging
-		output, err := remote.SSH(controlPlane.instanceID, "cloud-init", "status")
+		output, err := remote.SSH(ctx, controlPlane.instanceID, "cloud-init", "status")
 		if err != nil {
 			klog.V(2).Infof("cloud-init status check failed (retrying): %v", err)
-			time.Sleep(pollInterval)
+			if err := utils.Sleep(ctx, pollInterval); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -279,8 +253,8 @@ func (d *deployer) waitForCloudInitComplete() error {
 
 		klog.V(2).Infof("cloud-init still running, waiting... (status: %s)",
 			strings.TrimSpace(output))
-		time.Sleep(pollInterval)
+		if err := utils.Sleep(ctx, pollInterval); err != nil {
+			return fmt.Errorf("waiting for cloud-init to complete: %w", err)
+		}
 	}
-
-	return fmt.Errorf("timeout waiting for cloud-init to complete after %v", timeout)
 }
@@ -0,0 +1,152 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/kubetest2/pkg/artifacts"
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// artifactsIndex lists the artifacts uploaded for a run
+type artifactsIndex struct {
+	ClusterID  string               `json:"clusterID"`
+	Bucket     string               `json:"bucket"`
+	Prefix     string               `json:"prefix"`
+	UploadedAt time.Time            `json:"uploadedAt"`
+	ExpiresAt  *time.Time           `json:"expiresAt,omitempty"`
+	Files      []utils.UploadedFile `json:"files"`
+}
+
+var artifactsIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Artifacts of {{.ClusterID}}</title>
+</head>
+<body>
+<h1>Artifacts of {{.ClusterID}}</h1>
+<p>s3://{{.Bucket}}/{{.Prefix}}, uploaded at {{.UploadedAt.Format "2006-01-02 15:04:05 MST"}}{{if .ExpiresAt}}, deleted after {{.ExpiresAt.Format "2006-01-02 15:04:05 MST"}}{{end}}</p>
+<table>
+<tr><th align="left">File</th><th align="right">Bytes</th></tr>
+{{range .Files}}<tr><td>{{if .URL}}<a href="{{.URL}}">{{.Path}}</a>{{else}}{{.Path}}{{end}}</td><td align="right">{{.Size}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// artifactsBucket splits --artifacts-bucket into the bucket and the prefix
+func (d *deployer) artifactsBucket() (string, string) {
+	location := strings.TrimPrefix(d.ArtifactsBucket, "s3://")
+	bucket, prefix, _ := strings.Cut(location, "/")
+	return bucket, strings.Trim(prefix, "/")
+}
+
+// skipArtifact keeps the credentials to the cluster out of the bucket
+func skipArtifact(rel string) bool {
+	name := filepath.Base(rel)
+	return strings.Contains(name, "kubeconfig") || strings.HasSuffix(name, ".pem") ||
+		strings.HasSuffix(name, ".key")
+}
+
+// uploadArtifacts uploads the artifacts directory, with the logs of the cluster and
+// the results of the tester, to a prefix of --artifacts-bucket for the run. An index
+// links to every file with presigned links, and the runs older than
+// --artifacts-retention are deleted. The prefix is the one of the run ID, so that
+// uploading again, from DumpClusterLogs, a rollback or Down, updates the same run.
+func (d *deployer) uploadArtifacts(ctx context.Context) error {
+	if d.ArtifactsBucket == "" {
+		return nil
+	}
+	bucket, prefix := d.artifactsBucket()
+	if deleted, err := utils.DeleteExpiredArtifacts(ctx, d.runner.s3Service, bucket, prefix); err != nil {
+		klog.Warningf("unable to delete the expired artifacts of s3://%s/%s: %v", bucket, prefix, err)
+	} else if deleted > 0 {
+		klog.Infof("deleted %d expired runs from s3://%s/%s", deleted, bucket, prefix)
+	}
+
+	now := time.Now().UTC()
+	index := artifactsIndex{
+		ClusterID:  d.ClusterID,
+		Bucket:     bucket,
+		Prefix:     path.Join(prefix, d.ClusterID+"-"+d.commonOptions.RunID()),
+		UploadedAt: now,
+	}
+	linkExpiry := utils.MaxPresignExpiry
+	metadata := map[string]string{}
+	if d.ArtifactsRetention > 0 {
+		expiresAt := now.Add(d.ArtifactsRetention)
+		index.ExpiresAt = &expiresAt
+		metadata[utils.ArtifactsExpiresAtKey] = expiresAt.Format(time.RFC3339)
+		if d.ArtifactsRetention < linkExpiry {
+			linkExpiry = d.ArtifactsRetention
+		}
+	}
+
+	tagging := utils.S3Tagging(d.resourceTags())
+	klog.Infof("uploading %s to s3://%s/%s", artifacts.BaseDir(), bucket, index.Prefix)
+	files, uploadErr := utils.UploadDirectory(ctx, d.BuildOptions.CommonBuildOptions.S3Uploader, bucket, index.Prefix,
+		artifacts.BaseDir(), skipArtifact, tagging)
+	if uploadErr != nil && len(files) == 0 {
+		return uploadErr
+	}
+	for i := range files {
+		url, err := utils.PresignGetObject(ctx, d.runner.s3Service, bucket, files[i].Key, linkExpiry)
+		if err != nil {
+			return err
+		}
+		files[i].URL = url
+	}
+	index.Files = files
+
+	var page bytes.Buffer
+	if err := artifactsIndexTemplate.Execute(&page, index); err != nil {
+		return fmt.Errorf("rendering the index of the artifacts: %w", err)
+	}
+	pageKey := path.Join(index.Prefix, "index.html")
+	if err := utils.PutObject(ctx, d.runner.s3Service, bucket, pageKey, page.Bytes(),
+		"text/html; charset=utf-8", metadata, tagging); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	// last, the retention of the run is read from it
+	if err := utils.PutObject(ctx, d.runner.s3Service, bucket, path.Join(index.Prefix, utils.ArtifactsIndex), data,
+		"application/json", metadata, tagging); err != nil {
+		return err
+	}
+
+	url, err := utils.PresignGetObject(ctx, d.runner.s3Service, bucket, pageKey, linkExpiry)
+	if err != nil {
+		return err
+	}
+	klog.Infof("uploaded %d artifacts to s3://%s/%s, browse them at %s", len(files), bucket, index.Prefix, url)
+	return uploadErr
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployer
+
+import "testing"
+
+func TestArtifactsBucket(t *testing.T) {
+	tests := []struct {
+		flag       string
+		wantBucket string
+		wantPrefix string
+	}{
+		{flag: "artifacts", wantBucket: "artifacts"},
+		{flag: "s3://artifacts", wantBucket: "artifacts"},
+		{flag: "s3://artifacts/", wantBucket: "artifacts"},
+		{flag: "s3://artifacts/ci/ec2/", wantBucket: "artifacts", wantPrefix: "ci/ec2"},
+		{flag: "artifacts/ci", wantBucket: "artifacts", wantPrefix: "ci"},
+	}
+	for _, tc := range tests {
+		d := &deployer{ArtifactsBucket: tc.flag}
+		bucket, prefix := d.artifactsBucket()
+		if bucket != tc.wantBucket || prefix != tc.wantPrefix {
+			t.Errorf("--artifacts-bucket %s is bucket %q prefix %q, want %q and %q",
+				tc.flag, bucket, prefix, tc.wantBucket, tc.wantPrefix)
+		}
+	}
+}
+
+func TestSkipArtifact(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "kubeconfig", want: true},
+		{path: "kubeconfig.yaml", want: true},
+		{path: "logs/i-0123/kubelet.log"},
+		{path: "logs/i-0123/ca.pem", want: true},
+		{path: "ssh/kube_aws_rsa.key", want: true},
+		{path: "junit_readiness.xml"},
+		{path: "cluster.json"},
+	}
+	for _, tc := range tests {
+		if got := skipArtifact(tc.path); got != tc.want {
+			t.Errorf("skipArtifact(%s) = %t, want %t", tc.path, got, tc.want)
+		}
+	}
+}
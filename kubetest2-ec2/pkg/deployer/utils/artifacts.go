@@ -0,0 +1,273 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	s3managerv2 "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3typesv2 "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ArtifactsIndex is the object listing the artifacts of a run
+	ArtifactsIndex = "index.json"
+	// ArtifactsExpiresAtKey is the metadata of the index telling when the run may be deleted
+	ArtifactsExpiresAtKey = "expires-at"
+	// MaxPresignExpiry is the longest a presigned link may last
+	MaxPresignExpiry = 7 * 24 * time.Hour
+
+	uploadConcurrency = 10
+)
+
+// UploadedFile is an artifact uploaded to S3
+type UploadedFile struct {
+	// Path is relative to the uploaded directory, with slashes
+	Path string `json:"path"`
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	URL  string `json:"url,omitempty"`
+}
+
+// UploadDirectory uploads the files of dir under the prefix of the bucket, skipping
+// those skip returns true for. tagging is the S3Tagging of the objects.
+func UploadDirectory(ctx context.Context, uploader *s3managerv2.Uploader, bucket string, prefix string,
+	dir string, skip func(path string) bool, tagging string) ([]UploadedFile, error) {
+	var files []UploadedFile
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if rel != "." && skip != nil && skip(rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		files = append(files, UploadedFile{Path: rel, Key: path.Join(prefix, rel), Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", dir, err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []string
+	sem := make(chan struct{}, uploadConcurrency)
+	for _, file := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(file UploadedFile) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := uploadFile(ctx, uploader, bucket, file.Key, filepath.Join(dir, filepath.FromSlash(file.Path)), tagging); err != nil {
+				mu.Lock()
+				errs = append(errs, err.Error())
+				mu.Unlock()
+			}
+		}(file)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return files, fmt.Errorf("uploading %d of %d files to s3://%s/%s: %s", len(errs), len(files), bucket, prefix,
+			strings.Join(errs, "; "))
+	}
+	return files, nil
+}
+
+func uploadFile(ctx context.Context, uploader *s3managerv2.Uploader, bucket string, key string, file string,
+	tagging string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	input := &s3v2.PutObjectInput{
+		Bucket:      awsv2.String(bucket),
+		Key:         awsv2.String(key),
+		Body:        f,
+		ContentType: awsv2.String(contentType(file)),
+	}
+	if tagging != "" {
+		input.Tagging = awsv2.String(tagging)
+	}
+	_, err = uploader.Upload(ctx, input)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", file, err)
+	}
+	return nil
+}
+
+// contentType lets browsers display the logs rather than download them
+func contentType(file string) string {
+	switch ext := filepath.Ext(file); ext {
+	case ".log", ".txt", "":
+		return "text/plain; charset=utf-8"
+	default:
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+		return "application/octet-stream"
+	}
+}
+
+// PutObject uploads data as the key of the bucket, with the metadata and the S3Tagging
+func PutObject(ctx context.Context, svc *s3v2.Client, bucket string, key string, data []byte, contentType string,
+	metadata map[string]string, tagging string) error {
+	input := &s3v2.PutObjectInput{
+		Bucket:      awsv2.String(bucket),
+		Key:         awsv2.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: awsv2.String(contentType),
+		Metadata:    metadata,
+	}
+	if tagging != "" {
+		input.Tagging = awsv2.String(tagging)
+	}
+	_, err := svc.PutObject(ctx, input)
+	if err != nil {
+		return fmt.Errorf("uploading s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// PresignGetObject returns a link to download the key of the bucket for the duration
+func PresignGetObject(ctx context.Context, svc *s3v2.Client, bucket string, key string, expires time.Duration) (string, error) {
+	if expires > MaxPresignExpiry {
+		expires = MaxPresignExpiry
+	}
+	request, err := s3v2.NewPresignClient(svc).PresignGetObject(ctx, &s3v2.GetObjectInput{
+		Bucket: awsv2.String(bucket),
+		Key:    awsv2.String(key),
+	}, s3v2.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("presigning s3://%s/%s: %w", bucket, key, err)
+	}
+	return request.URL, nil
+}
+
+// DeleteExpiredArtifacts deletes the runs under the prefix of the bucket whose index
+// expired, and returns how many it deleted
+func DeleteExpiredArtifacts(ctx context.Context, svc *s3v2.Client, bucket string, prefix string) (int, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var runs []string
+	paginator := s3v2.NewListObjectsV2Paginator(svc, &s3v2.ListObjectsV2Input{
+		Bucket:    awsv2.String(bucket),
+		Prefix:    awsv2.String(prefix),
+		Delimiter: awsv2.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, p := range page.CommonPrefixes {
+			runs = append(runs, awsv2.ToString(p.Prefix))
+		}
+	}
+
+	deleted := 0
+	for _, run := range runs {
+		head, err := svc.HeadObject(ctx, &s3v2.HeadObjectInput{
+			Bucket: awsv2.String(bucket),
+			Key:    awsv2.String(run + ArtifactsIndex),
+		})
+		if err != nil {
+			// not a run, or one still uploading
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, head.Metadata[ArtifactsExpiresAtKey])
+		if err != nil || time.Now().Before(expiresAt) {
+			continue
+		}
+		klog.Infof("deleting s3://%s/%s, expired at %s", bucket, run, expiresAt)
+		if err := deletePrefix(ctx, svc, bucket, run); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// deletePrefix deletes all the objects under the prefix, the index last so that an
+// interrupted deletion is retried
+func deletePrefix(ctx context.Context, svc *s3v2.Client, bucket string, prefix string) error {
+	paginator := s3v2.NewListObjectsV2Paginator(svc, &s3v2.ListObjectsV2Input{
+		Bucket: awsv2.String(bucket),
+		Prefix: awsv2.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+		var objects []s3typesv2.ObjectIdentifier
+		for _, object := range page.Contents {
+			if awsv2.ToString(object.Key) == prefix+ArtifactsIndex {
+				continue
+			}
+			objects = append(objects, s3typesv2.ObjectIdentifier{Key: object.Key})
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		_, err = svc.DeleteObjects(ctx, &s3v2.DeleteObjectsInput{
+			Bucket: awsv2.String(bucket),
+			Delete: &s3typesv2.Delete{Objects: objects, Quiet: awsv2.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("deleting s3://%s/%s: %w", bucket, prefix, err)
+		}
+	}
+	_, err := svc.DeleteObject(ctx, &s3v2.DeleteObjectInput{
+		Bucket: awsv2.String(bucket),
+		Key:    awsv2.String(prefix + ArtifactsIndex),
+	})
+	if err != nil {
+		return fmt.Errorf("deleting s3://%s/%s%s: %w", bucket, prefix, ArtifactsIndex, err)
+	}
+	return nil
+}
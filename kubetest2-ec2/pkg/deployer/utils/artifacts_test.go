@@ -0,0 +1,39 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import "testing"
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "kubelet.log", want: "text/plain; charset=utf-8"},
+		{file: "build-log.txt", want: "text/plain; charset=utf-8"},
+		{file: "kern", want: "text/plain; charset=utf-8"},
+		{file: "junit_01.xml", want: "text/xml; charset=utf-8"},
+		{file: "cluster.json", want: "application/json"},
+		{file: "index.html", want: "text/html; charset=utf-8"},
+		{file: "screenshot.xyzzy", want: "application/octet-stream"},
+	}
+	for _, tc := range tests {
+		if got := contentType(tc.file); got != tc.want {
+			t.Errorf("contentType(%s) = %q, want %q", tc.file, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// ConsoleOutput returns the serial console output of the instance, the latest one on
+// nitro instances and the one of boot on the others.
+func ConsoleOutput(ctx context.Context, svc *ec2v2.Client, instanceID string) (string, error) {
+	out, err := svc.GetConsoleOutput(ctx, &ec2v2.GetConsoleOutputInput{
+		InstanceId: awsv2.String(instanceID),
+		Latest:     awsv2.Bool(true),
+	})
+	if err != nil && strings.Contains(err.Error(), "UnsupportedOperation") {
+		out, err = svc.GetConsoleOutput(ctx, &ec2v2.GetConsoleOutputInput{
+			InstanceId: awsv2.String(instanceID),
+		})
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting the console output of %s: %w", instanceID, err)
+	}
+	output, err := base64.StdEncoding.DecodeString(awsv2.ToString(out.Output))
+	if err != nil {
+		return "", fmt.Errorf("decoding the console output of %s: %w", instanceID, err)
+	}
+	return string(output), nil
+}
+
+// ConsoleScreenshot returns a JPG screenshot of the console of the instance
+func ConsoleScreenshot(ctx context.Context, svc *ec2v2.Client, instanceID string) ([]byte, error) {
+	out, err := svc.GetConsoleScreenshot(ctx, &ec2v2.GetConsoleScreenshotInput{
+		InstanceId: awsv2.String(instanceID),
+		WakeUp:     awsv2.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting a console screenshot of %s: %w", instanceID, err)
+	}
+	image, err := base64.StdEncoding.DecodeString(awsv2.ToString(out.ImageData))
+	if err != nil {
+		return nil, fmt.Errorf("decoding the console screenshot of %s: %w", instanceID, err)
+	}
+	return image, nil
+}
+
+// InstanceState is what EC2 tells about the state of an instance and its status checks
+type InstanceState struct {
+	InstanceID            string     `json:"instanceID"`
+	InstanceType          string     `json:"instanceType,omitempty"`
+	AvailabilityZone      string     `json:"availabilityZone,omitempty"`
+	LaunchTime            *time.Time `json:"launchTime,omitempty"`
+	State                 string     `json:"state"`
+	StateReasonCode       string     `json:"stateReasonCode,omitempty"`
+	StateReasonMessage    string     `json:"stateReasonMessage,omitempty"`
+	StateTransitionReason string     `json:"stateTransitionReason,omitempty"`
+	SystemStatus          string     `json:"systemStatus,omitempty"`
+	InstanceStatus        string     `json:"instanceStatus,omitempty"`
+}
+
+// DescribeInstanceState returns the state of the instance, its status checks are
+// only known while it runs.
+func DescribeInstanceState(ctx context.Context, svc *ec2v2.Client, instanceID string) (*InstanceState, error) {
+	op, err := svc.DescribeInstances(ctx, &ec2v2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing instance %s: %w", instanceID, err)
+	}
+	if len(op.Reservations) == 0 || len(op.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+	instance := op.Reservations[0].Instances[0]
+	state := &InstanceState{
+		InstanceID:            instanceID,
+		InstanceType:          string(instance.InstanceType),
+		LaunchTime:            instance.LaunchTime,
+		StateTransitionReason: awsv2.ToString(instance.StateTransitionReason),
+	}
+	if instance.Placement != nil {
+		state.AvailabilityZone = awsv2.ToString(instance.Placement.AvailabilityZone)
+	}
+	if instance.State != nil {
+		state.State = string(instance.State.Name)
+	}
+	if instance.StateReason != nil {
+		state.StateReasonCode = awsv2.ToString(instance.StateReason.Code)
+		state.StateReasonMessage = awsv2.ToString(instance.StateReason.Message)
+	}
+
+	status, err := svc.DescribeInstanceStatus(ctx, &ec2v2.DescribeInstanceStatusInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return state, fmt.Errorf("describing the status of instance %s: %w", instanceID, err)
+	}
+	if len(status.InstanceStatuses) > 0 {
+		s := status.InstanceStatuses[0]
+		if s.SystemStatus != nil {
+			state.SystemStatus = string(s.SystemStatus.Status)
+		}
+		if s.InstanceStatus != nil {
+			state.InstanceStatus = string(s.InstanceStatus.Status)
+		}
+	}
+	return state, nil
+}
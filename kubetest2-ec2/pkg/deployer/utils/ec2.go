@@ -2,13 +2,11 @@ package utils
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
 	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	iamv2 "github.com/aws/aws-sdk-go-v2/service/iam"
-	"math/rand"
 	"strings"
 	"time"
 
@@ -17,6 +15,49 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	// ClusterTagPrefix is the prefix of the tag applied to every instance of a
+	// cluster, the cluster ID is appended to form the key.
+	ClusterTagPrefix = "kubernetes.io/cluster/"
+	// RoleTagKey is the tag recording whether an instance is a control plane or worker node.
+	RoleTagKey = "kubetest2-ec2/role"
+
+	RoleControlPlane = "control-plane"
+	RoleWorker       = "worker"
+
+	// NodePoolTagKey is the tag recording the node pool of a worker, with --cluster-spec.
+	NodePoolTagKey = "kubetest2-ec2/node-pool"
+
+	// CapacityTypeTagKey is the tag recording whether an instance was launched as spot or on-demand.
+	CapacityTypeTagKey = "kubetest2-ec2/capacity-type"
+
+	CapacityTypeOnDemand = "on-demand"
+	CapacityTypeSpot     = "spot"
+	// CapacityTypeSpotWithFallback launches a spot instance and retries as on-demand
+	// when there is no spot capacity.
+	CapacityTypeSpotWithFallback = "spot-with-fallback"
+
+	// spotInterruptionReason is the state reason code of a spot instance that was reclaimed
+	spotInterruptionReason = "Server.SpotInstanceTermination"
+)
+
+// CapacityTypes lists the valid values of InternalAWSImage.CapacityType
+var CapacityTypes = []string{CapacityTypeOnDemand, CapacityTypeSpot, CapacityTypeSpotWithFallback}
+
+// spotCapacityErrors are the RunInstances error codes that mean spot capacity is not
+// available right now, as opposed to a problem with the request itself.
+var spotCapacityErrors = []string{
+	"InsufficientInstanceCapacity",
+	"SpotMaxPriceTooLow",
+	"MaxSpotInstanceCountExceeded",
+	"UnfulfillableCapacity",
+}
+
+// ClusterTag returns the tag key used to identify instances of the cluster
+func ClusterTag(clusterID string) string {
+	return ClusterTagPrefix + clusterID
+}
+
 type InternalAWSImage struct {
 	AmiID string
 	// The instance type (e.g. t3a.medium)
@@ -25,19 +66,50 @@ type InternalAWSImage struct {
 	ImageDesc    string
 	// name of the instance profile
 	InstanceProfile string
+	// Role is either RoleControlPlane or RoleWorker
+	Role string
+	// SecurityGroupIDs are the groups of the instance, empty means the default group of the VPC
+	SecurityGroupIDs []string
+	// CapacityType is one of CapacityTypes, empty means on-demand
+	CapacityType string
+	// InstanceTypes is the ordered list of instance types to try on capacity errors,
+	// InstanceType is set to the one being launched
+	InstanceTypes []string
+	// NoPublicIP launches the instance with only a private address
+	NoPublicIP bool
+	// Tags are added to the instance, its volumes and its network interfaces
+	Tags map[string]string
+	// TerminateOnShutdown terminates the instance instead of stopping it when it powers
+	// itself off, e.g. at the end of --max-lifetime
+	TerminateOnShutdown bool
+	// NodePool is the node pool of a worker launched from a cluster spec, if any
+	NodePool string
 }
 
-func LaunchNewInstance(ec2Service *ec2v2.Client, iamService *iamv2.Client,
+// instanceTags are the Tags of the instance itself, with its node pool
+func (img InternalAWSImage) instanceTags() map[string]string {
+	if img.NodePool == "" {
+		return img.Tags
+	}
+	tags := map[string]string{NodePoolTagKey: img.NodePool}
+	for key, value := range img.Tags {
+		tags[key] = value
+	}
+	return tags
+}
+
+func LaunchNewInstance(ctx context.Context, ec2Service *ec2v2.Client, iamService *iamv2.Client,
 	clusterID string, controlPlaneIP string, img InternalAWSImage, subnetID string, ipFamily string) (*ec2typesv2.Instance, error) {
-	images, err := ec2Service.DescribeImages(context.TODO(), &ec2v2.DescribeImagesInput{ImageIds: []string{img.AmiID}})
+	images, err := ec2Service.DescribeImages(ctx, &ec2v2.DescribeImagesInput{ImageIds: []string{img.AmiID}})
 	if err != nil {
 		return nil, fmt.Errorf("describing images: %w", err)
 	}
 
 	netIface := ec2typesv2.InstanceNetworkInterfaceSpecification{
 		SubnetId:                 awsv2.String(subnetID),
-		AssociatePublicIpAddress: awsv2.Bool(true),
+		AssociatePublicIpAddress: awsv2.Bool(!img.NoPublicIP),
 		DeviceIndex:              awsv2.Int32(0),
+		Groups:                   img.SecurityGroupIDs,
 	}
 	if ipFamily == "ipv6" || ipFamily == "dual" {
 		netIface.Ipv6AddressCount = awsv2.Int32(1)
@@ -57,25 +129,46 @@ func LaunchNewInstance(ec2Service *ec2v2.Client, iamService *iamv2.Client,
 		TagSpecifications: []ec2typesv2.TagSpecification{
 			{
 				ResourceType: ec2typesv2.ResourceTypeInstance,
-				Tags: []ec2typesv2.Tag{
+				Tags: append([]ec2typesv2.Tag{
 					{
 						Key:   awsv2.String("Name"),
 						Value: awsv2.String(name),
 					},
 					{
-						Key:   awsv2.String("kubernetes.io/cluster/" + clusterID),
+						Key:   awsv2.String(ClusterTag(clusterID)),
 						Value: awsv2.String("owned"),
 					},
-				},
+					{
+						Key:   awsv2.String(RoleTagKey),
+						Value: awsv2.String(img.Role),
+					},
+					{
+						Key:   awsv2.String(CapacityTypeTagKey),
+						Value: awsv2.String(CapacityTypeOnDemand),
+					},
+				}, EC2Tags(img.instanceTags())...),
 			},
 			{
 				ResourceType: ec2typesv2.ResourceTypeVolume,
-				Tags: []ec2typesv2.Tag{
+				Tags: append([]ec2typesv2.Tag{
 					{
 						Key:   awsv2.String("Name"),
 						Value: awsv2.String(name),
 					},
-				},
+				}, EC2Tags(img.Tags)...),
+			},
+			{
+				ResourceType: ec2typesv2.ResourceTypeNetworkInterface,
+				Tags: append([]ec2typesv2.Tag{
+					{
+						Key:   awsv2.String("Name"),
+						Value: awsv2.String(name),
+					},
+					{
+						Key:   awsv2.String(ClusterTag(clusterID)),
+						Value: awsv2.String("owned"),
+					},
+				}, EC2Tags(img.Tags)...),
 			},
 		},
 		BlockDeviceMappings: []ec2typesv2.BlockDeviceMapping{
@@ -88,12 +181,19 @@ func LaunchNewInstance(ec2Service *ec2v2.Client, iamService *iamv2.Client,
 			},
 		},
 	}
+	if img.TerminateOnShutdown {
+		input.InstanceInitiatedShutdownBehavior = ec2typesv2.ShutdownBehaviorTerminate
+	}
 	if len(img.UserData) > 0 {
 		data := strings.ReplaceAll(img.UserData, "{{KUBEADM_CONTROL_PLANE_IP}}", controlPlaneIP)
-		input.UserData = awsv2.String(base64.StdEncoding.EncodeToString([]byte(data)))
+		userData, err := EncodeUserData(data)
+		if err != nil {
+			return nil, fmt.Errorf("encoding user data, %w", err)
+		}
+		input.UserData = awsv2.String(userData)
 	}
 	if img.InstanceProfile != "" {
-		arn, err := GetInstanceProfileArn(iamService, img.InstanceProfile)
+		arn, err := GetInstanceProfileArn(ctx, iamService, img.InstanceProfile)
 		if err != nil {
 			return nil, fmt.Errorf("getting instance profile arn, %w", err)
 		}
@@ -102,12 +202,75 @@ func LaunchNewInstance(ec2Service *ec2v2.Client, iamService *iamv2.Client,
 		}
 	}
 
-	rsv, err := ec2Service.RunInstances(context.TODO(), input)
+	if img.CapacityType == CapacityTypeSpot || img.CapacityType == CapacityTypeSpotWithFallback {
+		setMarketType(input, ec2typesv2.MarketTypeSpot)
+	}
+	rsv, err := ec2Service.RunInstances(ctx, input)
+	if err != nil && img.CapacityType == CapacityTypeSpotWithFallback && isSpotCapacityError(err) {
+		klog.Warningf("no spot capacity for %s in subnet %s, falling back to on-demand: %v",
+			img.InstanceType, subnetID, err)
+		setMarketType(input, "")
+		rsv, err = ec2Service.RunInstances(ctx, input)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("creating instance, %w", err)
 	}
 
-	return WaitForInstanceToRun(ec2Service, &rsv.Instances[0]), nil
+	return WaitForInstanceToRun(ctx, ec2Service, &rsv.Instances[0]), nil
+}
+
+// setMarketType switches the request between spot and on-demand (an empty market
+// type), keeping the capacity type tag of the instance in sync.
+func setMarketType(input *ec2v2.RunInstancesInput, marketType ec2typesv2.MarketType) {
+	capacityType := CapacityTypeOnDemand
+	input.InstanceMarketOptions = nil
+	if marketType == ec2typesv2.MarketTypeSpot {
+		capacityType = CapacityTypeSpot
+		input.InstanceMarketOptions = &ec2typesv2.InstanceMarketOptionsRequest{
+			MarketType: ec2typesv2.MarketTypeSpot,
+			SpotOptions: &ec2typesv2.SpotMarketOptions{
+				SpotInstanceType:             ec2typesv2.SpotInstanceTypeOneTime,
+				InstanceInterruptionBehavior: ec2typesv2.InstanceInterruptionBehaviorTerminate,
+			},
+		}
+	}
+	for _, spec := range input.TagSpecifications {
+		for i := range spec.Tags {
+			if *spec.Tags[i].Key == CapacityTypeTagKey {
+				spec.Tags[i].Value = awsv2.String(capacityType)
+			}
+		}
+	}
+}
+
+func isSpotCapacityError(err error) bool {
+	for _, code := range spotCapacityErrors {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+	return false
+}
+
+// SpotInterruption returns a description of why a spot instance was reclaimed by
+// EC2, or "" if it was not.
+func SpotInterruption(ctx context.Context, ec2Service *ec2v2.Client, instanceID string) (string, error) {
+	op, err := ec2Service.DescribeInstances(ctx, &ec2v2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("describing instance %s: %w", instanceID, err)
+	}
+	if len(op.Reservations) == 0 || len(op.Reservations[0].Instances) == 0 {
+		return "", nil
+	}
+	instance := op.Reservations[0].Instances[0]
+	if instance.InstanceLifecycle != ec2typesv2.InstanceLifecycleTypeSpot || instance.StateReason == nil ||
+		awsv2.ToString(instance.StateReason.Code) != spotInterruptionReason {
+		return "", nil
+	}
+	return fmt.Sprintf("spot instance %s was interrupted by EC2 (state %s): %s", instanceID,
+		instance.State.Name, awsv2.ToString(instance.StateReason.Message)), nil
 }
 
 // EnsureSSHSelfIngress allows TCP 22 between instances that share the VPC's
@@ -116,21 +279,23 @@ func LaunchNewInstance(ec2Service *ec2v2.Client, iamService *iamv2.Client,
 // through the control plane (KUBE_SSH_BASTION); that second hop targets a
 // node's private IP and only a security group rule can admit it. A rule that
 // already exists returns InvalidPermission.Duplicate, which counts as success.
-func EnsureSSHSelfIngress(svc *ec2v2.Client, vpcID string) error {
-	out, err := svc.DescribeSecurityGroups(context.TODO(), &ec2v2.DescribeSecurityGroupsInput{
+// It returns the ID of the group and the IDs of the rules it added, which is
+// empty when the rule already existed.
+func EnsureSSHSelfIngress(ctx context.Context, svc *ec2v2.Client, vpcID string) (string, []string, error) {
+	out, err := svc.DescribeSecurityGroups(ctx, &ec2v2.DescribeSecurityGroupsInput{
 		Filters: []ec2typesv2.Filter{
 			{Name: awsv2.String("vpc-id"), Values: []string{vpcID}},
 			{Name: awsv2.String("group-name"), Values: []string{"default"}},
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("describing default security group of vpc %s: %w", vpcID, err)
+		return "", nil, fmt.Errorf("describing default security group of vpc %s: %w", vpcID, err)
 	}
 	if len(out.SecurityGroups) == 0 {
-		return fmt.Errorf("no default security group in vpc %s", vpcID)
+		return "", nil, fmt.Errorf("no default security group in vpc %s", vpcID)
 	}
 	groupID := out.SecurityGroups[0].GroupId
-	_, err = svc.AuthorizeSecurityGroupIngress(context.TODO(), &ec2v2.AuthorizeSecurityGroupIngressInput{
+	authorized, err := svc.AuthorizeSecurityGroupIngress(ctx, &ec2v2.AuthorizeSecurityGroupIngressInput{
 		GroupId: groupID,
 		IpPermissions: []ec2typesv2.IpPermission{{
 			IpProtocol:       awsv2.String("tcp"),
@@ -141,21 +306,43 @@ func EnsureSSHSelfIngress(svc *ec2v2.Client, vpcID string) error {
 	})
 	if err != nil {
 		if strings.Contains(err.Error(), "InvalidPermission.Duplicate") {
-			return nil
+			return *groupID, nil, nil
+		}
+		return *groupID, nil, fmt.Errorf("authorizing ssh ingress on security group %s: %w", *groupID, err)
+	}
+	var ruleIDs []string
+	for _, rule := range authorized.SecurityGroupRules {
+		if rule.SecurityGroupRuleId != nil {
+			ruleIDs = append(ruleIDs, *rule.SecurityGroupRuleId)
 		}
-		return fmt.Errorf("authorizing ssh ingress on security group %s: %w", *groupID, err)
 	}
 	klog.Infof("allowed ssh (tcp/22) between instances in security group %s", *groupID)
+	return *groupID, ruleIDs, nil
+}
+
+// RevokeSecurityGroupIngress removes ingress rules previously added to the security group.
+func RevokeSecurityGroupIngress(ctx context.Context, svc *ec2v2.Client, groupID string, ruleIDs []string) error {
+	_, err := svc.RevokeSecurityGroupIngress(ctx, &ec2v2.RevokeSecurityGroupIngressInput{
+		GroupId:              awsv2.String(groupID),
+		SecurityGroupRuleIds: ruleIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("revoking ingress rules %v of security group %s: %w", ruleIDs, groupID, err)
+	}
 	return nil
 }
 
-func WaitForInstanceToRun(ec2Service *ec2v2.Client, instance *ec2typesv2.Instance) *ec2typesv2.Instance {
+// WaitForInstanceToRun polls the instance until it is running, it gives up after
+// 30 attempts or when the context is done and returns the last known state.
+func WaitForInstanceToRun(ctx context.Context, ec2Service *ec2v2.Client, instance *ec2typesv2.Instance) *ec2typesv2.Instance {
 	for i := 0; i < 30; i++ {
 		if i > 0 {
-			time.Sleep(time.Second * 5)
+			if err := Sleep(ctx, time.Second*5); err != nil {
+				break
+			}
 		}
 
-		op, err := ec2Service.DescribeInstances(context.TODO(), &ec2v2.DescribeInstancesInput{
+		op, err := ec2Service.DescribeInstances(ctx, &ec2v2.DescribeInstancesInput{
 			InstanceIds: []string{*instance.InstanceId},
 		})
 		if err != nil {
@@ -169,38 +356,152 @@ func WaitForInstanceToRun(ec2Service *ec2v2.Client, instance *ec2typesv2.Instanc
 	return instance
 }
 
-func PickSubnetID(svc *ec2v2.Client, ipFamily string) (string, string, error) {
-	defaultVpcID, err := getDefaultVPC(svc)
+// DescribeClusterInstances returns all instances tagged as belonging to the cluster that
+// have not been terminated yet.
+func DescribeClusterInstances(ctx context.Context, ec2Service *ec2v2.Client, clusterID string) ([]ec2typesv2.Instance, error) {
+	var instances []ec2typesv2.Instance
+	paginator := ec2v2.NewDescribeInstancesPaginator(ec2Service, &ec2v2.DescribeInstancesInput{
+		Filters: []ec2typesv2.Filter{
+			{
+				Name:   awsv2.String("tag-key"),
+				Values: []string{ClusterTag(clusterID)},
+			},
+			{
+				Name: awsv2.String("instance-state-name"),
+				Values: []string{
+					string(ec2typesv2.InstanceStateNamePending),
+					string(ec2typesv2.InstanceStateNameRunning),
+					string(ec2typesv2.InstanceStateNameStopping),
+					string(ec2typesv2.InstanceStateNameStopped),
+				},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describing instances of cluster %s: %w", clusterID, err)
+		}
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+	}
+	return instances, nil
+}
+
+// InstanceTag returns the value of the tag with the given key, or "" when absent.
+func InstanceTag(instance ec2typesv2.Instance, key string) string {
+	for _, tag := range instance.Tags {
+		if tag.Key != nil && *tag.Key == key && tag.Value != nil {
+			return *tag.Value
+		}
+	}
+	return ""
+}
+
+// Subnet is a subnet instances can be launched in
+type Subnet struct {
+	ID               string
+	AvailabilityZone string
+}
+
+// ListSubnets returns the subnets instances can be launched in, along with the ID of
+// their VPC. Those are the given subnets when there are any, otherwise all the subnets
+// of the given VPC, or of the default VPC when no VPC is given either.
+func ListSubnets(ctx context.Context, svc *ec2v2.Client, vpcID string, subnetIDs []string, ipFamily string) ([]Subnet, string, error) {
+	if len(subnetIDs) > 0 {
+		return describeSubnets(ctx, svc, vpcID, subnetIDs, ipFamily)
+	}
+	if vpcID != "" {
+		requireIPv6 := ipFamily == "ipv6" || ipFamily == "dual"
+		subnets, err := getSubnets(ctx, svc, vpcID, requireIPv6)
+		if err != nil {
+			return nil, "", fmt.Errorf("describing subnets of vpc %s: %w", vpcID, err)
+		}
+		klog.Infof("Subnets of VPC %s (ipFamily=%q, requireIPv6=%v): %v", vpcID, ipFamily, requireIPv6, subnets)
+		if len(subnets) == 0 {
+			return nil, "", fmt.Errorf("no usable subnets found in vpc %s for --ip-family=%s", vpcID, ipFamily)
+		}
+		return subnets, vpcID, nil
+	}
+
+	defaultVpcID, err := getDefaultVPC(ctx, svc)
 	if err != nil {
-		return "", "", fmt.Errorf("Failed to get default VPC: %v", err)
+		return nil, "", fmt.Errorf("Failed to get default VPC: %v", err)
 	}
 	klog.Infof("Default VPC ID: %s\n", defaultVpcID)
 
-	// Get subnet IDs for the default VPC. When the caller asked for an IPv6
+	// Get subnets of the default VPC. When the caller asked for an IPv6
 	// or dual-stack cluster, restrict to subnets that already have an IPv6
 	// CIDR association — instance launch would otherwise fail with
 	// InvalidParameterValue: ipv6AddressCount cannot be specified on a subnet
 	// without IPv6 enabled.
 	requireIPv6 := ipFamily == "ipv6" || ipFamily == "dual"
-	subnetIDs, err := getSubnetIDs(svc, defaultVpcID, requireIPv6)
+	subnets, err := getSubnets(ctx, svc, defaultVpcID, requireIPv6)
 	if err != nil {
-		return "", "", fmt.Errorf("Failed to get subnet IDs: %v", err)
+		return nil, "", fmt.Errorf("Failed to get subnet IDs: %v", err)
 	}
 
 	// Print the results
-	klog.Infof("Subnet IDs (ipFamily=%q, requireIPv6=%v): %v", ipFamily, requireIPv6, subnetIDs)
-	if len(subnetIDs) == 0 {
+	klog.Infof("Subnets (ipFamily=%q, requireIPv6=%v): %v", ipFamily, requireIPv6, subnets)
+	if len(subnets) == 0 {
 		if requireIPv6 {
-			return "", "", fmt.Errorf("No IPv6-enabled subnets found in the default VPC %s; enable an IPv6 CIDR on at least one subnet or run without --ip-family=%s", defaultVpcID, ipFamily)
+			return nil, "", fmt.Errorf("No IPv6-enabled subnets found in the default VPC %s; enable an IPv6 CIDR on at least one subnet or run without --ip-family=%s", defaultVpcID, ipFamily)
+		}
+		return nil, "", fmt.Errorf("No subnets found in the default VPC: %s", defaultVpcID)
+	}
+	return subnets, defaultVpcID, nil
+}
+
+// describeSubnets checks that the subnets exist, are all in the same VPC (the given one
+// when set) and have an IPv6 CIDR when the ip family needs one.
+func describeSubnets(ctx context.Context, svc *ec2v2.Client, vpcID string, subnetIDs []string, ipFamily string) ([]Subnet, string, error) {
+	result, err := svc.DescribeSubnets(ctx, &ec2v2.DescribeSubnetsInput{SubnetIds: subnetIDs})
+	if err != nil {
+		return nil, "", fmt.Errorf("describing subnets %v: %w", subnetIDs, err)
+	}
+	requireIPv6 := ipFamily == "ipv6" || ipFamily == "dual"
+	var subnets []Subnet
+	for _, subnet := range result.Subnets {
+		if vpcID == "" {
+			vpcID = *subnet.VpcId
+		}
+		if *subnet.VpcId != vpcID {
+			return nil, "", fmt.Errorf("subnet %s is in vpc %s, not in vpc %s", *subnet.SubnetId, *subnet.VpcId, vpcID)
+		}
+		if requireIPv6 && !hasIPv6CIDR(subnet) {
+			return nil, "", fmt.Errorf("subnet %s has no IPv6 CIDR, which --ip-family=%s needs", *subnet.SubnetId, ipFamily)
+		}
+		subnets = append(subnets, Subnet{
+			ID:               *subnet.SubnetId,
+			AvailabilityZone: *subnet.AvailabilityZone,
+		})
+	}
+	if len(subnets) != len(subnetIDs) {
+		return nil, "", fmt.Errorf("only found %d of the subnets %v", len(subnets), subnetIDs)
+	}
+	klog.Infof("Subnets of VPC %s: %v", vpcID, subnets)
+	return subnets, vpcID, nil
+}
+
+// ValidateSecurityGroups checks that the security groups exist in the VPC
+func ValidateSecurityGroups(ctx context.Context, svc *ec2v2.Client, vpcID string, groupIDs []string) error {
+	result, err := svc.DescribeSecurityGroups(ctx, &ec2v2.DescribeSecurityGroupsInput{GroupIds: groupIDs})
+	if err != nil {
+		return fmt.Errorf("describing security groups %v: %w", groupIDs, err)
+	}
+	if len(result.SecurityGroups) != len(groupIDs) {
+		return fmt.Errorf("only found %d of the security groups %v", len(result.SecurityGroups), groupIDs)
+	}
+	for _, group := range result.SecurityGroups {
+		if awsv2.ToString(group.VpcId) != vpcID {
+			return fmt.Errorf("security group %s is in vpc %s, not in vpc %s", *group.GroupId, awsv2.ToString(group.VpcId), vpcID)
 		}
-		return "", "", fmt.Errorf("No subnets found in the default VPC: %s", defaultVpcID)
 	}
-	randomSubnetID := subnetIDs[rand.Intn(len(subnetIDs))]
-	klog.Infof("Randomly picked subnet ID: %s\n", randomSubnetID)
-	return randomSubnetID, defaultVpcID, nil
+	return nil
 }
 
-func getDefaultVPC(svc *ec2v2.Client) (string, error) {
+func getDefaultVPC(ctx context.Context, svc *ec2v2.Client) (string, error) {
 	input := &ec2v2.DescribeVpcsInput{
 		Filters: []ec2typesv2.Filter{
 			{
@@ -210,7 +511,7 @@ func getDefaultVPC(svc *ec2v2.Client) (string, error) {
 		},
 	}
 
-	result, err := svc.DescribeVpcs(context.TODO(), input)
+	result, err := svc.DescribeVpcs(ctx, input)
 	if err != nil {
 		return "", err
 	}
@@ -222,7 +523,7 @@ func getDefaultVPC(svc *ec2v2.Client) (string, error) {
 	return *result.Vpcs[0].VpcId, nil
 }
 
-func getSubnetIDs(svc *ec2v2.Client, vpcID string, requireIPv6 bool) ([]string, error) {
+func getSubnets(ctx context.Context, svc *ec2v2.Client, vpcID string, requireIPv6 bool) ([]Subnet, error) {
 	input := &ec2v2.DescribeSubnetsInput{
 		Filters: []ec2typesv2.Filter{
 			{
@@ -232,24 +533,23 @@ func getSubnetIDs(svc *ec2v2.Client, vpcID string, requireIPv6 bool) ([]string,
 		},
 	}
 
-	result, err := svc.DescribeSubnets(context.TODO(), input)
+	result, err := svc.DescribeSubnets(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	var subnetIDs []string
+	var subnets []Subnet
 	for _, subnet := range result.Subnets {
-		// skip known AZ where instance types we need are not available
-		if *subnet.AvailabilityZone == "us-east-1e" {
-			continue
-		}
 		if requireIPv6 && !hasIPv6CIDR(subnet) {
 			continue
 		}
-		subnetIDs = append(subnetIDs, *subnet.SubnetId)
+		subnets = append(subnets, Subnet{
+			ID:               *subnet.SubnetId,
+			AvailabilityZone: *subnet.AvailabilityZone,
+		})
 	}
 
-	return subnetIDs, nil
+	return subnets, nil
 }
 
 // hasIPv6CIDR returns true when the subnet has at least one associated IPv6
@@ -0,0 +1,74 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bufio"
+	"context"
+	"strings"
+
+	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
+	"golang.org/x/crypto/ssh"
+)
+
+// cloud-init prints the host keys it generated between these markers on the console
+const (
+	hostKeysBegin = "-----BEGIN SSH HOST KEY KEYS-----"
+	hostKeysEnd   = "-----END SSH HOST KEY KEYS-----"
+)
+
+// ConsoleHostKeys returns the SSH host keys the instance printed on its console.
+// The console output only reaches the API a few minutes after boot, none are
+// returned until then.
+func ConsoleHostKeys(ctx context.Context, svc *ec2v2.Client, instanceID string) ([]ssh.PublicKey, error) {
+	output, err := ConsoleOutput(ctx, svc, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return parseHostKeys(output), nil
+}
+
+// parseHostKeys parses the last block of host keys in the console output
+func parseHostKeys(output string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	inBlock := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, hostKeysBegin):
+			// the instance may have rebooted, the keys of the last boot win
+			keys, inBlock = nil, true
+		case strings.Contains(line, hostKeysEnd):
+			inBlock = false
+		case inBlock:
+			// lines may be prefixed by the kernel or cloud-init, the key starts at its type
+			fields := strings.Fields(line)
+			for i, field := range fields {
+				if !strings.HasPrefix(field, "ssh-") && !strings.HasPrefix(field, "ecdsa-") {
+					continue
+				}
+				key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " ")))
+				if err == nil {
+					keys = append(keys, key)
+				}
+				break
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T, keyType string) (ssh.PublicKey, string) {
+	t.Helper()
+	var public interface{}
+	switch keyType {
+	case ssh.KeyAlgoED25519:
+		key, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = key
+	case ssh.KeyAlgoECDSA256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = &key.PublicKey
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " root@ip-10-0-0-1"
+}
+
+func TestParseHostKeys(t *testing.T) {
+	ed25519Key, ed25519Line := newTestHostKey(t, ssh.KeyAlgoED25519)
+	ecdsaKey, ecdsaLine := newTestHostKey(t, ssh.KeyAlgoECDSA256)
+	rebootKey, rebootLine := newTestHostKey(t, ssh.KeyAlgoED25519)
+	tests := []struct {
+		name   string
+		output string
+		want   []ssh.PublicKey
+	}{
+		{
+			name:   "not printed yet",
+			output: "[    0.000000] Linux version 6.1.0\nCloud-init v. 23.1 running 'init'\n",
+		},
+		{
+			name: "keys",
+			output: "Cloud-init v. 23.1 running 'modules:final'\n" +
+				hostKeysBegin + "\n" + ecdsaLine + "\n" + ed25519Line + "\n" + hostKeysEnd + "\n" +
+				"Cloud-init v. 23.1 finished\n",
+			want: []ssh.PublicKey{ecdsaKey, ed25519Key},
+		},
+		{
+			name: "prefixed lines",
+			output: "[   42.1] cloud-init[1234]: " + hostKeysBegin + "\n" +
+				"[   42.1] cloud-init[1234]: " + ed25519Line + "\n" +
+				"[   42.1] cloud-init[1234]: " + hostKeysEnd + "\n",
+			want: []ssh.PublicKey{ed25519Key},
+		},
+		{
+			name: "invalid key",
+			output: hostKeysBegin + "\n" + "ssh-ed25519 not-base64 root@ip-10-0-0-1\n" + ed25519Line + "\n" +
+				hostKeysEnd + "\n",
+			want: []ssh.PublicKey{ed25519Key},
+		},
+		{
+			name: "outside of the block",
+			output: ed25519Line + "\n" + hostKeysBegin + "\n" + ecdsaLine + "\n" + hostKeysEnd + "\n" +
+				rebootLine + "\n",
+			want: []ssh.PublicKey{ecdsaKey},
+		},
+		{
+			name: "rebooted",
+			output: hostKeysBegin + "\n" + ed25519Line + "\n" + hostKeysEnd + "\n" +
+				hostKeysBegin + "\n" + rebootLine + "\n" + hostKeysEnd + "\n",
+			want: []ssh.PublicKey{rebootKey},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseHostKeys(tc.output)
+			if len(got) != len(tc.want) {
+				t.Fatalf("parseHostKeys() returned %d keys, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if ssh.FingerprintSHA256(got[i]) != ssh.FingerprintSHA256(tc.want[i]) {
+					t.Errorf("key %d is %s, want %s", i, ssh.FingerprintSHA256(got[i]), ssh.FingerprintSHA256(tc.want[i]))
+				}
+			}
+		})
+	}
+}
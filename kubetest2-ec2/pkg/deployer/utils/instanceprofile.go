@@ -9,11 +9,11 @@ import (
 	iamv2 "github.com/aws/aws-sdk-go-v2/service/iam"
 )
 
-func GetInstanceProfileArn(svc *iamv2.Client, instanceProfileName string) (string, error) {
+func GetInstanceProfileArn(ctx context.Context, svc *iamv2.Client, instanceProfileName string) (string, error) {
 	listInstanceProfilesInput := &iamv2.ListInstanceProfilesInput{
 		PathPrefix: awsv2.String("/kubetest2/"),
 	}
-	listInstanceProfilesResult, err := svc.ListInstanceProfiles(context.TODO(), listInstanceProfilesInput)
+	listInstanceProfilesResult, err := svc.ListInstanceProfiles(ctx, listInstanceProfilesInput)
 	if err != nil {
 		return "", err
 	}
@@ -27,13 +27,15 @@ func GetInstanceProfileArn(svc *iamv2.Client, instanceProfileName string) (strin
 	return "", fmt.Errorf("unable to find Arn for %s instance profile", instanceProfileName)
 }
 
-func EnsureInstanceProfile(svc *iamv2.Client, instanceProfileName string, roleName string) error {
+// EnsureInstanceProfile creates the instance profile of the role unless it exists, with the tags
+func EnsureInstanceProfile(ctx context.Context, svc *iamv2.Client, instanceProfileName string, roleName string,
+	tags map[string]string) error {
 
 	listInstanceProfilesInput := &iamv2.ListInstanceProfilesInput{
 		PathPrefix: awsv2.String("/kubetest2/"),
 	}
 
-	listInstanceProfilesResult, err := svc.ListInstanceProfiles(context.TODO(), listInstanceProfilesInput)
+	listInstanceProfilesResult, err := svc.ListInstanceProfiles(ctx, listInstanceProfilesInput)
 	if err != nil {
 		return err
 	}
@@ -51,16 +53,17 @@ func EnsureInstanceProfile(svc *iamv2.Client, instanceProfileName string, roleNa
 	createInput := &iamv2.CreateInstanceProfileInput{
 		InstanceProfileName: awsv2.String(instanceProfileName),
 		Path:                awsv2.String("/kubetest2/"),
+		Tags:                IAMTags(tags),
 	}
 
-	createResult, err := svc.CreateInstanceProfile(context.TODO(), createInput)
+	createResult, err := svc.CreateInstanceProfile(ctx, createInput)
 	if err != nil {
 		return fmt.Errorf("unable to create instance profile : %w", err)
 	}
 	klog.Infof("created instance profile: %v\n", *createResult.InstanceProfile.Arn)
 
 	listProfilesForRoleInput := &iamv2.ListInstanceProfilesForRoleInput{RoleName: awsv2.String(roleName)}
-	listProfilesForRoleResult, err := svc.ListInstanceProfilesForRole(context.TODO(), listProfilesForRoleInput)
+	listProfilesForRoleResult, err := svc.ListInstanceProfilesForRole(ctx, listProfilesForRoleInput)
 	if err != nil {
 		return fmt.Errorf("unable to list instance profile for role: %w", err)
 	}
@@ -73,7 +76,7 @@ func EnsureInstanceProfile(svc *iamv2.Client, instanceProfileName string, roleNa
 		InstanceProfileName: awsv2.String(instanceProfileName),
 		RoleName:            awsv2.String(roleName),
 	}
-	_, err = svc.AddRoleToInstanceProfile(context.TODO(), addInput)
+	_, err = svc.AddRoleToInstanceProfile(ctx, addInput)
 	if err != nil {
 		return fmt.Errorf("unable to add role to instance profile : %w", err)
 	}
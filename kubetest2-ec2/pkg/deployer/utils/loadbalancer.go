@@ -0,0 +1,256 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypesv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+
+	"k8s.io/klog/v2"
+)
+
+// APIServerPort is the port the kube-apiserver listens on, and the load balancer in front of it
+const APIServerPort = 6443
+
+// LoadBalancer is the network load balancer in front of the API servers of a cluster
+type LoadBalancer struct {
+	ARN            string
+	DNSName        string
+	TargetGroupARN string
+}
+
+// LoadBalancerName returns the name of the API server load balancer of the cluster, its
+// target group has the same name. Both are limited to 32 characters.
+func LoadBalancerName(clusterID string) string {
+	name := clusterID
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// CreateAPIServerLoadBalancer creates a network load balancer that forwards to the
+// API servers registered in its target group, and waits for it to be active. It is
+// internet-facing unless internal is set. The targets are registered by IP without client IP preservation, so a
+// control plane node can reach itself through the load balancer while joining.
+func CreateAPIServerLoadBalancer(ctx context.Context, svc *elbv2.Client, clusterID string, vpcID string,
+	subnetIDs []string, internal bool, extraTags map[string]string) (*LoadBalancer, error) {
+	name := LoadBalancerName(clusterID)
+	scheme := elbtypesv2.LoadBalancerSchemeEnumInternetFacing
+	if internal {
+		scheme = elbtypesv2.LoadBalancerSchemeEnumInternal
+	}
+	tags := append([]elbtypesv2.Tag{
+		{
+			Key:   awsv2.String(ClusterTag(clusterID)),
+			Value: awsv2.String("owned"),
+		},
+	}, ELBTags(extraTags)...)
+
+	tg, err := svc.CreateTargetGroup(ctx, &elbv2.CreateTargetGroupInput{
+		Name:                       awsv2.String(name),
+		Protocol:                   elbtypesv2.ProtocolEnumTcp,
+		Port:                       awsv2.Int32(APIServerPort),
+		VpcId:                      awsv2.String(vpcID),
+		TargetType:                 elbtypesv2.TargetTypeEnumIp,
+		HealthCheckProtocol:        elbtypesv2.ProtocolEnumTcp,
+		HealthCheckIntervalSeconds: awsv2.Int32(10),
+		HealthyThresholdCount:      awsv2.Int32(2),
+		UnhealthyThresholdCount:    awsv2.Int32(2),
+		Tags:                       tags,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating target group %s: %w", name, err)
+	}
+	lb := &LoadBalancer{TargetGroupARN: *tg.TargetGroups[0].TargetGroupArn}
+
+	created, err := svc.CreateLoadBalancer(ctx, &elbv2.CreateLoadBalancerInput{
+		Name:          awsv2.String(name),
+		Type:          elbtypesv2.LoadBalancerTypeEnumNetwork,
+		Scheme:        scheme,
+		IpAddressType: elbtypesv2.IpAddressTypeIpv4,
+		Subnets:       subnetIDs,
+		Tags:          tags,
+	})
+	if err != nil {
+		return lb, fmt.Errorf("creating load balancer %s: %w", name, err)
+	}
+	lb.ARN = *created.LoadBalancers[0].LoadBalancerArn
+	lb.DNSName = *created.LoadBalancers[0].DNSName
+
+	// the control plane nodes may all end up in a single zone
+	_, err = svc.ModifyLoadBalancerAttributes(ctx, &elbv2.ModifyLoadBalancerAttributesInput{
+		LoadBalancerArn: awsv2.String(lb.ARN),
+		Attributes: []elbtypesv2.LoadBalancerAttribute{
+			{
+				Key:   awsv2.String("load_balancing.cross_zone.enabled"),
+				Value: awsv2.String("true"),
+			},
+		},
+	})
+	if err != nil {
+		return lb, fmt.Errorf("enabling cross-zone load balancing on %s: %w", name, err)
+	}
+
+	_, err = svc.CreateListener(ctx, &elbv2.CreateListenerInput{
+		LoadBalancerArn: awsv2.String(lb.ARN),
+		Protocol:        elbtypesv2.ProtocolEnumTcp,
+		Port:            awsv2.Int32(APIServerPort),
+		DefaultActions: []elbtypesv2.Action{
+			{
+				Type:           elbtypesv2.ActionTypeEnumForward,
+				TargetGroupArn: awsv2.String(lb.TargetGroupARN),
+			},
+		},
+	})
+	if err != nil {
+		return lb, fmt.Errorf("creating listener on %s: %w", name, err)
+	}
+
+	klog.Infof("waiting for load balancer %s (%s) to be active", name, lb.DNSName)
+	err = elbv2.NewLoadBalancerAvailableWaiter(svc).Wait(ctx, &elbv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{lb.ARN},
+	}, 10*time.Minute)
+	if err != nil {
+		return lb, fmt.Errorf("waiting for load balancer %s: %w", name, err)
+	}
+	return lb, nil
+}
+
+// RegisterAPIServerTarget adds a control plane node to the target group of the load balancer
+func RegisterAPIServerTarget(ctx context.Context, svc *elbv2.Client, targetGroupARN string, ip string) error {
+	_, err := svc.RegisterTargets(ctx, &elbv2.RegisterTargetsInput{
+		TargetGroupArn: awsv2.String(targetGroupARN),
+		Targets: []elbtypesv2.TargetDescription{
+			{
+				Id:   awsv2.String(ip),
+				Port: awsv2.Int32(APIServerPort),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("registering %s with target group %s: %w", ip, targetGroupARN, err)
+	}
+	return nil
+}
+
+// DeleteAPIServerLoadBalancer deletes the load balancer of the cluster and its target
+// group, it is not an error if they do not exist.
+func DeleteAPIServerLoadBalancer(ctx context.Context, svc *elbv2.Client, clusterID string) error {
+	name := LoadBalancerName(clusterID)
+	lbs, err := svc.DescribeLoadBalancers(ctx, &elbv2.DescribeLoadBalancersInput{
+		Names: []string{name},
+	})
+	var lbNotFound *elbtypesv2.LoadBalancerNotFoundException
+	if err != nil && !errors.As(err, &lbNotFound) {
+		return fmt.Errorf("describing load balancer %s: %w", name, err)
+	}
+	if err == nil {
+		for _, lb := range lbs.LoadBalancers {
+			_, err = svc.DeleteLoadBalancer(ctx, &elbv2.DeleteLoadBalancerInput{
+				LoadBalancerArn: lb.LoadBalancerArn,
+			})
+			if err != nil {
+				return fmt.Errorf("deleting load balancer %s: %w", name, err)
+			}
+			err = elbv2.NewLoadBalancersDeletedWaiter(svc).Wait(ctx, &elbv2.DescribeLoadBalancersInput{
+				LoadBalancerArns: []string{*lb.LoadBalancerArn},
+			}, 5*time.Minute)
+			if err != nil {
+				return fmt.Errorf("waiting for load balancer %s to be deleted: %w", name, err)
+			}
+			klog.Infof("deleted load balancer %s", name)
+		}
+	}
+
+	tgs, err := svc.DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{
+		Names: []string{name},
+	})
+	var tgNotFound *elbtypesv2.TargetGroupNotFoundException
+	if errors.As(err, &tgNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("describing target group %s: %w", name, err)
+	}
+	for _, tg := range tgs.TargetGroups {
+		// the target group stays in use for a little while after its load balancer is gone
+		var inUse *elbtypesv2.ResourceInUseException
+		for i := 0; i < 10; i++ {
+			_, err = svc.DeleteTargetGroup(ctx, &elbv2.DeleteTargetGroupInput{
+				TargetGroupArn: tg.TargetGroupArn,
+			})
+			if !errors.As(err, &inUse) {
+				break
+			}
+			if err := Sleep(ctx, 10*time.Second); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("deleting target group %s: %w", name, err)
+		}
+		klog.Infof("deleted target group %s", name)
+	}
+	return nil
+}
+
+// EnsureVPCIngress allows TCP traffic on the port from anywhere in the VPC into the
+// security group, it returns the IDs of the rules it added which is empty when the
+// rule already existed.
+func EnsureVPCIngress(ctx context.Context, svc *ec2v2.Client, vpcID string, groupID string, port int32) ([]string, error) {
+	vpcs, err := svc.DescribeVpcs(ctx, &ec2v2.DescribeVpcsInput{VpcIds: []string{vpcID}})
+	if err != nil {
+		return nil, fmt.Errorf("describing vpc %s: %w", vpcID, err)
+	}
+	if len(vpcs.Vpcs) == 0 || vpcs.Vpcs[0].CidrBlock == nil {
+		return nil, fmt.Errorf("no ipv4 cidr block for vpc %s", vpcID)
+	}
+	cidr := *vpcs.Vpcs[0].CidrBlock
+	authorized, err := svc.AuthorizeSecurityGroupIngress(ctx, &ec2v2.AuthorizeSecurityGroupIngressInput{
+		GroupId: awsv2.String(groupID),
+		IpPermissions: []ec2typesv2.IpPermission{{
+			IpProtocol: awsv2.String("tcp"),
+			FromPort:   awsv2.Int32(port),
+			ToPort:     awsv2.Int32(port),
+			IpRanges:   []ec2typesv2.IpRange{{CidrIp: awsv2.String(cidr)}},
+		}},
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "InvalidPermission.Duplicate") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("authorizing tcp/%d ingress from %s on security group %s: %w", port, cidr, groupID, err)
+	}
+	var ruleIDs []string
+	for _, rule := range authorized.SecurityGroupRules {
+		if rule.SecurityGroupRuleId != nil {
+			ruleIDs = append(ruleIDs, *rule.SecurityGroupRuleId)
+		}
+	}
+	klog.Infof("allowed tcp/%d from %s in security group %s", port, cidr, groupID)
+	return ruleIDs, nil
+}
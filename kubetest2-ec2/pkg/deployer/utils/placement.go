@@ -0,0 +1,114 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// capacityErrors are the RunInstances error codes worth retrying with another
+// instance type or in another availability zone.
+var capacityErrors = []string{
+	"InsufficientInstanceCapacity",
+	"InsufficientCapacity",
+	"InsufficientHostCapacity",
+	// the instance type is not offered in the availability zone of the subnet
+	"Unsupported",
+}
+
+// IsCapacityError tells whether launching an instance failed because of a lack of
+// capacity for the instance type in the availability zone.
+func IsCapacityError(err error) bool {
+	for _, code := range capacityErrors {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+	return false
+}
+
+// Placement is a candidate instance type and subnet for an instance
+type Placement struct {
+	InstanceType string
+	Subnet       Subnet
+}
+
+// InstanceTypeZones returns the availability zones of the region in which each of
+// the instance types is offered.
+func InstanceTypeZones(ctx context.Context, svc *ec2v2.Client, instanceTypes []string) (map[string][]string, error) {
+	zones := map[string][]string{}
+	paginator := ec2v2.NewDescribeInstanceTypeOfferingsPaginator(svc, &ec2v2.DescribeInstanceTypeOfferingsInput{
+		LocationType: ec2typesv2.LocationTypeAvailabilityZone,
+		Filters: []ec2typesv2.Filter{
+			{
+				Name:   awsv2.String("instance-type"),
+				Values: instanceTypes,
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describing offerings of instance types %v: %w", instanceTypes, err)
+		}
+		for _, offering := range page.InstanceTypeOfferings {
+			instanceType := string(offering.InstanceType)
+			zones[instanceType] = append(zones[instanceType], awsv2.ToString(offering.Location))
+		}
+	}
+	return zones, nil
+}
+
+// Placements orders the candidate placements of an instance: the instance types in
+// order of preference and, for each of them, the subnets in the availability zones
+// it is offered in. The preferred subnet comes first, the others are shuffled to
+// spread the load. A nil zones map means the offerings are unknown and every subnet
+// is a candidate.
+func Placements(instanceTypes []string, subnets []Subnet, zones map[string][]string, preferredSubnetID string) []Placement {
+	shuffled := slices.Clone(subnets)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	slices.SortStableFunc(shuffled, func(a, b Subnet) int {
+		switch {
+		case a.ID == preferredSubnetID && b.ID != preferredSubnetID:
+			return -1
+		case b.ID == preferredSubnetID && a.ID != preferredSubnetID:
+			return 1
+		}
+		return 0
+	})
+
+	var placements []Placement
+	for _, instanceType := range instanceTypes {
+		for _, subnet := range shuffled {
+			if zones != nil && !slices.Contains(zones[instanceType], subnet.AvailabilityZone) {
+				continue
+			}
+			placements = append(placements, Placement{InstanceType: instanceType, Subnet: subnet})
+		}
+	}
+	return placements
+}
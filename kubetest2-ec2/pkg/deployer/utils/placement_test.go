@@ -0,0 +1,124 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestIsCapacityError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: errors.New("api error InsufficientInstanceCapacity: We currently do not have sufficient r5d.xlarge capacity"), want: true},
+		{err: errors.New("api error InsufficientCapacity: Insufficient capacity."), want: true},
+		{err: errors.New("api error InsufficientHostCapacity: no host"), want: true},
+		{err: errors.New("api error Unsupported: Your requested instance type is not supported in your requested Availability Zone"), want: true},
+		{err: errors.New("api error UnauthorizedOperation: You are not authorized to perform this operation."), want: false},
+		{err: errors.New("api error InvalidAMIID.NotFound: The image id does not exist"), want: false},
+	}
+	for _, tc := range tests {
+		if got := IsCapacityError(tc.err); got != tc.want {
+			t.Errorf("IsCapacityError(%q) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestPlacements(t *testing.T) {
+	subnets := []Subnet{
+		{ID: "subnet-a", AvailabilityZone: "us-east-1a"},
+		{ID: "subnet-b", AvailabilityZone: "us-east-1b"},
+		{ID: "subnet-c", AvailabilityZone: "us-east-1c"},
+	}
+	tests := []struct {
+		name          string
+		instanceTypes []string
+		zones         map[string][]string
+		preferred     string
+		// want are the subnets of each instance type in order, the subnets after the
+		// preferred one are shuffled and compared sorted
+		want [][]string
+	}{
+		{
+			name:          "unknown offerings",
+			instanceTypes: []string{"r5d.xlarge"},
+			want:          [][]string{{"subnet-a", "subnet-b", "subnet-c"}},
+		},
+		{
+			name:          "preferred subnet",
+			instanceTypes: []string{"r5d.xlarge"},
+			preferred:     "subnet-c",
+			want:          [][]string{{"subnet-c", "subnet-a", "subnet-b"}},
+		},
+		{
+			name:          "offered zones",
+			instanceTypes: []string{"r5d.xlarge", "m5d.xlarge"},
+			zones: map[string][]string{
+				"r5d.xlarge": {"us-east-1b"},
+				"m5d.xlarge": {"us-east-1a", "us-east-1c"},
+			},
+			want: [][]string{{"subnet-b"}, {"subnet-a", "subnet-c"}},
+		},
+		{
+			name:          "preferred subnet not offered",
+			instanceTypes: []string{"r5d.xlarge", "m5d.xlarge"},
+			zones: map[string][]string{
+				"r5d.xlarge": {"us-east-1b", "us-east-1c"},
+				"m5d.xlarge": {"us-east-1a"},
+			},
+			preferred: "subnet-a",
+			want:      [][]string{{"subnet-b", "subnet-c"}, {"subnet-a"}},
+		},
+		{
+			name:          "instance type offered nowhere",
+			instanceTypes: []string{"r5d.xlarge", "m5d.xlarge"},
+			zones:         map[string][]string{"m5d.xlarge": {"us-east-1a"}},
+			want:          [][]string{nil, {"subnet-a"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			placements := Placements(tc.instanceTypes, subnets, tc.zones, tc.preferred)
+			got := make([][]string, len(tc.instanceTypes))
+			next := 0
+			for _, placement := range placements {
+				// the placements of an instance type are all before those of the next one
+				for next < len(tc.instanceTypes) && tc.instanceTypes[next] != placement.InstanceType {
+					next++
+				}
+				if next == len(tc.instanceTypes) {
+					t.Fatalf("Placements() returned %v, out of the order of %v", placements, tc.instanceTypes)
+				}
+				got[next] = append(got[next], placement.Subnet.ID)
+			}
+			for i, ids := range got {
+				shuffled := ids
+				if tc.preferred != "" && len(ids) > 0 && ids[0] == tc.preferred {
+					shuffled = ids[1:]
+				}
+				sort.Strings(shuffled)
+				if !reflect.DeepEqual(ids, tc.want[i]) {
+					t.Errorf("Placements() put %s in %v, want %v", tc.instanceTypes[i], ids, tc.want[i])
+				}
+			}
+		})
+	}
+}
@@ -25,12 +25,20 @@ import (
 	"k8s.io/klog/v2"
 )
 
-func EnsureRole(svc *iamv2.Client, roleName string) error {
+// DefaultRoleName and DefaultInstanceProfileName are shared by all the runs that do not
+// set --role-name and --instance-profile, they are never deleted.
+const (
+	DefaultRoleName            = "provider-aws-test-role"
+	DefaultInstanceProfileName = "provider-aws-test-instance-profile"
+)
+
+// EnsureRole creates the role unless it exists, with the tags
+func EnsureRole(ctx context.Context, svc *iamv2.Client, roleName string, tags map[string]string) error {
 	listRolesInput := &iamv2.ListRolesInput{
 		PathPrefix: awsv2.String("/kubetest2/"),
 	}
 
-	listRolesResult, err := svc.ListRoles(context.TODO(), listRolesInput)
+	listRolesResult, err := svc.ListRoles(ctx, listRolesInput)
 	if err != nil {
 		return err
 	}
@@ -73,8 +81,9 @@ func EnsureRole(svc *iamv2.Client, roleName string) error {
 		RoleName:                 awsv2.String(roleName),
 		Path:                     awsv2.String("/kubetest2/"),
 		AssumeRolePolicyDocument: awsv2.String(string(rolePolicy)),
+		Tags:                     IAMTags(tags),
 	}
-	result, err := svc.CreateRole(context.TODO(), &createRoleInput)
+	result, err := svc.CreateRole(ctx, &createRoleInput)
 	if err != nil {
 		return err
 	}
@@ -89,10 +98,12 @@ func EnsureRole(svc *iamv2.Client, roleName string) error {
 		"arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy",
 		"arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy",
 		"arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess",
+		// lets the SSM agent on the nodes accept Session Manager sessions
+		"arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore",
 	}
 
 	for _, policy := range policies {
-		_, err = svc.AttachRolePolicy(context.TODO(), &iamv2.AttachRolePolicyInput{
+		_, err = svc.AttachRolePolicy(ctx, &iamv2.AttachRolePolicyInput{
 			PolicyArn: awsv2.String(policy),
 			RoleName:  awsv2.String(roleName),
 		})
@@ -27,12 +27,12 @@ import (
 	"golang.org/x/exp/maps"
 )
 
-func ValidateS3Bucket(s3Service *s3v2.Client, stageLocation string, stageVersion string, version string) error {
+func ValidateS3Bucket(ctx context.Context, s3Service *s3v2.Client, stageLocation string, stageVersion string, version string) error {
 	if strings.Contains(stageLocation, "://") {
 		return nil
 	}
 
-	results, err := s3Service.ListObjectsV2(context.TODO(), &s3v2.ListObjectsV2Input{
+	results, err := s3Service.ListObjectsV2(ctx, &s3v2.ListObjectsV2Input{
 		Bucket: awsv2.String(stageLocation),
 		Prefix: awsv2.String(version),
 	})
@@ -42,7 +42,7 @@ func ValidateS3Bucket(s3Service *s3v2.Client, stageLocation string, stageVersion
 			stageLocation,
 			err)
 	} else if results.KeyCount == nil || *results.KeyCount == 0 {
-		results, _ = s3Service.ListObjectsV2(context.TODO(), &s3v2.ListObjectsV2Input{
+		results, _ = s3Service.ListObjectsV2(ctx, &s3v2.ListObjectsV2Input{
 			Bucket: awsv2.String(stageLocation),
 			Prefix: awsv2.String("v"),
 		})
@@ -64,3 +64,27 @@ func ValidateS3Bucket(s3Service *s3v2.Client, stageLocation string, stageVersion
 	}
 	return nil
 }
+
+// ValidateStagedArchs checks that the server tarball of every architecture the nodes
+// need was staged with the version, the nodes download the one of their own.
+func ValidateStagedArchs(ctx context.Context, s3Service *s3v2.Client, stageLocation string, version string, archs []string) error {
+	if strings.Contains(stageLocation, "://") {
+		return nil
+	}
+	var missing []string
+	for _, arch := range archs {
+		key := version + "/kubernetes-server-linux-" + arch + ".tar.gz"
+		_, err := s3Service.HeadObject(ctx, &s3v2.HeadObjectInput{
+			Bucket: awsv2.String(stageLocation),
+			Key:    awsv2.String(key),
+		})
+		if err != nil {
+			missing = append(missing, arch)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("version %s in bucket %s has no server tarball for %s, build and stage it for every architecture of the cluster",
+			version, stageLocation, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -22,8 +22,8 @@ import (
 	ssmv2 "github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
-func GetSSMImage(ssmService *ssmv2.Client, path string) (string, error) {
-	rsp, err := ssmService.GetParameter(context.TODO(), &ssmv2.GetParameterInput{
+func GetSSMImage(ctx context.Context, ssmService *ssmv2.Client, path string) (string, error) {
+	rsp, err := ssmService.GetParameter(ctx, &ssmv2.GetParameterInput{
 		Name: &path,
 	})
 	if err != nil {
@@ -0,0 +1,154 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	ssmv2 "github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"k8s.io/klog/v2"
+)
+
+// SessionManagerPlugin is the binary that carries the data of SSM sessions, the
+// AWS CLI runs the same one for `aws ssm start-session`.
+const SessionManagerPlugin = "session-manager-plugin"
+
+// SSMTunnel forwards a local port to a port of an instance through SSM Session
+// Manager, the instance needs neither a public IP nor any inbound rule.
+type SSMTunnel struct {
+	InstanceID string
+	RemotePort int
+	LocalPort  int
+	sessionID  string
+	cmd        *exec.Cmd
+}
+
+// Address is where to connect to reach the remote port
+func (t *SSMTunnel) Address() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(t.LocalPort))
+}
+
+// StartSSMTunnel starts a port forwarding session to the instance and waits for the
+// local end to accept connections. The tunnel outlives ctx, it is only stopped by Close.
+func StartSSMTunnel(ctx context.Context, svc *ssmv2.Client, region string, instanceID string, remotePort int) (*SSMTunnel, error) {
+	plugin, err := exec.LookPath(SessionManagerPlugin)
+	if err != nil {
+		return nil, fmt.Errorf("%s is needed to reach instances through SSM: %w", SessionManagerPlugin, err)
+	}
+	localPort, err := freeLocalPort()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ssmv2.StartSessionInput{
+		Target:       awsv2.String(instanceID),
+		DocumentName: awsv2.String("AWS-StartPortForwardingSession"),
+		Parameters: map[string][]string{
+			"portNumber":      {strconv.Itoa(remotePort)},
+			"localPortNumber": {strconv.Itoa(localPort)},
+		},
+	}
+	session, err := svc.StartSession(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("starting ssm session to %s:%d: %w", instanceID, remotePort, err)
+	}
+	tunnel := &SSMTunnel{
+		InstanceID: instanceID,
+		RemotePort: remotePort,
+		LocalPort:  localPort,
+		sessionID:  awsv2.ToString(session.SessionId),
+	}
+
+	// same arguments as the AWS CLI passes to the plugin
+	sessionJSON, err := json.Marshal(map[string]string{
+		"SessionId":  awsv2.ToString(session.SessionId),
+		"StreamUrl":  awsv2.ToString(session.StreamUrl),
+		"TokenValue": awsv2.ToString(session.TokenValue),
+	})
+	if err != nil {
+		tunnel.Close(ctx, svc)
+		return nil, err
+	}
+	inputJSON, err := json.Marshal(map[string]interface{}{
+		"Target":       instanceID,
+		"DocumentName": *input.DocumentName,
+		"Parameters":   input.Parameters,
+	})
+	if err != nil {
+		tunnel.Close(ctx, svc)
+		return nil, err
+	}
+	// not tied to ctx: the tunnel of the API server is used after Up() returns
+	tunnel.cmd = exec.Command(plugin, string(sessionJSON), region, "StartSession", "",
+		string(inputJSON), fmt.Sprintf("https://ssm.%s.amazonaws.com", region))
+	tunnel.cmd.Stdout = os.Stderr
+	tunnel.cmd.Stderr = os.Stderr
+	if err := tunnel.cmd.Start(); err != nil {
+		tunnel.cmd = nil
+		tunnel.Close(ctx, svc)
+		return nil, fmt.Errorf("running %s: %w", SessionManagerPlugin, err)
+	}
+
+	for i := 0; i < 30; i++ {
+		conn, err := (&net.Dialer{Timeout: time.Second}).DialContext(ctx, "tcp", tunnel.Address())
+		if err == nil {
+			conn.Close()
+			klog.Infof("forwarding %s to %s:%d through ssm session %s", tunnel.Address(), instanceID, remotePort, tunnel.sessionID)
+			return tunnel, nil
+		}
+		if err := Sleep(ctx, time.Second); err != nil {
+			tunnel.Close(context.Background(), svc)
+			return nil, err
+		}
+	}
+	tunnel.Close(ctx, svc)
+	return nil, fmt.Errorf("timed out waiting for the ssm tunnel to %s:%d", instanceID, remotePort)
+}
+
+// Close stops the plugin and terminates the session
+func (t *SSMTunnel) Close(ctx context.Context, svc *ssmv2.Client) {
+	if t.cmd != nil && t.cmd.Process != nil {
+		_ = t.cmd.Process.Kill()
+		_ = t.cmd.Wait()
+	}
+	if t.sessionID == "" {
+		return
+	}
+	_, err := svc.TerminateSession(ctx, &ssmv2.TerminateSessionInput{SessionId: awsv2.String(t.sessionID)})
+	if err != nil {
+		klog.Warningf("unable to terminate ssm session %s: %v", t.sessionID, err)
+	}
+}
+
+// freeLocalPort asks the kernel for a port nobody listens on
+func freeLocalPort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("finding a free local port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbtypesv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	iamtypesv2 "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+const (
+	// RunIDTagKey, JobNameTagKey and CreatedByTagKey are added to the tags of every
+	// resource the deployer creates, so that its cost can be attributed to a job
+	RunIDTagKey     = "run-id"
+	JobNameTagKey   = "job-name"
+	CreatedByTagKey = "created-by"
+	CreatedBy       = "kubetest2-ec2"
+
+	// ExpiresAtTagKey is the RFC 3339 time at which the resource expires with
+	// --max-lifetime, the janitor deletes it once this time has passed
+	ExpiresAtTagKey = "expires-at"
+
+	// the limits of AWS on user tags, S3 objects only allow 10 tags
+	maxTags           = 40
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+	maxS3Tags         = 10
+)
+
+// ParseTags parses key=value pairs into tags, checking them against the limits of AWS
+func ParseTags(pairs []string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return nil, fmt.Errorf("invalid tag %q, the aws: prefix is reserved", pair)
+		}
+		if key == "Name" || strings.HasPrefix(key, ClusterTagPrefix) || strings.HasPrefix(key, "kubetest2-ec2/") {
+			return nil, fmt.Errorf("invalid tag %q, the deployer sets it", pair)
+		}
+		if len(key) > maxTagKeyLength || len(value) > maxTagValueLength {
+			return nil, fmt.Errorf("invalid tag %q, keys are limited to %d characters and values to %d",
+				pair, maxTagKeyLength, maxTagValueLength)
+		}
+		tags[key] = value
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("%d tags, at most %d are allowed", len(tags), maxTags)
+	}
+	return tags, nil
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EC2Tags converts tags for EC2, in a stable order
+func EC2Tags(tags map[string]string) []ec2typesv2.Tag {
+	var ret []ec2typesv2.Tag
+	for _, key := range sortedTagKeys(tags) {
+		ret = append(ret, ec2typesv2.Tag{Key: awsv2.String(key), Value: awsv2.String(tags[key])})
+	}
+	return ret
+}
+
+// IAMTags converts tags for IAM, in a stable order
+func IAMTags(tags map[string]string) []iamtypesv2.Tag {
+	var ret []iamtypesv2.Tag
+	for _, key := range sortedTagKeys(tags) {
+		ret = append(ret, iamtypesv2.Tag{Key: awsv2.String(key), Value: awsv2.String(tags[key])})
+	}
+	return ret
+}
+
+// ELBTags converts tags for ELB, in a stable order
+func ELBTags(tags map[string]string) []elbtypesv2.Tag {
+	var ret []elbtypesv2.Tag
+	for _, key := range sortedTagKeys(tags) {
+		ret = append(ret, elbtypesv2.Tag{Key: awsv2.String(key), Value: awsv2.String(tags[key])})
+	}
+	return ret
+}
+
+// S3Tagging encodes tags as the Tagging of an S3 object. S3 objects take at most 10
+// tags, the automatic ones are kept first and the others in order.
+func S3Tagging(tags map[string]string) string {
+	values := url.Values{}
+	for _, key := range []string{RunIDTagKey, JobNameTagKey, CreatedByTagKey, ExpiresAtTagKey} {
+		if value, ok := tags[key]; ok {
+			values.Set(key, value)
+		}
+	}
+	for _, key := range sortedTagKeys(tags) {
+		if len(values) == maxS3Tags {
+			break
+		}
+		values.Set(key, tags[key])
+	}
+	return values.Encode()
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tooMany := make([]string, maxTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("key%d=value", i)
+	}
+	tests := []struct {
+		name    string
+		pairs   []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "none",
+			pairs: nil,
+			want:  map[string]string{},
+		},
+		{
+			name:  "pairs",
+			pairs: []string{"team=node", "cost-center=1234"},
+			want:  map[string]string{"team": "node", "cost-center": "1234"},
+		},
+		{
+			name:  "empty value",
+			pairs: []string{"team="},
+			want:  map[string]string{"team": ""},
+		},
+		{
+			name:  "value with equal sign",
+			pairs: []string{"query=a=b"},
+			want:  map[string]string{"query": "a=b"},
+		},
+		{
+			name:  "key with spaces",
+			pairs: []string{" team =node"},
+			want:  map[string]string{"team": "node"},
+		},
+		{
+			name:  "last value wins",
+			pairs: []string{"team=node", "team=sig-node"},
+			want:  map[string]string{"team": "sig-node"},
+		},
+		{
+			name:    "missing value",
+			pairs:   []string{"team"},
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			pairs:   []string{"=node"},
+			wantErr: true,
+		},
+		{
+			name:    "aws prefix",
+			pairs:   []string{"AWS:createdBy=me"},
+			wantErr: true,
+		},
+		{
+			name:    "name",
+			pairs:   []string{"Name=node"},
+			wantErr: true,
+		},
+		{
+			name:    "cluster tag",
+			pairs:   []string{ClusterTagPrefix + "test=owned"},
+			wantErr: true,
+		},
+		{
+			name:    "deployer tag",
+			pairs:   []string{"kubetest2-ec2/role=worker"},
+			wantErr: true,
+		},
+		{
+			name:    "long key",
+			pairs:   []string{strings.Repeat("k", maxTagKeyLength+1) + "=value"},
+			wantErr: true,
+		},
+		{
+			name:    "long value",
+			pairs:   []string{"key=" + strings.Repeat("v", maxTagValueLength+1)},
+			wantErr: true,
+		},
+		{
+			name:    "too many",
+			pairs:   tooMany,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseTags(tc.pairs)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseTags(%q) returned %v, want error %t", tc.pairs, err, tc.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseTags(%q) = %v, want %v", tc.pairs, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestS3Tagging(t *testing.T) {
+	tags := map[string]string{
+		RunIDTagKey:     "run",
+		JobNameTagKey:   "job",
+		CreatedByTagKey: CreatedBy,
+		ExpiresAtTagKey: "2023-01-01T00:00:00Z",
+	}
+	for i := 0; i < maxS3Tags; i++ {
+		tags[fmt.Sprintf("key%d", i)] = "value"
+	}
+	values, err := url.ParseQuery(S3Tagging(tags))
+	if err != nil {
+		t.Fatalf("parsing the tagging: %v", err)
+	}
+	if len(values) != maxS3Tags {
+		t.Errorf("tagging has %d tags, want %d", len(values), maxS3Tags)
+	}
+	for _, key := range []string{RunIDTagKey, JobNameTagKey, CreatedByTagKey, ExpiresAtTagKey, "key0"} {
+		if values.Get(key) != tags[key] {
+			t.Errorf("tag %s is %q, want %q", key, values.Get(key), tags[key])
+		}
+	}
+}
@@ -23,28 +23,67 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/config"
 )
 
-func gzipAndBase64Encode(fileBytes []byte) (string, error) {
+// MaxUserDataSize is the most user data EC2 takes for an instance
+const MaxUserDataSize = 16384
+
+func gzipBytes(fileBytes []byte) ([]byte, error) {
 	var buffer bytes.Buffer
 	gz, err := gzip.NewWriterLevel(&buffer, flate.BestCompression)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if _, err := gz.Write(fileBytes); err != nil {
-		return "", err
+		return nil, err
 	}
 	if err := gz.Flush(); err != nil {
-		return "", err
+		return nil, err
 	}
 	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func gzipAndBase64Encode(fileBytes []byte) (string, error) {
+	data, err := gzipBytes(fileBytes)
+	if err != nil {
 		return "", err
 	}
-	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// EncodeUserData returns the base64 encoded user data of an instance, gzipped when
+// it is larger than EC2 takes, cloud-init unpacks it
+func EncodeUserData(userdata string) (string, error) {
+	if len(userdata) <= MaxUserDataSize {
+		return base64.StdEncoding.EncodeToString([]byte(userdata)), nil
+	}
+	return gzipAndBase64Encode([]byte(userdata))
+}
+
+// UserDataSize returns the size of the user data as EncodeUserData launches it
+func UserDataSize(userdata string) (int, error) {
+	if len(userdata) <= MaxUserDataSize {
+		return len(userdata), nil
+	}
+	data, err := gzipBytes([]byte(userdata))
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
 }
 
 func FetchConfigureScript(userDataFile string, replace func(string) string) (string, error) {
@@ -152,11 +191,90 @@ func FetchUbuntuFile(fileName string) string {
 	var err error
 	scriptBytes, err = config.ConfigFS.ReadFile(fileName)
 	if err != nil {
-		panic(fmt.Sprintf("error reading %s: %w", fileName, err))
+		panic(fmt.Sprintf("error reading %s: %v", fileName, err))
 	}
 	scriptString, err := gzipAndBase64Encode(scriptBytes)
 	if err != nil {
-		panic(fmt.Sprintf("error encoding %s: %w", fileName, err))
+		panic(fmt.Sprintf("error encoding %s: %v", fileName, err))
 	}
 	return scriptString
 }
+
+// WithMaxLifetime turns the user data into a MIME multi-part archive, which cloud-init
+// processes part by part, adding config/max-lifetime.sh that installs a systemd timer
+// powering the node off at expiresAt. The node is launched with a shutdown behavior of
+// terminate, so that it is deleted even if Down() never runs.
+func WithMaxLifetime(userdata string, expiresAt time.Time) (string, error) {
+	if strings.HasPrefix(strings.ToLower(userdata), "content-type: multipart/") {
+		return "", fmt.Errorf("user data is already a MIME multi-part archive")
+	}
+	scriptBytes, err := config.ConfigFS.ReadFile("max-lifetime.sh")
+	if err != nil {
+		return "", fmt.Errorf("error reading max-lifetime.sh: %w", err)
+	}
+	// a systemd calendar event
+	script := strings.ReplaceAll(string(scriptBytes), "{{EXPIRES_AT}}",
+		expiresAt.UTC().Format("2006-01-02 15:04:05 UTC"))
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\nMIME-Version: 1.0\r\n\r\n", w.Boundary())
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		// first, so that the timer is there even if the user data fails
+		{contentType: "text/x-shellscript", body: script},
+		// cloud-init tells the format of a text/plain part from its first line, e.g.
+		// #cloud-config or #!/bin/bash
+		{contentType: "text/plain", body: userdata},
+	}
+	for _, part := range parts {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {part.contentType + `; charset="utf-8"`},
+		})
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.WriteString(pw, part.body); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// KubeletArg is an entry of the kubeletExtraArgs of a kubeadm configuration
+type KubeletArg struct {
+	Name  string
+	Value string
+}
+
+// WithKubeletExtraArgs adds args at the top of the first kubeletExtraArgs list of a
+// kubeadm configuration, which may be embedded in a script, and returns false if it
+// has none.
+func WithKubeletExtraArgs(data string, args []KubeletArg) (string, bool) {
+	lines := strings.Split(data, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "kubeletExtraArgs:" || i+1 == len(lines) {
+			continue
+		}
+		next := lines[i+1]
+		if !strings.HasPrefix(strings.TrimSpace(next), "- ") {
+			continue
+		}
+		indent := next[:len(next)-len(strings.TrimLeft(next, " "))]
+		var entries []string
+		for _, arg := range args {
+			// a Go quoted string is a valid YAML double quoted one
+			entries = append(entries,
+				fmt.Sprintf("%s- name: %s", indent, arg.Name),
+				fmt.Sprintf("%s  value: %s", indent, strconv.Quote(arg.Value)))
+		}
+		lines = slices.Insert(lines, i+1, entries...)
+		return strings.Join(lines, "\n"), true
+	}
+	return data, false
+}
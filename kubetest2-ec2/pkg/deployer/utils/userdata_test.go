@@ -0,0 +1,156 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithMaxLifetime(t *testing.T) {
+	expiresAt := time.Date(2023, 6, 1, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	tests := []struct {
+		name     string
+		userdata string
+		wantErr  bool
+	}{
+		{
+			name:     "cloud config",
+			userdata: "#cloud-config\nruncmd:\n- echo hello\n",
+		},
+		{
+			name:     "script",
+			userdata: "#!/bin/bash\necho hello\n",
+		},
+		{
+			name:     "multipart",
+			userdata: "Content-Type: multipart/mixed; boundary=\"b\"\r\nMIME-Version: 1.0\r\n\r\n--b--\r\n",
+			wantErr:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := WithMaxLifetime(tc.userdata, expiresAt)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("WithMaxLifetime() returned %v, want error %t", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			header, body, _ := strings.Cut(got, "\r\n\r\n")
+			contentType, _, _ := strings.Cut(header, "\r\n")
+			mediaType, params, err := mime.ParseMediaType(strings.TrimPrefix(contentType, "Content-Type: "))
+			if err != nil || mediaType != "multipart/mixed" {
+				t.Fatalf("user data is %q, want multipart/mixed", contentType)
+			}
+			r := multipart.NewReader(strings.NewReader(body), params["boundary"])
+			var parts []string
+			var contentTypes []string
+			for {
+				part, err := r.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("reading the parts of the user data: %v", err)
+				}
+				data, err := io.ReadAll(part)
+				if err != nil {
+					t.Fatal(err)
+				}
+				parts = append(parts, string(data))
+				contentTypes = append(contentTypes, part.Header.Get("Content-Type"))
+			}
+			if len(parts) != 2 {
+				t.Fatalf("user data has %d parts, want 2", len(parts))
+			}
+			if !strings.HasPrefix(contentTypes[0], "text/x-shellscript") {
+				t.Errorf("the first part is %s, want text/x-shellscript", contentTypes[0])
+			}
+			for _, want := range []string{"kubetest2-max-lifetime.timer", "OnCalendar=2023-06-01 12:30:00 UTC"} {
+				if !strings.Contains(parts[0], want) {
+					t.Errorf("the first part does not contain %q", want)
+				}
+			}
+			if parts[1] != tc.userdata {
+				t.Errorf("the second part is %q, want %q", parts[1], tc.userdata)
+			}
+		})
+	}
+}
+
+func TestEncodeUserData(t *testing.T) {
+	tests := []struct {
+		name     string
+		userdata string
+		wantGzip bool
+	}{
+		{
+			name:     "small",
+			userdata: "#cloud-config\n",
+		},
+		{
+			name:     "at the limit",
+			userdata: "#cloud-config\n" + strings.Repeat("#", MaxUserDataSize-len("#cloud-config\n")),
+		},
+		{
+			name:     "too large",
+			userdata: "#cloud-config\n" + strings.Repeat("# a comment\n", MaxUserDataSize),
+			wantGzip: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := EncodeUserData(tc.userdata)
+			if err != nil {
+				t.Fatalf("EncodeUserData() returned %v", err)
+			}
+			data, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatalf("EncodeUserData() returned invalid base64: %v", err)
+			}
+			size, err := UserDataSize(tc.userdata)
+			if err != nil {
+				t.Fatalf("UserDataSize() returned %v", err)
+			}
+			if size != len(data) {
+				t.Errorf("UserDataSize() = %d, want %d", size, len(data))
+			}
+
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if (err == nil) != tc.wantGzip {
+				t.Fatalf("gzipped %t, want %t", err == nil, tc.wantGzip)
+			}
+			if tc.wantGzip {
+				if data, err = io.ReadAll(r); err != nil {
+					t.Fatalf("reading the gzipped user data: %v", err)
+				}
+			}
+			if string(data) != tc.userdata {
+				t.Errorf("EncodeUserData() encoded %d bytes, want the %d bytes of the user data", len(data), len(tc.userdata))
+			}
+		})
+	}
+}
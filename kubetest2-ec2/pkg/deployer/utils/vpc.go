@@ -0,0 +1,665 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultVpcCIDR is the IPv4 range of the VPC created for a cluster
+	DefaultVpcCIDR = "10.0.0.0/16"
+	// subnetBits is how many bits the subnets add to the VPC prefix, a /16 VPC gets /20
+	// subnets. IPv6 subnets are always the /64 of the /56 that Amazon hands out.
+	subnetBits = 4
+	// privateSubnetIndex is the offset of the private subnets among the subnets of the
+	// VPC, the public ones start at 0.
+	privateSubnetIndex = 8
+)
+
+// ClusterNetwork is the VPC created for a single cluster, see CreateClusterNetwork
+type ClusterNetwork struct {
+	VpcID             string
+	InternetGatewayID string
+	// PublicSubnets route to the internet gateway, one per availability zone
+	PublicSubnets []Subnet
+	// PrivateSubnets route through the NAT gateway if there is one, and only within
+	// the VPC otherwise. There is one per availability zone.
+	PrivateSubnets []Subnet
+	// SecurityGroupID is the group of all the instances of the cluster
+	SecurityGroupID string
+}
+
+// CreateClusterNetwork creates an isolated network for the cluster: a VPC with a public
+// and a private subnet in each of the first zones of the region, an internet gateway,
+// route tables and a security group that lets the nodes talk to each other. With
+// natGateway the private subnets reach the internet through a NAT gateway. Everything
+// carries the cluster tag so that DeleteClusterNetwork finds it, including when this
+// fails half way and returns what was created so far.
+func CreateClusterNetwork(ctx context.Context, svc *ec2v2.Client, clusterID string, cidr string,
+	zoneCount int, ipv6 bool, natGateway bool, tags map[string]string) (*ClusterNetwork, error) {
+	vpcPrefix, err := netip.ParsePrefix(cidr)
+	if err != nil || !vpcPrefix.Addr().Is4() {
+		return nil, fmt.Errorf("invalid IPv4 cidr for the vpc %q", cidr)
+	}
+	zones, err := availabilityZones(ctx, svc, zoneCount)
+	if err != nil {
+		return nil, err
+	}
+
+	network := &ClusterNetwork{}
+	vpc, err := svc.CreateVpc(ctx, &ec2v2.CreateVpcInput{
+		CidrBlock:                   awsv2.String(cidr),
+		AmazonProvidedIpv6CidrBlock: awsv2.Bool(ipv6),
+		TagSpecifications:           clusterTagSpecifications(ec2typesv2.ResourceTypeVpc, clusterID, clusterID, tags),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating vpc %s: %w", cidr, err)
+	}
+	network.VpcID = *vpc.Vpc.VpcId
+	klog.Infof("created vpc %s (%s) for cluster %s", network.VpcID, cidr, clusterID)
+
+	err = ec2v2.NewVpcAvailableWaiter(svc).Wait(ctx, &ec2v2.DescribeVpcsInput{
+		VpcIds: []string{network.VpcID},
+	}, 5*time.Minute)
+	if err != nil {
+		return network, fmt.Errorf("waiting for vpc %s: %w", network.VpcID, err)
+	}
+	// instances in the VPC resolve each other's names, kubeadm uses them as node names
+	for _, attribute := range []*ec2v2.ModifyVpcAttributeInput{
+		{VpcId: awsv2.String(network.VpcID), EnableDnsSupport: &ec2typesv2.AttributeBooleanValue{Value: awsv2.Bool(true)}},
+		{VpcId: awsv2.String(network.VpcID), EnableDnsHostnames: &ec2typesv2.AttributeBooleanValue{Value: awsv2.Bool(true)}},
+	} {
+		if _, err := svc.ModifyVpcAttribute(ctx, attribute); err != nil {
+			return network, fmt.Errorf("enabling dns on vpc %s: %w", network.VpcID, err)
+		}
+	}
+
+	var ipv6Prefix netip.Prefix
+	if ipv6 {
+		ipv6Prefix, err = waitForIPv6CIDR(ctx, svc, network.VpcID)
+		if err != nil {
+			return network, err
+		}
+	}
+
+	igw, err := svc.CreateInternetGateway(ctx, &ec2v2.CreateInternetGatewayInput{
+		TagSpecifications: clusterTagSpecifications(ec2typesv2.ResourceTypeInternetGateway, clusterID, clusterID, tags),
+	})
+	if err != nil {
+		return network, fmt.Errorf("creating internet gateway: %w", err)
+	}
+	network.InternetGatewayID = *igw.InternetGateway.InternetGatewayId
+	_, err = svc.AttachInternetGateway(ctx, &ec2v2.AttachInternetGatewayInput{
+		InternetGatewayId: awsv2.String(network.InternetGatewayID),
+		VpcId:             awsv2.String(network.VpcID),
+	})
+	if err != nil {
+		return network, fmt.Errorf("attaching internet gateway %s to vpc %s: %w", network.InternetGatewayID, network.VpcID, err)
+	}
+
+	publicRouteTable, err := createRouteTable(ctx, svc, clusterID, network.VpcID, "public", tags)
+	if err != nil {
+		return network, err
+	}
+	routes := []*ec2v2.CreateRouteInput{
+		{DestinationCidrBlock: awsv2.String("0.0.0.0/0")},
+	}
+	if ipv6 {
+		routes = append(routes, &ec2v2.CreateRouteInput{DestinationIpv6CidrBlock: awsv2.String("::/0")})
+	}
+	for _, route := range routes {
+		route.RouteTableId = awsv2.String(publicRouteTable)
+		route.GatewayId = awsv2.String(network.InternetGatewayID)
+		if _, err := svc.CreateRoute(ctx, route); err != nil {
+			return network, fmt.Errorf("adding default route to route table %s: %w", publicRouteTable, err)
+		}
+	}
+	privateRouteTable, err := createRouteTable(ctx, svc, clusterID, network.VpcID, "private", tags)
+	if err != nil {
+		return network, err
+	}
+
+	for i, zone := range zones {
+		for _, kind := range []struct {
+			name       string
+			index      int
+			routeTable string
+			public     bool
+		}{
+			{name: "public", index: i, routeTable: publicRouteTable, public: true},
+			{name: "private", index: privateSubnetIndex + i, routeTable: privateRouteTable},
+		} {
+			subnet, err := createSubnet(ctx, svc, clusterID, network.VpcID, zone, vpcPrefix, ipv6Prefix,
+				kind.index, kind.name, kind.public, tags)
+			if err != nil {
+				return network, err
+			}
+			_, err = svc.AssociateRouteTable(ctx, &ec2v2.AssociateRouteTableInput{
+				RouteTableId: awsv2.String(kind.routeTable),
+				SubnetId:     awsv2.String(subnet.ID),
+			})
+			if err != nil {
+				return network, fmt.Errorf("associating subnet %s with route table %s: %w", subnet.ID, kind.routeTable, err)
+			}
+			if kind.public {
+				network.PublicSubnets = append(network.PublicSubnets, subnet)
+			} else {
+				network.PrivateSubnets = append(network.PrivateSubnets, subnet)
+			}
+		}
+	}
+
+	if natGateway {
+		natGatewayID, err := createNATGateway(ctx, svc, clusterID, network.PublicSubnets[0].ID, tags)
+		if err != nil {
+			return network, err
+		}
+		_, err = svc.CreateRoute(ctx, &ec2v2.CreateRouteInput{
+			RouteTableId:         awsv2.String(privateRouteTable),
+			DestinationCidrBlock: awsv2.String("0.0.0.0/0"),
+			NatGatewayId:         awsv2.String(natGatewayID),
+		})
+		if err != nil {
+			return network, fmt.Errorf("adding default route to route table %s: %w", privateRouteTable, err)
+		}
+	}
+
+	network.SecurityGroupID, err = createClusterSecurityGroup(ctx, svc, clusterID, network.VpcID, cidr, ipv6Prefix, tags)
+	if err != nil {
+		return network, err
+	}
+	return network, nil
+}
+
+// availabilityZones returns the first count available zones of the region
+func availabilityZones(ctx context.Context, svc *ec2v2.Client, count int) ([]string, error) {
+	out, err := svc.DescribeAvailabilityZones(ctx, &ec2v2.DescribeAvailabilityZonesInput{
+		Filters: []ec2typesv2.Filter{
+			{Name: awsv2.String("zone-type"), Values: []string{"availability-zone"}},
+			{Name: awsv2.String("state"), Values: []string{"available"}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing availability zones: %w", err)
+	}
+	var zones []string
+	for _, zone := range out.AvailabilityZones {
+		zones = append(zones, *zone.ZoneName)
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("no available zones in the region")
+	}
+	sort.Strings(zones)
+	if len(zones) > count {
+		zones = zones[:count]
+	}
+	return zones, nil
+}
+
+// waitForIPv6CIDR returns the Amazon provided IPv6 range of the VPC once it is associated
+func waitForIPv6CIDR(ctx context.Context, svc *ec2v2.Client, vpcID string) (netip.Prefix, error) {
+	for i := 0; i < 30; i++ {
+		vpcs, err := svc.DescribeVpcs(ctx, &ec2v2.DescribeVpcsInput{VpcIds: []string{vpcID}})
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("describing vpc %s: %w", vpcID, err)
+		}
+		for _, vpc := range vpcs.Vpcs {
+			for _, assoc := range vpc.Ipv6CidrBlockAssociationSet {
+				if assoc.Ipv6CidrBlockState != nil &&
+					assoc.Ipv6CidrBlockState.State == ec2typesv2.VpcCidrBlockStateCodeAssociated {
+					return netip.ParsePrefix(awsv2.ToString(assoc.Ipv6CidrBlock))
+				}
+			}
+		}
+		if err := Sleep(ctx, 2*time.Second); err != nil {
+			return netip.Prefix{}, err
+		}
+	}
+	return netip.Prefix{}, fmt.Errorf("timed out waiting for the IPv6 cidr of vpc %s", vpcID)
+}
+
+func createRouteTable(ctx context.Context, svc *ec2v2.Client, clusterID string, vpcID string, name string,
+	tags map[string]string) (string, error) {
+	out, err := svc.CreateRouteTable(ctx, &ec2v2.CreateRouteTableInput{
+		VpcId:             awsv2.String(vpcID),
+		TagSpecifications: clusterTagSpecifications(ec2typesv2.ResourceTypeRouteTable, clusterID, clusterID+"-"+name, tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating %s route table in vpc %s: %w", name, vpcID, err)
+	}
+	return *out.RouteTable.RouteTableId, nil
+}
+
+// createNATGateway creates a NAT gateway in the public subnet and waits for it to be
+// available, it gives the private subnets outbound IPv4 access.
+func createNATGateway(ctx context.Context, svc *ec2v2.Client, clusterID string, subnetID string,
+	tags map[string]string) (string, error) {
+	eip, err := svc.AllocateAddress(ctx, &ec2v2.AllocateAddressInput{
+		Domain:            ec2typesv2.DomainTypeVpc,
+		TagSpecifications: clusterTagSpecifications(ec2typesv2.ResourceTypeElasticIp, clusterID, clusterID+"-nat", tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("allocating elastic ip for the nat gateway: %w", err)
+	}
+	nat, err := svc.CreateNatGateway(ctx, &ec2v2.CreateNatGatewayInput{
+		SubnetId:          awsv2.String(subnetID),
+		AllocationId:      eip.AllocationId,
+		TagSpecifications: clusterTagSpecifications(ec2typesv2.ResourceTypeNatgateway, clusterID, clusterID, tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating nat gateway in subnet %s: %w", subnetID, err)
+	}
+	natGatewayID := *nat.NatGateway.NatGatewayId
+	klog.Infof("waiting for nat gateway %s to be available", natGatewayID)
+	err = ec2v2.NewNatGatewayAvailableWaiter(svc).Wait(ctx, &ec2v2.DescribeNatGatewaysInput{
+		NatGatewayIds: []string{natGatewayID},
+	}, 10*time.Minute)
+	if err != nil {
+		return natGatewayID, fmt.Errorf("waiting for nat gateway %s: %w", natGatewayID, err)
+	}
+	return natGatewayID, nil
+}
+
+func createSubnet(ctx context.Context, svc *ec2v2.Client, clusterID string, vpcID string, zone string,
+	vpcPrefix netip.Prefix, ipv6Prefix netip.Prefix, index int, name string, public bool,
+	tags map[string]string) (Subnet, error) {
+	cidr, err := subnetPrefix(vpcPrefix, subnetBits, index)
+	if err != nil {
+		return Subnet{}, err
+	}
+	input := &ec2v2.CreateSubnetInput{
+		VpcId:             awsv2.String(vpcID),
+		AvailabilityZone:  awsv2.String(zone),
+		CidrBlock:         awsv2.String(cidr.String()),
+		TagSpecifications: clusterTagSpecifications(ec2typesv2.ResourceTypeSubnet, clusterID, clusterID+"-"+name+"-"+zone, tags),
+	}
+	if ipv6Prefix.IsValid() {
+		ipv6CIDR, err := subnetPrefix(ipv6Prefix, 64-ipv6Prefix.Bits(), index)
+		if err != nil {
+			return Subnet{}, err
+		}
+		input.Ipv6CidrBlock = awsv2.String(ipv6CIDR.String())
+	}
+	out, err := svc.CreateSubnet(ctx, input)
+	if err != nil {
+		return Subnet{}, fmt.Errorf("creating %s subnet %s in %s: %w", name, cidr, zone, err)
+	}
+	subnet := Subnet{ID: *out.Subnet.SubnetId, AvailabilityZone: zone}
+	if public {
+		_, err = svc.ModifySubnetAttribute(ctx, &ec2v2.ModifySubnetAttributeInput{
+			SubnetId:            awsv2.String(subnet.ID),
+			MapPublicIpOnLaunch: &ec2typesv2.AttributeBooleanValue{Value: awsv2.Bool(true)},
+		})
+		if err != nil {
+			return subnet, fmt.Errorf("enabling public IPs on subnet %s: %w", subnet.ID, err)
+		}
+	}
+	klog.Infof("created %s subnet %s (%s) in %s", name, subnet.ID, cidr, zone)
+	return subnet, nil
+}
+
+// subnetPrefix returns the index-th subnet of the parent prefix that is newBits longer
+func subnetPrefix(parent netip.Prefix, newBits int, index int) (netip.Prefix, error) {
+	bits := parent.Bits() + newBits
+	if bits > parent.Addr().BitLen() || index >= 1<<newBits {
+		return netip.Prefix{}, fmt.Errorf("no room for subnet %d of %d bits in %s", index, newBits, parent)
+	}
+	addr := parent.Masked().Addr().AsSlice()
+	for i := 0; i < newBits; i++ {
+		if index&(1<<(newBits-1-i)) != 0 {
+			pos := parent.Bits() + i
+			addr[pos/8] |= 0x80 >> (pos % 8)
+		}
+	}
+	subnet, _ := netip.AddrFromSlice(addr)
+	return netip.PrefixFrom(subnet, bits), nil
+}
+
+// createClusterSecurityGroup creates the security group of the instances. SSH and the
+// API server are reachable from anywhere, everything else only from within the VPC:
+// the kubeadm ports (etcd, kubelet, controller-manager, scheduler), the node ports and
+// whatever encapsulation or routing protocol the CNI uses.
+func createClusterSecurityGroup(ctx context.Context, svc *ec2v2.Client, clusterID string, vpcID string,
+	cidr string, ipv6Prefix netip.Prefix, tags map[string]string) (string, error) {
+	group, err := svc.CreateSecurityGroup(ctx, &ec2v2.CreateSecurityGroupInput{
+		GroupName:         awsv2.String(clusterID),
+		Description:       awsv2.String("kubetest2-ec2 cluster " + clusterID),
+		VpcId:             awsv2.String(vpcID),
+		TagSpecifications: clusterTagSpecifications(ec2typesv2.ResourceTypeSecurityGroup, clusterID, clusterID, tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating security group in vpc %s: %w", vpcID, err)
+	}
+	groupID := *group.GroupId
+
+	anywhere := []ec2typesv2.IpRange{{CidrIp: awsv2.String("0.0.0.0/0")}}
+	within := []ec2typesv2.IpRange{{CidrIp: awsv2.String(cidr)}}
+	var anywhereIPv6, withinIPv6 []ec2typesv2.Ipv6Range
+	if ipv6Prefix.IsValid() {
+		anywhereIPv6 = []ec2typesv2.Ipv6Range{{CidrIpv6: awsv2.String("::/0")}}
+		withinIPv6 = []ec2typesv2.Ipv6Range{{CidrIpv6: awsv2.String(ipv6Prefix.String())}}
+	}
+	permissions := []ec2typesv2.IpPermission{
+		{
+			IpProtocol: awsv2.String("tcp"),
+			FromPort:   awsv2.Int32(22),
+			ToPort:     awsv2.Int32(22),
+			IpRanges:   anywhere,
+			Ipv6Ranges: anywhereIPv6,
+		},
+		{
+			IpProtocol: awsv2.String("tcp"),
+			FromPort:   awsv2.Int32(APIServerPort),
+			ToPort:     awsv2.Int32(APIServerPort),
+			IpRanges:   anywhere,
+			Ipv6Ranges: anywhereIPv6,
+		},
+		{
+			IpProtocol: awsv2.String("-1"),
+			IpRanges:   within,
+			Ipv6Ranges: withinIPv6,
+		},
+	}
+	_, err = svc.AuthorizeSecurityGroupIngress(ctx, &ec2v2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       awsv2.String(groupID),
+		IpPermissions: permissions,
+	})
+	if err != nil {
+		return groupID, fmt.Errorf("authorizing ingress on security group %s: %w", groupID, err)
+	}
+	if ipv6Prefix.IsValid() {
+		// a new group only allows IPv4 egress
+		_, err = svc.AuthorizeSecurityGroupEgress(ctx, &ec2v2.AuthorizeSecurityGroupEgressInput{
+			GroupId: awsv2.String(groupID),
+			IpPermissions: []ec2typesv2.IpPermission{{
+				IpProtocol: awsv2.String("-1"),
+				Ipv6Ranges: anywhereIPv6,
+			}},
+		})
+		if err != nil {
+			return groupID, fmt.Errorf("authorizing IPv6 egress on security group %s: %w", groupID, err)
+		}
+	}
+	klog.Infof("created security group %s in vpc %s", groupID, vpcID)
+	return groupID, nil
+}
+
+func clusterTagSpecifications(resourceType ec2typesv2.ResourceType, clusterID string, name string,
+	tags map[string]string) []ec2typesv2.TagSpecification {
+	return []ec2typesv2.TagSpecification{
+		{
+			ResourceType: resourceType,
+			Tags: append([]ec2typesv2.Tag{
+				{
+					Key:   awsv2.String("Name"),
+					Value: awsv2.String(name),
+				},
+				{
+					Key:   awsv2.String(ClusterTag(clusterID)),
+					Value: awsv2.String("owned"),
+				},
+			}, EC2Tags(tags)...),
+		},
+	}
+}
+
+// DeleteClusterNetwork deletes the VPCs created by CreateClusterNetwork for the cluster
+// and everything in them, in dependency order. Instances still in the VPC are
+// terminated first. It is not an error if the cluster has no VPC.
+func DeleteClusterNetwork(ctx context.Context, svc *ec2v2.Client, clusterID string) error {
+	vpcs, err := svc.DescribeVpcs(ctx, &ec2v2.DescribeVpcsInput{
+		Filters: []ec2typesv2.Filter{
+			{Name: awsv2.String("tag:" + ClusterTag(clusterID)), Values: []string{"owned"}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("describing vpcs of cluster %s: %w", clusterID, err)
+	}
+	for _, vpc := range vpcs.Vpcs {
+		if err := deleteVPC(ctx, svc, *vpc.VpcId); err != nil {
+			return err
+		}
+	}
+
+	// the elastic IPs of the NAT gateways are only free once these are deleted
+	addresses, err := svc.DescribeAddresses(ctx, &ec2v2.DescribeAddressesInput{
+		Filters: []ec2typesv2.Filter{
+			{Name: awsv2.String("tag:" + ClusterTag(clusterID)), Values: []string{"owned"}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("describing elastic ips of cluster %s: %w", clusterID, err)
+	}
+	for _, address := range addresses.Addresses {
+		_, err := svc.ReleaseAddress(ctx, &ec2v2.ReleaseAddressInput{AllocationId: address.AllocationId})
+		if err != nil {
+			return fmt.Errorf("releasing elastic ip %s: %w", awsv2.ToString(address.PublicIp), err)
+		}
+		klog.Infof("released elastic ip %s", awsv2.ToString(address.PublicIp))
+	}
+	return nil
+}
+
+func deleteVPC(ctx context.Context, svc *ec2v2.Client, vpcID string) error {
+	inVPC := []ec2typesv2.Filter{{Name: awsv2.String("vpc-id"), Values: []string{vpcID}}}
+
+	if err := terminateVPCInstances(ctx, svc, vpcID); err != nil {
+		return err
+	}
+	if err := deleteNATGateways(ctx, svc, vpcID); err != nil {
+		return err
+	}
+
+	// the network interfaces of a deleted load balancer linger for a while, and keep
+	// the security group and the subnets in use
+	groups, err := svc.DescribeSecurityGroups(ctx, &ec2v2.DescribeSecurityGroupsInput{Filters: inVPC})
+	if err != nil {
+		return fmt.Errorf("describing security groups of vpc %s: %w", vpcID, err)
+	}
+	for _, group := range groups.SecurityGroups {
+		if awsv2.ToString(group.GroupName) == "default" {
+			continue
+		}
+		err := retryDependencyViolation(ctx, func() error {
+			_, err := svc.DeleteSecurityGroup(ctx, &ec2v2.DeleteSecurityGroupInput{GroupId: group.GroupId})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("deleting security group %s: %w", *group.GroupId, err)
+		}
+		klog.Infof("deleted security group %s", *group.GroupId)
+	}
+
+	subnets, err := svc.DescribeSubnets(ctx, &ec2v2.DescribeSubnetsInput{Filters: inVPC})
+	if err != nil {
+		return fmt.Errorf("describing subnets of vpc %s: %w", vpcID, err)
+	}
+	for _, subnet := range subnets.Subnets {
+		err := retryDependencyViolation(ctx, func() error {
+			_, err := svc.DeleteSubnet(ctx, &ec2v2.DeleteSubnetInput{SubnetId: subnet.SubnetId})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("deleting subnet %s: %w", *subnet.SubnetId, err)
+		}
+		klog.Infof("deleted subnet %s", *subnet.SubnetId)
+	}
+
+	routeTables, err := svc.DescribeRouteTables(ctx, &ec2v2.DescribeRouteTablesInput{Filters: inVPC})
+	if err != nil {
+		return fmt.Errorf("describing route tables of vpc %s: %w", vpcID, err)
+	}
+	for _, routeTable := range routeTables.RouteTables {
+		main := false
+		for _, assoc := range routeTable.Associations {
+			if awsv2.ToBool(assoc.Main) {
+				main = true
+				continue
+			}
+			_, err := svc.DisassociateRouteTable(ctx, &ec2v2.DisassociateRouteTableInput{
+				AssociationId: assoc.RouteTableAssociationId,
+			})
+			if err != nil && !strings.Contains(err.Error(), "InvalidAssociationID.NotFound") {
+				return fmt.Errorf("disassociating route table %s: %w", *routeTable.RouteTableId, err)
+			}
+		}
+		// the main route table goes away with the VPC
+		if main {
+			continue
+		}
+		_, err := svc.DeleteRouteTable(ctx, &ec2v2.DeleteRouteTableInput{RouteTableId: routeTable.RouteTableId})
+		if err != nil {
+			return fmt.Errorf("deleting route table %s: %w", *routeTable.RouteTableId, err)
+		}
+		klog.Infof("deleted route table %s", *routeTable.RouteTableId)
+	}
+
+	igws, err := svc.DescribeInternetGateways(ctx, &ec2v2.DescribeInternetGatewaysInput{
+		Filters: []ec2typesv2.Filter{{Name: awsv2.String("attachment.vpc-id"), Values: []string{vpcID}}},
+	})
+	if err != nil {
+		return fmt.Errorf("describing internet gateways of vpc %s: %w", vpcID, err)
+	}
+	for _, igw := range igws.InternetGateways {
+		_, err := svc.DetachInternetGateway(ctx, &ec2v2.DetachInternetGatewayInput{
+			InternetGatewayId: igw.InternetGatewayId,
+			VpcId:             awsv2.String(vpcID),
+		})
+		if err != nil {
+			return fmt.Errorf("detaching internet gateway %s: %w", *igw.InternetGatewayId, err)
+		}
+		_, err = svc.DeleteInternetGateway(ctx, &ec2v2.DeleteInternetGatewayInput{
+			InternetGatewayId: igw.InternetGatewayId,
+		})
+		if err != nil {
+			return fmt.Errorf("deleting internet gateway %s: %w", *igw.InternetGatewayId, err)
+		}
+		klog.Infof("deleted internet gateway %s", *igw.InternetGatewayId)
+	}
+
+	err = retryDependencyViolation(ctx, func() error {
+		_, err := svc.DeleteVpc(ctx, &ec2v2.DeleteVpcInput{VpcId: awsv2.String(vpcID)})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("deleting vpc %s: %w", vpcID, err)
+	}
+	klog.Infof("deleted vpc %s", vpcID)
+	return nil
+}
+
+// terminateVPCInstances terminates the instances left in the VPC and waits for them to
+// be gone, as their network interfaces keep the subnets in use.
+func terminateVPCInstances(ctx context.Context, svc *ec2v2.Client, vpcID string) error {
+	var instanceIDs []string
+	paginator := ec2v2.NewDescribeInstancesPaginator(svc, &ec2v2.DescribeInstancesInput{
+		Filters: []ec2typesv2.Filter{
+			{Name: awsv2.String("vpc-id"), Values: []string{vpcID}},
+			{
+				Name:   awsv2.String("instance-state-name"),
+				Values: []string{"pending", "running", "shutting-down", "stopping", "stopped"},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("describing instances of vpc %s: %w", vpcID, err)
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				instanceIDs = append(instanceIDs, *instance.InstanceId)
+			}
+		}
+	}
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+	_, err := svc.TerminateInstances(ctx, &ec2v2.TerminateInstancesInput{InstanceIds: instanceIDs})
+	if err != nil {
+		return fmt.Errorf("terminating instances %v of vpc %s: %w", instanceIDs, vpcID, err)
+	}
+	klog.Infof("waiting for instances %v of vpc %s to terminate", instanceIDs, vpcID)
+	err = ec2v2.NewInstanceTerminatedWaiter(svc).Wait(ctx, &ec2v2.DescribeInstancesInput{
+		InstanceIds: instanceIDs,
+	}, 10*time.Minute)
+	if err != nil {
+		return fmt.Errorf("waiting for instances %v to terminate: %w", instanceIDs, err)
+	}
+	return nil
+}
+
+// deleteNATGateways deletes the NAT gateways of the VPC and waits for them to be gone,
+// as they keep their subnet in use.
+func deleteNATGateways(ctx context.Context, svc *ec2v2.Client, vpcID string) error {
+	out, err := svc.DescribeNatGateways(ctx, &ec2v2.DescribeNatGatewaysInput{
+		Filter: []ec2typesv2.Filter{
+			{Name: awsv2.String("vpc-id"), Values: []string{vpcID}},
+			{Name: awsv2.String("state"), Values: []string{"pending", "available", "deleting"}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("describing nat gateways of vpc %s: %w", vpcID, err)
+	}
+	var natGatewayIDs []string
+	for _, nat := range out.NatGateways {
+		_, err := svc.DeleteNatGateway(ctx, &ec2v2.DeleteNatGatewayInput{NatGatewayId: nat.NatGatewayId})
+		if err != nil {
+			return fmt.Errorf("deleting nat gateway %s: %w", *nat.NatGatewayId, err)
+		}
+		natGatewayIDs = append(natGatewayIDs, *nat.NatGatewayId)
+	}
+	if len(natGatewayIDs) == 0 {
+		return nil
+	}
+	err = ec2v2.NewNatGatewayDeletedWaiter(svc).Wait(ctx, &ec2v2.DescribeNatGatewaysInput{
+		NatGatewayIds: natGatewayIDs,
+	}, 10*time.Minute)
+	if err != nil {
+		return fmt.Errorf("waiting for nat gateways %v to be deleted: %w", natGatewayIDs, err)
+	}
+	klog.Infof("deleted nat gateways %v", natGatewayIDs)
+	return nil
+}
+
+// retryDependencyViolation retries fn for a few minutes while it fails because the
+// resource is still in use
+func retryDependencyViolation(ctx context.Context, fn func() error) error {
+	var err error
+	for i := 0; i < 30; i++ {
+		err = fn()
+		if err == nil || !strings.Contains(err.Error(), "DependencyViolation") {
+			return err
+		}
+		if err := Sleep(ctx, 10*time.Second); err != nil {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package janitor
+
+import (
+	"context"
+	"fmt"
+
+	iamv2 "github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypesv2 "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+func deleteInstanceProfile(ctx context.Context, svc *iamv2.Client, profileName *string, roles []iamtypesv2.Role) error {
+	for _, role := range roles {
+		_, err := svc.RemoveRoleFromInstanceProfile(ctx, &iamv2.RemoveRoleFromInstanceProfileInput{
+			InstanceProfileName: profileName,
+			RoleName:            role.RoleName,
+		})
+		if err != nil {
+			return fmt.Errorf("removing role %s from instance profile: %w", *role.RoleName, err)
+		}
+	}
+	_, err := svc.DeleteInstanceProfile(ctx, &iamv2.DeleteInstanceProfileInput{
+		InstanceProfileName: profileName,
+	})
+	if err != nil {
+		return fmt.Errorf("deleting instance profile: %w", err)
+	}
+	return nil
+}
+
+// deleteRole detaches all policies of the role before deleting it, as IAM refuses to delete
+// a role that still has policies.
+func deleteRole(ctx context.Context, svc *iamv2.Client, roleName *string) error {
+	attached := iamv2.NewListAttachedRolePoliciesPaginator(svc, &iamv2.ListAttachedRolePoliciesInput{
+		RoleName: roleName,
+	})
+	for attached.HasMorePages() {
+		page, err := attached.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing attached policies: %w", err)
+		}
+		for _, policy := range page.AttachedPolicies {
+			_, err = svc.DetachRolePolicy(ctx, &iamv2.DetachRolePolicyInput{
+				PolicyArn: policy.PolicyArn,
+				RoleName:  roleName,
+			})
+			if err != nil {
+				return fmt.Errorf("detaching policy %s: %w", *policy.PolicyArn, err)
+			}
+		}
+	}
+
+	inline := iamv2.NewListRolePoliciesPaginator(svc, &iamv2.ListRolePoliciesInput{
+		RoleName: roleName,
+	})
+	for inline.HasMorePages() {
+		page, err := inline.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing inline policies: %w", err)
+		}
+		for _, policyName := range page.PolicyNames {
+			policyName := policyName
+			_, err = svc.DeleteRolePolicy(ctx, &iamv2.DeleteRolePolicyInput{
+				PolicyName: &policyName,
+				RoleName:   roleName,
+			})
+			if err != nil {
+				return fmt.Errorf("deleting inline policy %s: %w", policyName, err)
+			}
+		}
+	}
+
+	_, err := svc.DeleteRole(ctx, &iamv2.DeleteRoleInput{RoleName: roleName})
+	if err != nil {
+		return fmt.Errorf("deleting role: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package janitor sweeps AWS resources leaked by the kubetest2 ec2 deployer
+// and the node e2e runner, e.g. when a CI job is killed before Down() runs.
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	configv2 "github.com/aws/aws-sdk-go-v2/config"
+	ec2v2 "github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	iamv2 "github.com/aws/aws-sdk-go-v2/service/iam"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+// NodeE2ETag is the tag the node e2e AWS runner puts on the instances it launches.
+const NodeE2ETag = "Node-E2E-Test"
+
+// IAMPathPrefix is the path of the roles and instance profiles created by the deployer.
+const IAMPathPrefix = "/kubetest2/"
+
+const (
+	ResourceInstance        = "instance"
+	ResourceVolume          = "volume"
+	ResourceRole            = "iam-role"
+	ResourceInstanceProfile = "iam-instance-profile"
+)
+
+type Options struct {
+	// Regions to sweep, IAM resources are global and swept once.
+	Regions []string
+	// TTL is the age after which a resource is considered leaked.
+	TTL time.Duration
+	// NamePrefixes of the Name tag of instances and volumes to sweep.
+	NamePrefixes []string
+	// IAM also sweeps roles and instance profiles under IAMPathPrefix.
+	IAM bool
+	// DryRun only reports what would be deleted.
+	DryRun bool
+}
+
+// Resource is a single entry of the janitor report.
+type Resource struct {
+	Region  string    `json:"region,omitempty"`
+	Type    string    `json:"type"`
+	ID      string    `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	Created time.Time `json:"created"`
+	Age     string    `json:"age"`
+	Deleted bool      `json:"deleted"`
+	Error   string    `json:"error,omitempty"`
+
+	// RunID and JobName attribute the resource to the job that created it, from its tags
+	RunID   string `json:"runID,omitempty"`
+	JobName string `json:"jobName,omitempty"`
+}
+
+// owner describes the job that created the resource, if it is known
+func (r Resource) owner() string {
+	if r.JobName == "" && r.RunID == "" {
+		return ""
+	}
+	return fmt.Sprintf(", created by job %q run %s", r.JobName, r.RunID)
+}
+
+type Report struct {
+	DryRun    bool       `json:"dryRun"`
+	TTL       string     `json:"ttl"`
+	Resources []Resource `json:"resources"`
+}
+
+// WriteTo writes the report as JSON to the given file.
+func (r *Report) WriteTo(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Print logs a one line summary of each resource in the report.
+func (r *Report) Print() {
+	action := "deleted"
+	if r.DryRun {
+		action = "would delete"
+	}
+	for _, res := range r.Resources {
+		if res.Error != "" {
+			klog.Errorf("failed to delete %s %s (%s) in %s, age %s: %s", res.Type, res.ID, res.Name, res.Region, res.Age, res.Error)
+		} else {
+			klog.Infof("%s %s %s (%s) in %s, age %s%s", action, res.Type, res.ID, res.Name, res.Region, res.Age, res.owner())
+		}
+	}
+	klog.Infof("%d leaked resources older than %s or past their expires-at tag found", len(r.Resources), r.TTL)
+}
+
+type janitor struct {
+	opts   Options
+	now    time.Time
+	report *Report
+	// instance profiles used by instances that are not being swept
+	profilesInUse map[string]bool
+}
+
+// Run sweeps all the configured regions and returns a report of what was (or would be) deleted.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	j := &janitor{
+		opts: opts,
+		now:  time.Now(),
+		report: &Report{
+			DryRun: opts.DryRun,
+			TTL:    opts.TTL.String(),
+		},
+		profilesInUse: map[string]bool{},
+	}
+	var iamService *iamv2.Client
+	var ec2Service *ec2v2.Client
+	for _, region := range opts.Regions {
+		cfg, err := configv2.LoadDefaultConfig(ctx, configv2.WithRegion(region))
+		if err != nil {
+			return j.report, fmt.Errorf("unable to load default config for region %s, %w", region, err)
+		}
+		if iamService == nil {
+			iamService = iamv2.NewFromConfig(cfg)
+		}
+		ec2Service = ec2v2.NewFromConfig(cfg)
+		if err := j.sweepInstances(ctx, ec2Service, region); err != nil {
+			return j.report, err
+		}
+		if err := j.sweepVolumes(ctx, ec2Service, region); err != nil {
+			return j.report, err
+		}
+	}
+	if opts.IAM && iamService != nil {
+		if err := j.findProfilesInUse(ctx, ec2Service); err != nil {
+			return j.report, err
+		}
+		if err := j.sweepIAM(ctx, iamService); err != nil {
+			return j.report, err
+		}
+	}
+	return j.report, nil
+}
+
+func (j *janitor) expired(created *time.Time) bool {
+	return created != nil && j.now.Sub(*created) > j.opts.TTL
+}
+
+// expiredAt is expired, or the expires-at tag --max-lifetime put on the resource has
+// passed, whichever comes first
+func (j *janitor) expiredAt(created *time.Time, expiresAt string) bool {
+	if t, err := time.Parse(time.RFC3339, expiresAt); err == nil && j.now.After(t) {
+		return true
+	}
+	return j.expired(created)
+}
+
+func (j *janitor) hasNamePrefix(name string) bool {
+	for _, prefix := range j.opts.NamePrefixes {
+		if prefix != "" && strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTestInstance returns true for instances launched by utils.LaunchNewInstance (named after the
+// cluster they are tagged with, or tagged as created by the deployer) or by the node e2e runner.
+func (j *janitor) isTestInstance(instance ec2typesv2.Instance) bool {
+	name := utils.InstanceTag(instance, "Name")
+	if j.hasNamePrefix(name) {
+		return true
+	}
+	for _, tag := range instance.Tags {
+		if tag.Key == nil {
+			continue
+		}
+		if *tag.Key == NodeE2ETag {
+			return true
+		}
+		if *tag.Key == utils.CreatedByTagKey && awsv2.ToString(tag.Value) == utils.CreatedBy {
+			return true
+		}
+		if clusterID, ok := strings.CutPrefix(*tag.Key, utils.ClusterTagPrefix); ok &&
+			clusterID != "" && strings.HasPrefix(name, clusterID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *janitor) record(res Resource, err error) {
+	res.Age = j.now.Sub(res.Created).Round(time.Minute).String()
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Deleted = !j.opts.DryRun
+	}
+	j.report.Resources = append(j.report.Resources, res)
+}
+
+func (j *janitor) sweepInstances(ctx context.Context, svc *ec2v2.Client, region string) error {
+	paginator := ec2v2.NewDescribeInstancesPaginator(svc, &ec2v2.DescribeInstancesInput{
+		Filters: []ec2typesv2.Filter{
+			{
+				Name: awsv2.String("instance-state-name"),
+				Values: []string{
+					string(ec2typesv2.InstanceStateNamePending),
+					string(ec2typesv2.InstanceStateNameRunning),
+					string(ec2typesv2.InstanceStateNameStopping),
+					string(ec2typesv2.InstanceStateNameStopped),
+				},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("describing instances in %s: %w", region, err)
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if !j.isTestInstance(instance) || !j.expiredAt(instance.LaunchTime, utils.InstanceTag(instance, utils.ExpiresAtTagKey)) {
+					if instance.IamInstanceProfile != nil && instance.IamInstanceProfile.Arn != nil {
+						j.profilesInUse[*instance.IamInstanceProfile.Arn] = true
+					}
+					continue
+				}
+				res := Resource{
+					Region:  region,
+					Type:    ResourceInstance,
+					ID:      *instance.InstanceId,
+					Name:    utils.InstanceTag(instance, "Name"),
+					RunID:   utils.InstanceTag(instance, utils.RunIDTagKey),
+					JobName: utils.InstanceTag(instance, utils.JobNameTagKey),
+					Created: *instance.LaunchTime,
+				}
+				var err error
+				if !j.opts.DryRun {
+					_, err = svc.TerminateInstances(ctx, &ec2v2.TerminateInstancesInput{
+						InstanceIds: []string{res.ID},
+					})
+				}
+				j.record(res, err)
+			}
+		}
+	}
+	return nil
+}
+
+// sweepVolumes deletes unattached volumes named like the test instances or created by the deployer.
+func (j *janitor) sweepVolumes(ctx context.Context, svc *ec2v2.Client, region string) error {
+	paginator := ec2v2.NewDescribeVolumesPaginator(svc, &ec2v2.DescribeVolumesInput{
+		Filters: []ec2typesv2.Filter{
+			{
+				Name:   awsv2.String("status"),
+				Values: []string{string(ec2typesv2.VolumeStateAvailable)},
+			},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("describing volumes in %s: %w", region, err)
+		}
+		for _, volume := range page.Volumes {
+			tags := map[string]string{}
+			for _, tag := range volume.Tags {
+				if tag.Key != nil && tag.Value != nil {
+					tags[*tag.Key] = *tag.Value
+				}
+			}
+			name := tags["Name"]
+			createdByDeployer := tags[utils.CreatedByTagKey] == utils.CreatedBy
+			if !(j.hasNamePrefix(name) || createdByDeployer) || !j.expiredAt(volume.CreateTime, tags[utils.ExpiresAtTagKey]) {
+				continue
+			}
+			res := Resource{
+				Region:  region,
+				Type:    ResourceVolume,
+				ID:      *volume.VolumeId,
+				Name:    name,
+				RunID:   tags[utils.RunIDTagKey],
+				JobName: tags[utils.JobNameTagKey],
+				Created: *volume.CreateTime,
+			}
+			var err error
+			if !j.opts.DryRun {
+				_, err = svc.DeleteVolume(ctx, &ec2v2.DeleteVolumeInput{
+					VolumeId: volume.VolumeId,
+				})
+			}
+			j.record(res, err)
+		}
+	}
+	return nil
+}
+
+// findProfilesInUse adds the instance profiles of the instances of the enabled regions that
+// are not swept to profilesInUse, IAM is global and sweepIAM must not delete them
+func (j *janitor) findProfilesInUse(ctx context.Context, svc *ec2v2.Client) error {
+	swept := map[string]bool{}
+	for _, region := range j.opts.Regions {
+		swept[region] = true
+	}
+	regions, err := svc.DescribeRegions(ctx, &ec2v2.DescribeRegionsInput{})
+	if err != nil {
+		return fmt.Errorf("describing regions: %w", err)
+	}
+	for _, region := range regions.Regions {
+		name := awsv2.ToString(region.RegionName)
+		if swept[name] {
+			continue
+		}
+		paginator := ec2v2.NewDescribeInstancesPaginator(svc, &ec2v2.DescribeInstancesInput{
+			Filters: []ec2typesv2.Filter{
+				{
+					Name: awsv2.String("instance-state-name"),
+					Values: []string{
+						string(ec2typesv2.InstanceStateNamePending),
+						string(ec2typesv2.InstanceStateNameRunning),
+						string(ec2typesv2.InstanceStateNameShuttingDown),
+						string(ec2typesv2.InstanceStateNameStopping),
+						string(ec2typesv2.InstanceStateNameStopped),
+					},
+				},
+			},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx, func(o *ec2v2.Options) {
+				o.Region = name
+			})
+			if err != nil {
+				return fmt.Errorf("describing instances in %s: %w", name, err)
+			}
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					if instance.IamInstanceProfile != nil && instance.IamInstanceProfile.Arn != nil {
+						j.profilesInUse[*instance.IamInstanceProfile.Arn] = true
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sweepIAM deletes the instance profiles and roles created by utils.EnsureRole and
+// utils.EnsureInstanceProfile, unless an instance that is not being swept still uses them.
+// The default role and instance profile are shared by all the runs and always kept.
+func (j *janitor) sweepIAM(ctx context.Context, svc *iamv2.Client) error {
+	skipRoles := map[string]bool{utils.DefaultRoleName: true}
+	profiles := iamv2.NewListInstanceProfilesPaginator(svc, &iamv2.ListInstanceProfilesInput{
+		PathPrefix: awsv2.String(IAMPathPrefix),
+	})
+	for profiles.HasMorePages() {
+		page, err := profiles.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing instance profiles: %w", err)
+		}
+		for _, profile := range page.InstanceProfiles {
+			if *profile.InstanceProfileName == utils.DefaultInstanceProfileName ||
+				j.profilesInUse[*profile.Arn] || !j.expired(profile.CreateDate) {
+				for _, role := range profile.Roles {
+					skipRoles[*role.RoleName] = true
+				}
+				continue
+			}
+			res := Resource{
+				Type:    ResourceInstanceProfile,
+				ID:      *profile.Arn,
+				Name:    *profile.InstanceProfileName,
+				Created: *profile.CreateDate,
+			}
+			var err error
+			if !j.opts.DryRun {
+				err = deleteInstanceProfile(ctx, svc, profile.InstanceProfileName, profile.Roles)
+			}
+			j.record(res, err)
+		}
+	}
+
+	roles := iamv2.NewListRolesPaginator(svc, &iamv2.ListRolesInput{
+		PathPrefix: awsv2.String(IAMPathPrefix),
+	})
+	for roles.HasMorePages() {
+		page, err := roles.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing roles: %w", err)
+		}
+		for _, role := range page.Roles {
+			if skipRoles[*role.RoleName] || !j.expired(role.CreateDate) {
+				continue
+			}
+			res := Resource{
+				Type:    ResourceRole,
+				ID:      *role.Arn,
+				Name:    *role.RoleName,
+				Created: *role.CreateDate,
+			}
+			var err error
+			if !j.opts.DryRun {
+				err = deleteRole(ctx, svc, role.RoleName)
+			}
+			j.record(res, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package janitor
+
+import (
+	"testing"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	ec2typesv2 "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"sigs.k8s.io/provider-aws-test-infra/kubetest2-ec2/pkg/deployer/utils"
+)
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		created *time.Time
+		want    bool
+	}{
+		{
+			name: "no creation time",
+		},
+		{
+			name:    "younger than the TTL",
+			created: awsv2.Time(now.Add(-5 * time.Hour)),
+		},
+		{
+			name:    "as old as the TTL",
+			created: awsv2.Time(now.Add(-6 * time.Hour)),
+		},
+		{
+			name:    "older than the TTL",
+			created: awsv2.Time(now.Add(-7 * time.Hour)),
+			want:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			j := &janitor{opts: Options{TTL: 6 * time.Hour}, now: now}
+			if got := j.expired(tc.created); got != tc.want {
+				t.Errorf("expired() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExpiredAt(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		created   *time.Time
+		expiresAt string
+		want      bool
+	}{
+		{
+			name:    "no expires-at, younger than the TTL",
+			created: awsv2.Time(now.Add(-time.Hour)),
+		},
+		{
+			name:    "no expires-at, older than the TTL",
+			created: awsv2.Time(now.Add(-7 * time.Hour)),
+			want:    true,
+		},
+		{
+			name:      "expired before the TTL",
+			created:   awsv2.Time(now.Add(-time.Hour)),
+			expiresAt: now.Add(-time.Minute).Format(time.RFC3339),
+			want:      true,
+		},
+		{
+			name:      "not expired yet",
+			created:   awsv2.Time(now.Add(-time.Hour)),
+			expiresAt: now.Add(time.Minute).Format(time.RFC3339),
+		},
+		{
+			name:      "older than the TTL before it expires",
+			created:   awsv2.Time(now.Add(-7 * time.Hour)),
+			expiresAt: now.Add(time.Hour).Format(time.RFC3339),
+			want:      true,
+		},
+		{
+			name:      "invalid expires-at",
+			created:   awsv2.Time(now.Add(-time.Hour)),
+			expiresAt: "tomorrow",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			j := &janitor{opts: Options{TTL: 6 * time.Hour}, now: now}
+			if got := j.expiredAt(tc.created, tc.expiresAt); got != tc.want {
+				t.Errorf("expiredAt() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHasNamePrefix(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		want     bool
+	}{
+		{name: "cid-test-cluster-control-plane", prefixes: []string{"cid-", "tmp-e2e-"}, want: true},
+		{name: "tmp-e2e-1234", prefixes: []string{"cid-", "tmp-e2e-"}, want: true},
+		{name: "production-web", prefixes: []string{"cid-", "tmp-e2e-"}},
+		{name: "", prefixes: []string{"cid-"}},
+		// an empty prefix matches nothing rather than everything
+		{name: "production-web", prefixes: []string{""}},
+		{name: "cid-test-cluster"},
+	}
+	for _, tc := range tests {
+		j := &janitor{opts: Options{NamePrefixes: tc.prefixes}}
+		if got := j.hasNamePrefix(tc.name); got != tc.want {
+			t.Errorf("hasNamePrefix(%q) with prefixes %q = %t, want %t", tc.name, tc.prefixes, got, tc.want)
+		}
+	}
+}
+
+func TestIsTestInstance(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want bool
+	}{
+		{
+			name: "no tags",
+		},
+		{
+			name: "name prefix",
+			tags: map[string]string{"Name": "tmp-node-e2e-1234"},
+			want: true,
+		},
+		{
+			name: "node e2e",
+			tags: map[string]string{"Name": "ubuntu", NodeE2ETag: ""},
+			want: true,
+		},
+		{
+			name: "created by the deployer",
+			tags: map[string]string{"Name": "web-1", utils.CreatedByTagKey: utils.CreatedBy},
+			want: true,
+		},
+		{
+			name: "created by something else",
+			tags: map[string]string{"Name": "web-1", utils.CreatedByTagKey: "terraform"},
+		},
+		{
+			name: "named after its cluster",
+			tags: map[string]string{"Name": "my-cluster-worker-1", utils.ClusterTag("my-cluster"): "owned"},
+			want: true,
+		},
+		{
+			name: "named after another cluster",
+			tags: map[string]string{"Name": "web-1", utils.ClusterTag("my-cluster"): "owned"},
+		},
+		{
+			name: "empty cluster tag",
+			tags: map[string]string{"Name": "web-1", utils.ClusterTagPrefix: "owned"},
+		},
+		{
+			name: "other instance",
+			tags: map[string]string{"Name": "production-web", "team": "web"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var instance ec2typesv2.Instance
+			for key, value := range tc.tags {
+				instance.Tags = append(instance.Tags, ec2typesv2.Tag{Key: awsv2.String(key), Value: awsv2.String(value)})
+			}
+			j := &janitor{opts: Options{NamePrefixes: []string{"cid-", "tmp-e2e-", "tmp-node-e2e-"}}}
+			if got := j.isTestInstance(instance); got != tc.want {
+				t.Errorf("isTestInstance() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/google/uuid"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/test/e2e_node/remote"
+)
+
+// The layout of the bucket is the one of the --artifacts-bucket of kubetest2-ec2, so
+// that both may share a bucket and expire each other's runs.
+const (
+	// artifactsIndex is the object listing the results of a run
+	artifactsIndex = "index.json"
+	// artifactsExpiresAtKey is the metadata of the index telling when the run may be deleted
+	artifactsExpiresAtKey = "expires-at"
+	// maxPresignExpiry is the longest a presigned link may last
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// uploadedFile is a result uploaded to --artifacts-bucket
+type uploadedFile struct {
+	// Path is relative to --results-dir, with slashes
+	Path string `json:"path"`
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	URL  string `json:"url,omitempty"`
+}
+
+// resultsIndex lists the results uploaded for a run
+type resultsIndex struct {
+	RunID      string         `json:"runID"`
+	Bucket     string         `json:"bucket"`
+	Prefix     string         `json:"prefix"`
+	UploadedAt time.Time      `json:"uploadedAt"`
+	ExpiresAt  *time.Time     `json:"expiresAt,omitempty"`
+	Files      []uploadedFile `json:"files"`
+}
+
+var resultsIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Node e2e results of {{.RunID}}</title>
+</head>
+<body>
+<h1>Node e2e results of {{.RunID}}</h1>
+<p>s3://{{.Bucket}}/{{.Prefix}}, uploaded at {{.UploadedAt.Format "2006-01-02 15:04:05 MST"}}{{if .ExpiresAt}}, deleted after {{.ExpiresAt.Format "2006-01-02 15:04:05 MST"}}{{end}}</p>
+<table>
+<tr><th align="left">File</th><th align="right">Bytes</th></tr>
+{{range .Files}}<tr><td>{{if .URL}}<a href="{{.URL}}">{{.Path}}</a>{{else}}{{.Path}}{{end}}</td><td align="right">{{.Size}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// defaultRunID is the one of kubetest2, the ID of the prow job if there is one
+func defaultRunID() string {
+	if id := os.Getenv("PROW_JOB_ID"); id != "" {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// artifactsLocation splits --artifacts-bucket into the bucket and the prefix
+func artifactsLocation() (string, string) {
+	location := strings.TrimPrefix(*artifactsBucket, "s3://")
+	bucket, prefix, _ := strings.Cut(location, "/")
+	return bucket, strings.Trim(prefix, "/")
+}
+
+// uploadResults uploads --results-dir to the prefix of the run in --artifacts-bucket,
+// with an index of presigned links. It runs after the tests of every instance, each
+// upload replaces the index of the previous one with that of all the results so far.
+func (a *AWSRunner) uploadResults() error {
+	if *artifactsBucket == "" {
+		return nil
+	}
+	a.uploadLock.Lock()
+	defer a.uploadLock.Unlock()
+	ctx := context.TODO()
+
+	bucket, prefix := artifactsLocation()
+	if !a.expiredRunsDeleted {
+		if err := a.deleteExpiredRuns(ctx, bucket, prefix); err != nil {
+			klog.Warningf("unable to delete the expired runs of s3://%s/%s: %v", bucket, prefix, err)
+		}
+		a.expiredRunsDeleted = true
+	}
+
+	now := time.Now().UTC()
+	index := resultsIndex{
+		RunID:      *runID,
+		Bucket:     bucket,
+		Prefix:     path.Join(prefix, *runID),
+		UploadedAt: now,
+	}
+	linkExpiry := maxPresignExpiry
+	metadata := map[string]string{}
+	if *artifactsRetention > 0 {
+		expiresAt := now.Add(*artifactsRetention)
+		index.ExpiresAt = &expiresAt
+		metadata[artifactsExpiresAtKey] = expiresAt.Format(time.RFC3339)
+		if *artifactsRetention < linkExpiry {
+			linkExpiry = *artifactsRetention
+		}
+	}
+
+	resultsDir := remoteFlag("results-dir")
+	klog.Infof("uploading %s to s3://%s/%s", resultsDir, bucket, index.Prefix)
+	err := filepath.WalkDir(resultsDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(resultsDir, file)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		uploaded := uploadedFile{Path: filepath.ToSlash(rel), Key: path.Join(index.Prefix, filepath.ToSlash(rel)), Size: info.Size()}
+		if err := a.putFile(ctx, bucket, uploaded.Key, file); err != nil {
+			return err
+		}
+		if uploaded.URL, err = a.presignGetObject(ctx, bucket, uploaded.Key, linkExpiry); err != nil {
+			return err
+		}
+		index.Files = append(index.Files, uploaded)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %s, %w", resultsDir, err)
+	}
+
+	var page bytes.Buffer
+	if err := resultsIndexTemplate.Execute(&page, index); err != nil {
+		return fmt.Errorf("rendering the index of the results, %w", err)
+	}
+	pageKey := path.Join(index.Prefix, "index.html")
+	if err := a.putObject(ctx, bucket, pageKey, page.Bytes(), "text/html; charset=utf-8", metadata); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	// last, the retention of the run is read from it
+	if err := a.putObject(ctx, bucket, path.Join(index.Prefix, artifactsIndex), data, "application/json", metadata); err != nil {
+		return err
+	}
+	url, err := a.presignGetObject(ctx, bucket, pageKey, linkExpiry)
+	if err != nil {
+		return err
+	}
+	klog.Infof("uploaded %d results to s3://%s/%s, browse them at %s", len(index.Files), bucket, index.Prefix, url)
+	return nil
+}
+
+func (a *AWSRunner) putFile(ctx context.Context, bucket string, key string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = a.s3Service.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        f,
+		ContentType: aws.String(contentType(file)),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %s, %w", file, err)
+	}
+	return nil
+}
+
+func (a *AWSRunner) putObject(ctx context.Context, bucket string, key string, data []byte, contentType string,
+	metadata map[string]string) error {
+	_, err := a.s3Service.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("uploading s3://%s/%s, %w", bucket, key, err)
+	}
+	return nil
+}
+
+func (a *AWSRunner) presignGetObject(ctx context.Context, bucket string, key string, expires time.Duration) (string, error) {
+	request, err := s3.NewPresignClient(a.s3Service).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("presigning s3://%s/%s, %w", bucket, key, err)
+	}
+	return request.URL, nil
+}
+
+// deleteExpiredRuns deletes the runs under the prefix of the bucket whose index expired
+func (a *AWSRunner) deleteExpiredRuns(ctx context.Context, bucket string, prefix string) error {
+	if prefix != "" {
+		prefix += "/"
+	}
+	paginator := s3.NewListObjectsV2Paginator(a.s3Service, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	var runs []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing s3://%s/%s, %w", bucket, prefix, err)
+		}
+		for _, p := range page.CommonPrefixes {
+			runs = append(runs, aws.ToString(p.Prefix))
+		}
+	}
+	for _, run := range runs {
+		head, err := a.s3Service.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(run + artifactsIndex),
+		})
+		if err != nil {
+			// not a run, or one still uploading
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, head.Metadata[artifactsExpiresAtKey])
+		if err != nil || time.Now().Before(expiresAt) {
+			continue
+		}
+		klog.Infof("deleting s3://%s/%s, expired at %s", bucket, run, expiresAt)
+		if err := a.deletePrefix(ctx, bucket, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletePrefix deletes all the objects under the prefix, the index last so that an
+// interrupted deletion is retried
+func (a *AWSRunner) deletePrefix(ctx context.Context, bucket string, prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(a.s3Service, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing s3://%s/%s, %w", bucket, prefix, err)
+		}
+		var objects []s3types.ObjectIdentifier
+		for _, object := range page.Contents {
+			if aws.ToString(object.Key) != prefix+artifactsIndex {
+				objects = append(objects, s3types.ObjectIdentifier{Key: object.Key})
+			}
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		_, err = a.s3Service.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("deleting s3://%s/%s, %w", bucket, prefix, err)
+		}
+	}
+	_, err := a.s3Service.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(prefix + artifactsIndex),
+	})
+	if err != nil {
+		return fmt.Errorf("deleting s3://%s/%s%s, %w", bucket, prefix, artifactsIndex, err)
+	}
+	return nil
+}
+
+// contentType lets browsers display the logs rather than download them
+func contentType(file string) string {
+	switch ext := filepath.Ext(file); ext {
+	case ".log", ".txt", "":
+		return "text/plain; charset=utf-8"
+	default:
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+		return "application/octet-stream"
+	}
+}
+
+// remoteFlag is the value of a flag of the remote package, e.g. --results-dir
+func remoteFlag(name string) string {
+	if f := remote.CommandLine.Lookup(name); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import "testing"
+
+func TestArtifactsLocation(t *testing.T) {
+	saved := *artifactsBucket
+	t.Cleanup(func() { *artifactsBucket = saved })
+	tests := []struct {
+		flag       string
+		wantBucket string
+		wantPrefix string
+	}{
+		{flag: "artifacts", wantBucket: "artifacts"},
+		{flag: "s3://artifacts/", wantBucket: "artifacts"},
+		{flag: "s3://artifacts/ci/node-e2e/", wantBucket: "artifacts", wantPrefix: "ci/node-e2e"},
+	}
+	for _, tc := range tests {
+		*artifactsBucket = tc.flag
+		bucket, prefix := artifactsLocation()
+		if bucket != tc.wantBucket || prefix != tc.wantPrefix {
+			t.Errorf("--artifacts-bucket %s is bucket %q prefix %q, want %q and %q",
+				tc.flag, bucket, prefix, tc.wantBucket, tc.wantPrefix)
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "kubelet.log", want: "text/plain; charset=utf-8"},
+		{file: "build-log.txt", want: "text/plain; charset=utf-8"},
+		{file: "kern", want: "text/plain; charset=utf-8"},
+		{file: "junit_01.xml", want: "text/xml; charset=utf-8"},
+		{file: "cluster.json", want: "application/json"},
+		{file: "index.html", want: "text/html; charset=utf-8"},
+		{file: "results.xyzzy", want: "application/octet-stream"},
+	}
+	for _, tc := range tests {
+		if got := contentType(tc.file); got != tc.want {
+			t.Errorf("contentType(%s) = %q, want %q", tc.file, got, tc.want)
+		}
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -35,6 +36,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 
 	"github.com/google/uuid"
@@ -56,6 +58,10 @@ var instanceProfile = flag.String("instance-profile", "", "The name of the insta
 var instanceConnect = flag.Bool("ec2-instance-connect", true, "Use EC2 instance connect to generate a one time use key (aws)")
 var instanceType = flag.String("instance-type", "t3a.medium", "EC2 Instance type to use for test")
 var reuseInstances = flag.Bool("reuse-instances", false, "Reuse already running instance")
+var verifyHostKeys = flag.Bool("ssh-verify-host-keys", true, "Only accept the SSH host keys the instances print on their console, or the key of the first connection to instances which print none (aws)")
+var artifactsBucket = flag.String("artifacts-bucket", "", "S3 bucket, optionally with a prefix, --results-dir is uploaded to under <run-id>/ with an index.html of presigned links, after the tests of every instance (aws)")
+var artifactsRetention = flag.Duration("artifacts-retention", 0, "How long the results uploaded to --artifacts-bucket are kept, older runs are deleted by the next upload. 0 keeps them. (aws)")
+var runID = flag.String("run-id", defaultRunID(), "Unique identifier of the run, $PROW_JOB_ID by default (aws)")
 
 const amiIDTag = "Node-E2E-Test"
 
@@ -64,7 +70,16 @@ type AWSRunner struct {
 	ec2Service        *ec2.Client
 	ec2icService      *ec2instanceconnect.Client
 	ssmService        *ssm.Client
+	s3Service         *s3.Client
 	internalAWSImages []internalAWSImage
+
+	// knownHostsFile pins the host keys of the instances when --ssh-verify-host-keys is set
+	knownHostsFile string
+	knownHostsLock sync.Mutex
+
+	// uploadLock serializes the uploads of the results of the instances
+	uploadLock         sync.Mutex
+	expiredRunsDeleted bool
 }
 
 func NewAWSRunner(cfg remote.Config) remote.Runner {
@@ -94,6 +109,12 @@ func (a *AWSRunner) Validate() error {
 	a.ec2Service = ec2.NewFromConfig(cfg)
 	a.ec2icService = ec2instanceconnect.NewFromConfig(cfg)
 	a.ssmService = ssm.NewFromConfig(cfg)
+	a.s3Service = s3.NewFromConfig(cfg)
+	if *verifyHostKeys {
+		if err := a.pinHostKeys(); err != nil {
+			klog.Fatalf("While pinning SSH host keys: %v", err)
+		}
+	}
 	if a.internalAWSImages, err = a.prepareAWSImages(); err != nil {
 		klog.Fatalf("While preparing AWS images: %v", err)
 	}
@@ -255,6 +276,9 @@ func (a *AWSRunner) testAWSImage(suite remote.TestSuite, archivePath string, ima
 		ExtraEnvs:      a.cfg.ExtraEnvs,
 		RuntimeConfig:  a.cfg.RuntimeConfig,
 	})
+	if uploadErr := a.uploadResults(); uploadErr != nil {
+		klog.Errorf("unable to upload the results of %s: %v", instance.instanceID, uploadErr)
+	}
 	return &remote.TestResult{
 		Output: output,
 		Err:    err,
@@ -358,6 +382,11 @@ func (a *AWSRunner) getAWSInstance(img internalAWSImage) (*awsInstance, error) {
 
 		testInstance.publicIP = *instance.PublicIpAddress
 
+		if err = a.learnHostKeys(testInstance); err != nil {
+			klog.Infof("host keys err = %s", err)
+			continue
+		}
+
 		// generate a temporary SSH key and send it to the node via instance-connect
 		if *instanceConnect && !createdSSHKey {
 			klog.Info("instance-connect flag is set, using ec2 instance connect to configure a temporary SSH key")
@@ -413,9 +442,13 @@ func (a *AWSRunner) assignNewSSHKey(testInstance *awsInstance) error {
 	if err != nil {
 		return fmt.Errorf("sending SSH public key for serial console access, %w", err)
 	}
+	hostKeyCallback, err := a.hostKeyCallback()
+	if err != nil {
+		return fmt.Errorf("reading known hosts file, %w", err)
+	}
 	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", testInstance.publicIP), &ssh.ClientConfig{
 		User:            remote.GetSSHUser(),
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(key.signer),
 		},
@@ -539,6 +572,7 @@ type awsInstance struct {
 	sshKey           *temporarySSHKey
 	publicIP         string
 	sshPublicKeyFile string
+	hostKeysLearned  bool
 }
 
 type temporarySSHKey struct {
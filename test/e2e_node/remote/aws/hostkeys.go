@@ -0,0 +1,186 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/test/e2e_node/remote"
+)
+
+// cloud-init prints the host keys it generated between these markers on the console
+const (
+	hostKeysBegin = "-----BEGIN SSH HOST KEY KEYS-----"
+	hostKeysEnd   = "-----END SSH HOST KEY KEYS-----"
+)
+
+// consoleHostKeysWait is how long after its launch an instance has to print its host
+// keys on the console, past it the key it presents is trusted on first use
+const consoleHostKeysWait = 5 * time.Minute
+
+// pinHostKeys makes ssh and scp only accept the host keys recorded in a known_hosts
+// file of the runner. --ssh-options go before the options of --ssh-env, and ssh
+// keeps the first value of an option, so they win over its StrictHostKeyChecking=no.
+// accept-new records the key of an instance which printed none on its console, the
+// runner only connects to the others once their keys are pinned.
+func (a *AWSRunner) pinHostKeys() error {
+	f, err := os.CreateTemp("", ".known-hosts-*")
+	if err != nil {
+		return fmt.Errorf("creating known hosts file, %w", err)
+	}
+	f.Close()
+	a.knownHostsFile = f.Name()
+
+	options := fmt.Sprintf("-o UserKnownHostsFile=%s -o StrictHostKeyChecking=accept-new", a.knownHostsFile)
+	sshOptions := remote.CommandLine.Lookup("ssh-options")
+	if sshOptions.Value.String() != "" {
+		options += " " + sshOptions.Value.String()
+	}
+	return sshOptions.Value.Set(options)
+}
+
+// learnHostKeys records the host keys the instance printed on its console under its
+// public IP. They come from the instance itself through the EC2 API rather than over
+// the network. The console output only reaches the API a few minutes after boot,
+// instances which have not printed them by consoleHostKeysWait are trusted on first use.
+func (a *AWSRunner) learnHostKeys(testInstance *awsInstance) error {
+	if a.knownHostsFile == "" || testInstance.hostKeysLearned {
+		return nil
+	}
+	out, err := a.ec2Service.GetConsoleOutput(context.TODO(), &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(testInstance.instanceID),
+		Latest:     aws.Bool(true),
+	})
+	if err != nil && strings.Contains(err.Error(), "UnsupportedOperation") {
+		// only nitro instances have the latest output, the others the one of boot
+		out, err = a.ec2Service.GetConsoleOutput(context.TODO(), &ec2.GetConsoleOutputInput{
+			InstanceId: aws.String(testInstance.instanceID),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("getting console output, %w", err)
+	}
+	output, err := base64.StdEncoding.DecodeString(aws.ToString(out.Output))
+	if err != nil {
+		return fmt.Errorf("decoding console output, %w", err)
+	}
+	keys := parseHostKeys(string(output))
+	if len(keys) == 0 {
+		if testInstance.instance != nil && time.Since(aws.ToTime(testInstance.instance.LaunchTime)) < consoleHostKeysWait {
+			return fmt.Errorf("the console output of %s has no host keys yet", testInstance.instanceID)
+		}
+		klog.Warningf("the console output of %s has no host keys, trusting the key of its first SSH connection", testInstance.instanceID)
+		testInstance.hostKeysLearned = true
+		return nil
+	}
+
+	if err := a.addKnownHostKeys(testInstance.publicIP, keys); err != nil {
+		return err
+	}
+	testInstance.hostKeysLearned = true
+	klog.Infof("recorded %d host keys of %s/%s in %s", len(keys), testInstance.instanceID, testInstance.publicIP, a.knownHostsFile)
+	return nil
+}
+
+// addKnownHostKeys records the host keys of the host in the known hosts file
+func (a *AWSRunner) addKnownHostKeys(host string, keys []ssh.PublicKey) error {
+	a.knownHostsLock.Lock()
+	defer a.knownHostsLock.Unlock()
+	f, err := os.OpenFile(a.knownHostsFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening known hosts file, %w", err)
+	}
+	defer f.Close()
+	for _, key := range keys {
+		if _, err := fmt.Fprintln(f, knownhosts.Line([]string{host}, key)); err != nil {
+			return fmt.Errorf("writing known hosts file, %w", err)
+		}
+	}
+	return nil
+}
+
+// hostKeyCallback verifies host keys against the known hosts file, if they are pinned.
+// Like StrictHostKeyChecking=accept-new, it records the key of a host without any.
+func (a *AWSRunner) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if a.knownHostsFile == "" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	a.knownHostsLock.Lock()
+	callback, err := knownhosts.New(a.knownHostsFile)
+	a.knownHostsLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return func(address string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(address, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			host, _, splitErr := net.SplitHostPort(address)
+			if splitErr != nil {
+				return splitErr
+			}
+			klog.Warningf("trusting the %s host key %s of %s on first use", key.Type(), ssh.FingerprintSHA256(key), host)
+			return a.addKnownHostKeys(host, []ssh.PublicKey{key})
+		}
+		return err
+	}, nil
+}
+
+// parseHostKeys parses the last block of host keys in the console output
+func parseHostKeys(output string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	inBlock := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, hostKeysBegin):
+			// the instance may have rebooted, the keys of the last boot win
+			keys, inBlock = nil, true
+		case strings.Contains(line, hostKeysEnd):
+			inBlock = false
+		case inBlock:
+			// lines may be prefixed by the kernel or cloud-init, the key starts at its type
+			fields := strings.Fields(line)
+			for i, field := range fields {
+				if !strings.HasPrefix(field, "ssh-") && !strings.HasPrefix(field, "ecdsa-") {
+					continue
+				}
+				key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " ")))
+				if err == nil {
+					keys = append(keys, key)
+				}
+				break
+			}
+		}
+	}
+	return keys
+}
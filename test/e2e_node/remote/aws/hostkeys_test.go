@@ -0,0 +1,172 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"k8s.io/kubernetes/test/e2e_node/remote"
+)
+
+func newTestHostKey(t *testing.T) (ssh.PublicKey, string) {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " root@ip-10-0-0-1"
+}
+
+func TestParseHostKeys(t *testing.T) {
+	key, line := newTestHostKey(t)
+	rebootKey, rebootLine := newTestHostKey(t)
+	tests := []struct {
+		name   string
+		output string
+		want   []ssh.PublicKey
+	}{
+		{
+			name:   "not printed yet",
+			output: "[    0.000000] Linux version 6.1.0\n",
+		},
+		{
+			name: "prefixed lines",
+			output: "[   42.1] cloud-init[1234]: " + hostKeysBegin + "\n" +
+				"[   42.1] cloud-init[1234]: " + line + "\n" +
+				"[   42.1] cloud-init[1234]: " + hostKeysEnd + "\n",
+			want: []ssh.PublicKey{key},
+		},
+		{
+			name: "rebooted",
+			output: hostKeysBegin + "\n" + line + "\n" + hostKeysEnd + "\n" +
+				hostKeysBegin + "\n" + rebootLine + "\n" + hostKeysEnd + "\n",
+			want: []ssh.PublicKey{rebootKey},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseHostKeys(tc.output)
+			if len(got) != len(tc.want) {
+				t.Fatalf("parseHostKeys() returned %d keys, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if ssh.FingerprintSHA256(got[i]) != ssh.FingerprintSHA256(tc.want[i]) {
+					t.Errorf("key %d is %s, want %s", i, ssh.FingerprintSHA256(got[i]), ssh.FingerprintSHA256(tc.want[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestPinHostKeys(t *testing.T) {
+	sshOptions := remote.CommandLine.Lookup("ssh-options")
+	saved := sshOptions.Value.String()
+	t.Cleanup(func() { sshOptions.Value.Set(saved) })
+	if err := sshOptions.Value.Set("-o ServerAliveInterval=30"); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &AWSRunner{}
+	if err := a.pinHostKeys(); err != nil {
+		t.Fatalf("pinHostKeys() returned %v", err)
+	}
+	t.Cleanup(func() { os.Remove(a.knownHostsFile) })
+	want := fmt.Sprintf("-o UserKnownHostsFile=%s -o StrictHostKeyChecking=accept-new -o ServerAliveInterval=30", a.knownHostsFile)
+	if got := sshOptions.Value.String(); got != want {
+		t.Errorf("--ssh-options is %q, want %q", got, want)
+	}
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	pinned, _ := newTestHostKey(t)
+	other, _ := newTestHostKey(t)
+	f, err := os.CreateTemp(t.TempDir(), ".known-hosts-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, knownhosts.Line([]string{"10.0.0.1"}, pinned))
+	f.Close()
+
+	tests := []struct {
+		name           string
+		knownHostsFile string
+		address        string
+		key            ssh.PublicKey
+		wantErr        bool
+	}{
+		{
+			name:    "not pinned",
+			address: "10.0.0.1:22",
+			key:     other,
+		},
+		{
+			name:           "pinned key",
+			knownHostsFile: f.Name(),
+			address:        "10.0.0.1:22",
+			key:            pinned,
+		},
+		{
+			name:           "other key",
+			knownHostsFile: f.Name(),
+			address:        "10.0.0.1:22",
+			key:            other,
+			wantErr:        true,
+		},
+		{
+			name:           "unknown host",
+			knownHostsFile: f.Name(),
+			address:        "10.0.0.2:22",
+			key:            pinned,
+		},
+		{
+			// the previous case trusted the first key of 10.0.0.2
+			name:           "other key of the unknown host",
+			knownHostsFile: f.Name(),
+			address:        "10.0.0.2:22",
+			key:            other,
+			wantErr:        true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &AWSRunner{knownHostsFile: tc.knownHostsFile}
+			callback, err := a.hostKeyCallback()
+			if err != nil {
+				t.Fatalf("hostKeyCallback() returned %v", err)
+			}
+			addr, err := net.ResolveTCPAddr("tcp", tc.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := callback(tc.address, addr, tc.key); (err != nil) != tc.wantErr {
+				t.Errorf("verifying the key of %s returned %v, want error %t", tc.address, err, tc.wantErr)
+			}
+		})
+	}
+}